@@ -0,0 +1,86 @@
+package cli
+
+import (
+	"encoding/json"
+	"github.com/pipedrive/uncouch/couchdbfile"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// checkpoint records how far the file was exported
+type checkpoint struct {
+	UpdateSeq    int64 `json:"update_seq"`
+	HeaderOffset int64 `json:"header_offset"`
+}
+
+// checkpoints is content of the checkpoint file, keyed by absolute path
+// of the exported file
+type checkpoints map[string]checkpoint
+
+// readCheckpoints reads checkpoint file, missing file means nothing was
+// exported yet
+func readCheckpoints(filename string) (checkpoints, error) {
+	cps := make(checkpoints)
+	data, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return cps, nil
+	}
+	if err != nil {
+		slog.Error(err)
+		return nil, err
+	}
+	err = json.Unmarshal(data, &cps)
+	if err != nil {
+		slog.Error(err)
+		return nil, err
+	}
+	return cps, nil
+}
+
+// writeCheckpoints replaces checkpoint file, new content is written to
+// temporary file first so interrupted write does not lose the old state
+func writeCheckpoints(filename string, cps checkpoints) error {
+	data, err := json.MarshalIndent(cps, "", "  ")
+	if err != nil {
+		slog.Error(err)
+		return err
+	}
+	tmp := filename + ".tmp"
+	err = ioutil.WriteFile(tmp, data, 0644)
+	if err != nil {
+		slog.Error(err)
+		return err
+	}
+	err = os.Rename(tmp, filename)
+	if err != nil {
+		slog.Error(err)
+		return err
+	}
+	return nil
+}
+
+// checkpointKey returns key of the exported file in checkpoint file
+func checkpointKey(filename string) (string, error) {
+	key, err := filepath.Abs(filename)
+	if err != nil {
+		slog.Error(err)
+		return "", err
+	}
+	return key, nil
+}
+
+// resumeSince returns update sequence to continue reading the file from.
+// It returns false when nothing changed since the checkpoint.
+func resumeSince(cp checkpoint, cf *couchdbfile.CouchDbFile, filename string) (int64, bool) {
+	since := cp.UpdateSeq
+	if since == cf.Header.UpdateSeq && cp.HeaderOffset == cf.HeaderOffset() {
+		slog.Infof("No changes in %s since update sequence %d", filename, since)
+		return since, false
+	}
+	if since > cf.Header.UpdateSeq {
+		slog.Warnf("Checkpoint update sequence %d is ahead of %s, reading it from the beginning", since, filename)
+		since = 0
+	}
+	return since, true
+}
@@ -0,0 +1,122 @@
+package cli
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/pipedrive/uncouch/couchdbfile"
+)
+
+func TestCheckpointRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "checkpoint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "state.json")
+
+	// Missing file means nothing was exported yet
+	cps, err := readCheckpoints(filename)
+	if err != nil || len(cps) != 0 {
+		t.Fatalf("Expected empty checkpoints, got %v and %v", cps, err)
+	}
+	key, err := checkpointKey(smallFixture)
+	if err != nil || !filepath.IsAbs(key) {
+		t.Fatalf("Expected absolute key, got %s and %v", key, err)
+	}
+	cps[key] = checkpoint{UpdateSeq: 13, HeaderOffset: 8192}
+	err = writeCheckpoints(filename, cps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	read, err := readCheckpoints(filename)
+	if err != nil || !reflect.DeepEqual(read, cps) {
+		t.Errorf("Expected %v, got %v and %v", cps, read, err)
+	}
+	if _, err := os.Stat(filename + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("Expected temporary file to be renamed, got %v", err)
+	}
+
+	err = ioutil.WriteFile(filename, []byte("{"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readCheckpoints(filename); err == nil {
+		t.Error("Expected error for invalid checkpoint file")
+	}
+}
+
+func TestResumeSince(t *testing.T) {
+	data, err := ioutil.ReadFile(smallFixture)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cf, err := couchdbfile.New(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	seq, offset := cf.Header.UpdateSeq, cf.HeaderOffset()
+	tests := []struct {
+		cp      checkpoint
+		since   int64
+		changed bool
+	}{
+		{checkpoint{}, 0, true},
+		{checkpoint{UpdateSeq: 9, HeaderOffset: 0}, 9, true},
+		{checkpoint{UpdateSeq: seq, HeaderOffset: offset}, seq, false},
+		// Same sequence in another header, like after compaction
+		{checkpoint{UpdateSeq: seq, HeaderOffset: offset + 4096}, seq, true},
+		// File was replaced with an older one
+		{checkpoint{UpdateSeq: seq + 1, HeaderOffset: offset}, 0, true},
+	}
+	for _, test := range tests {
+		since, changed := resumeSince(test.cp, cf, smallFixture)
+		if since != test.since || changed != test.changed {
+			t.Errorf("Checkpoint %+v: expected %d and %v, got %d and %v", test.cp, test.since, test.changed, since, changed)
+		}
+	}
+}
+
+func TestDataCheckpoint(t *testing.T) {
+	dir, err := ioutil.TempDir("", "checkpoint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "state.json")
+
+	rows := readRows(t, runCommand(t, "data", smallFixture, "--checkpoint", filename))
+	if len(rows) != 9 {
+		t.Errorf("Expected 9 documents in the first export, got %d", len(rows))
+	}
+	// Nothing changed since the first export
+	if out := runCommand(t, "data", smallFixture, "--checkpoint", filename); out != "" {
+		t.Errorf("Expected empty second export, got %s", out)
+	}
+
+	// Export resumes after the recorded update sequence
+	key, err := checkpointKey(smallFixture)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = writeCheckpoints(filename, checkpoints{key: {UpdateSeq: 9}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows = readRows(t, runCommand(t, "data", smallFixture, "--checkpoint", filename))
+	var ids []string
+	for _, row := range rows {
+		ids = append(ids, row["_id"].(string))
+	}
+	if !reflect.DeepEqual(ids, []string{"b", "d", "e"}) {
+		t.Errorf("Expected b, d and e updated after 9, got %v", ids)
+	}
+	cps, err := readCheckpoints(filename)
+	if err != nil || cps[key].UpdateSeq != 13 {
+		t.Errorf("Expected checkpoint at 13, got %v and %v", cps, err)
+	}
+}
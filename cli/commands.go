@@ -2,59 +2,341 @@ package cli
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"fmt"
 	"github.com/pipedrive/uncouch/couchdbfile"
+	"github.com/pipedrive/uncouch/couchdir"
 	"github.com/spf13/cobra"
 	"io/ioutil"
 	"os"
+	"os/signal"
 	"path"
+	"path/filepath"
 	"strings"
+	"syscall"
 )
 
-func cmdDataFunc(cmd *cobra.Command, args []string) error {
-	filename := args[0]
+// documentLine builds output line of the document with its metadata
+// fields and body fields on the same level
+func documentLine(doc *couchdbfile.CouchDbDocument, dbName string, revs bool) map[string]interface{} {
+	line := documentMeta(doc, dbName, revs)
+	for k, v := range doc.Value {
+		line[k] = v
+	}
+	return line
+}
+
+// documentMeta returns metadata fields of the document output line
+func documentMeta(doc *couchdbfile.CouchDbDocument, dbName string, revs bool) map[string]interface{} {
+	line := map[string]interface{}{
+		"_id":      doc.Id,
+		"_rev":     doc.Rev,
+		"_db":      dbName,
+		"_deleted": doc.Deleted,
+		"_seq":     doc.Seq,
+	}
+	if len(doc.Conflicts) > 0 {
+		line["_conflicts"] = doc.Conflicts
+	}
+	if revs && len(doc.Revisions.IDs) > 0 {
+		line["_revisions"] = doc.Revisions
+	}
+	if len(doc.Attachments) > 0 {
+		stubs := make(map[string]interface{}, len(doc.Attachments))
+		for _, a := range doc.Attachments {
+			stubs[a.Name] = a.Stub()
+		}
+		line["_attachments"] = stubs
+	}
+	return line
+}
 
-	// open file for reading
+func cmdGetFunc(cmd *cobra.Command, args []string) error {
+	filename := args[0]
+	docID := args[1]
 	f, err := os.Open(filename)
 	if err != nil {
 		slog.Error(err)
 		return err
 	}
 	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		slog.Error(err)
+		return err
+	}
+	conflicts, err := cmd.Flags().GetBool("conflicts")
+	if err != nil {
+		slog.Error(err)
+		return err
+	}
+	allLeaves, err := cmd.Flags().GetBool("all-leaves")
+	if err != nil {
+		slog.Error(err)
+		return err
+	}
+	revs, err := cmd.Flags().GetBool("revs")
+	if err != nil {
+		slog.Error(err)
+		return err
+	}
+	cf, err := couchdbfile.New(f, fi.Size())
+	if err != nil {
+		slog.Error(err)
+		return err
+	}
+	err = selectHeader(cmd, cf)
+	if err != nil {
+		slog.Error(err)
+		return err
+	}
 
-	// get file size
+	docs, err := cf.GetDocumentRevisions(docID, couchdbfile.DocumentsOptions{
+		Conflicts: conflicts,
+		AllLeaves: allLeaves,
+	})
+	if err == couchdbfile.ErrNotFound {
+		err = fmt.Errorf("Document \"%s\" not found in %s", docID, filename)
+	}
+	if err != nil {
+		slog.Error(err)
+		return err
+	}
+	out, err := openOutput(cmd)
+	if err != nil {
+		slog.Error(err)
+		return err
+	}
+	dbName := strings.Split(path.Base(filename), ".")[0]
+	for _, doc := range docs {
+		err = out.Write(documentLine(doc, dbName, revs))
+		if err != nil {
+			slog.Error(err)
+			break
+		}
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func cmdChangesFunc(cmd *cobra.Command, args []string) error {
+	filename := args[0]
+	f, err := os.Open(filename)
+	if err != nil {
+		slog.Error(err)
+		return err
+	}
+	defer f.Close()
 	fi, err := f.Stat()
 	if err != nil {
 		slog.Error(err)
 		return err
 	}
+	since, err := cmd.Flags().GetInt64("since")
+	if err != nil {
+		slog.Error(err)
+		return err
+	}
+	limit, err := cmd.Flags().GetInt64("limit")
+	if err != nil {
+		slog.Error(err)
+		return err
+	}
+	includeDocs, err := cmd.Flags().GetBool("include-docs")
+	if err != nil {
+		slog.Error(err)
+		return err
+	}
+	cf, err := couchdbfile.New(f, fi.Size())
+	if err != nil {
+		slog.Error(err)
+		return err
+	}
+	err = selectHeader(cmd, cf)
+	if err != nil {
+		slog.Error(err)
+		return err
+	}
+
+	out, err := openOutput(cmd)
+	if err != nil {
+		slog.Error(err)
+		return err
+	}
+
+	ctx, cancel := interruptContext()
+	defer cancel()
+
+	err = writeChanges(ctx, cf, out, since, limit, includeDocs)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// writeChanges writes changes feed rows followed by the last_seq row
+func writeChanges(ctx context.Context, cf *couchdbfile.CouchDbFile, out Writer, since, limit int64, includeDocs bool) error {
+	var count int64
+	lastSeq := since
+	it := cf.Changes(ctx, since, limit)
+	for it.Next() {
+		change := it.Change()
+		row := map[string]interface{}{
+			"seq":     change.Seq,
+			"id":      change.ID,
+			"changes": change.Changes,
+		}
+		if change.Deleted {
+			row["deleted"] = true
+		}
+		if includeDocs {
+			doc, err := it.Doc()
+			if err != nil {
+				slog.Error(err)
+				return err
+			}
+			row["doc"] = changeDoc(doc)
+		}
+		err := out.Write(row)
+		if err != nil {
+			slog.Error(err)
+			return err
+		}
+		lastSeq = change.Seq
+		count++
+	}
+	if err := it.Err(); err != nil {
+		slog.Error(err)
+		return err
+	}
+	// Like continuous feed finish with the sequence to continue from
+	if (limit == 0 || count < limit) && cf.Header.UpdateSeq > lastSeq {
+		lastSeq = cf.Header.UpdateSeq
+	}
+	return out.Write(map[string]interface{}{"last_seq": lastSeq})
+}
+
+// changeDoc builds document of the changes row the way CouchDB includes it
+func changeDoc(doc *couchdbfile.CouchDbDocument) map[string]interface{} {
+	value := make(map[string]interface{}, len(doc.Value)+3)
+	for k, v := range doc.Value {
+		value[k] = v
+	}
+	value["_id"] = doc.Id
+	value["_rev"] = doc.Rev
+	if doc.Deleted != 0 {
+		value["_deleted"] = true
+	}
+	if len(doc.Attachments) > 0 {
+		stubs := make(map[string]interface{}, len(doc.Attachments))
+		for _, a := range doc.Attachments {
+			stubs[a.Name] = a.Stub()
+		}
+		value["_attachments"] = stubs
+	}
+	return value
+}
 
-	// get CouchDbFile
+// clusterShard is shard range of the database in cluster command output
+type clusterShard struct {
+	Range   string   `json:"range"`
+	Nodes   []string `json:"nodes"`
+	Path    string   `json:"path"`
+	Present *bool    `json:"present,omitempty"`
+}
+
+func cmdClusterFunc(cmd *cobra.Command, args []string) error {
+	filename := args[0]
+	dataDir, err := cmd.Flags().GetString("data-dir")
+	if err != nil {
+		slog.Error(err)
+		return err
+	}
+	node, err := cmd.Flags().GetString("node")
+	if err != nil {
+		slog.Error(err)
+		return err
+	}
+	f, err := os.Open(filename)
+	if err != nil {
+		slog.Error(err)
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		slog.Error(err)
+		return err
+	}
 	cf, err := couchdbfile.New(f, fi.Size())
 	if err != nil {
 		slog.Error(err)
 		return err
 	}
 
-	// read JSON from CouchDbFile and send to jsonLines channel
-	couchDbDocuments := cf.ReadOffset(cf.Header.SeqTreeState.Offset, []couchdbfile.CouchDbDocument{})
+	ctx, cancel := interruptContext()
+	defer cancel()
 
-	// read from the channel and print the results
-	dbName := strings.Split(path.Base(filename), ".")[0]
-	for _, doc := range couchDbDocuments {
-		line := map[string]interface{}{
-			"_id":      doc.Id,
-			"_db":      dbName,
-			"_deleted": doc.Deleted,
+	maps, err := couchdir.ReadShardMaps(ctx, cf)
+	if err != nil {
+		slog.Error(err)
+		return err
+	}
+	out, err := openOutput(cmd)
+	if err != nil {
+		slog.Error(err)
+		return err
+	}
+	missing := 0
+	for _, m := range maps {
+		// Ranges expected on the checked node, all of them without --node
+		expected := make(map[string]bool)
+		for _, r := range m.ByNode[node] {
+			expected[r] = true
 		}
-		for k, v := range doc.Value {
-			line[k] = v
+		shards := make([]clusterShard, 0, len(m.ByRange))
+		for _, r := range m.Ranges() {
+			shard := clusterShard{
+				Range: r,
+				Nodes: m.ByRange[r],
+				Path:  m.ShardPath(r),
+			}
+			if dataDir != "" && (node == "" || expected[r]) {
+				_, err := os.Stat(filepath.Join(dataDir, shard.Path))
+				present := err == nil
+				if !present {
+					slog.Warnf("Shard file %s is missing", filepath.Join(dataDir, shard.Path))
+					missing++
+				}
+				shard.Present = &present
+			}
+			shards = append(shards, shard)
+		}
+		var s []byte
+		s, err = json.Marshal(struct {
+			Db     string         `json:"db"`
+			Suffix string         `json:"suffix"`
+			Shards []clusterShard `json:"shards"`
+		}{m.DbName, m.Suffix, shards})
+		if err == nil {
+			err = writeJSON(out, s)
 		}
-		s, err := json.Marshal(line)
 		if err != nil {
 			slog.Error(err)
+			break
 		}
-		log.Info(string(s))
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if missing > 0 {
+		return fmt.Errorf("Found %d missing shard files in %s", missing, dataDir)
 	}
 	return nil
 }
@@ -91,3 +373,229 @@ func cmdHeadersFunc(cmd *cobra.Command, args []string) error {
 
 	return writeHeaders(cf, outputdir)
 }
+
+func cmdAttachmentsFunc(cmd *cobra.Command, args []string) error {
+	outputdir := args[1]
+	_, err := os.Stat(outputdir)
+	if err != nil {
+		return err
+	}
+	filename := args[0]
+	f, err := os.Open(filename)
+	if err != nil {
+		slog.Error(err)
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		slog.Error(err)
+		return err
+	}
+	cf, err := couchdbfile.New(f, fi.Size())
+	if err != nil {
+		slog.Error(err)
+		return err
+	}
+
+	ctx, cancel := interruptContext()
+	defer cancel()
+
+	dbName := strings.Split(path.Base(filename), ".")[0]
+	return writeAttachments(ctx, cf, path.Join(outputdir, dbName))
+}
+
+func cmdLocalFunc(cmd *cobra.Command, args []string) error {
+	filename := args[0]
+	f, err := os.Open(filename)
+	if err != nil {
+		slog.Error(err)
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		slog.Error(err)
+		return err
+	}
+	cf, err := couchdbfile.New(f, fi.Size())
+	if err != nil {
+		slog.Error(err)
+		return err
+	}
+
+	out, err := openOutput(cmd)
+	if err != nil {
+		slog.Error(err)
+		return err
+	}
+
+	ctx, cancel := interruptContext()
+	defer cancel()
+
+	dbName := strings.Split(path.Base(filename), ".")[0]
+	it := cf.LocalDocuments(ctx)
+	for it.Next() {
+		doc := it.Doc()
+		line := map[string]interface{}{
+			"_id":  doc.Id,
+			"_rev": doc.Rev,
+			"_db":  dbName,
+		}
+		for k, v := range doc.Value {
+			line[k] = v
+		}
+		err = out.Write(line)
+		if err != nil {
+			slog.Error(err)
+			break
+		}
+	}
+	if err == nil {
+		err = it.Err()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func cmdInfoFunc(cmd *cobra.Command, args []string) error {
+	filename := args[0]
+	f, err := os.Open(filename)
+	if err != nil {
+		slog.Error(err)
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		slog.Error(err)
+		return err
+	}
+	cf, err := couchdbfile.New(f, fi.Size())
+	if err != nil {
+		slog.Error(err)
+		return err
+	}
+	all, err := cmd.Flags().GetBool("all")
+	if err != nil {
+		slog.Error(err)
+		return err
+	}
+	out, err := openOutput(cmd)
+	if err != nil {
+		slog.Error(err)
+		return err
+	}
+	if all {
+		var writeErr error
+		err = cf.WalkHeaders(func(offset int64, header *couchdbfile.DbHeader) bool {
+			var s []byte
+			s, writeErr = json.Marshal(struct {
+				Offset int64 `json:"offset"`
+				*couchdbfile.DbHeader
+			}{offset, header})
+			if writeErr == nil {
+				writeErr = writeJSON(out, s)
+			}
+			return writeErr == nil
+		})
+		if err == nil {
+			err = writeErr
+		}
+	} else {
+		var s []byte
+		s, err = json.MarshalIndent(cf.Header, "", "  ")
+		if err == nil {
+			err = writeJSON(out, s)
+		}
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// selectHeader switches CouchDbFile to older DB header if requested by
+// --header-offset or --as-of-seq flags
+func selectHeader(cmd *cobra.Command, cf *couchdbfile.CouchDbFile) error {
+	headerOffset, err := cmd.Flags().GetInt64("header-offset")
+	if err != nil {
+		slog.Error(err)
+		return err
+	}
+	asOfSeq, err := cmd.Flags().GetInt64("as-of-seq")
+	if err != nil {
+		slog.Error(err)
+		return err
+	}
+	if headerOffset >= 0 && asOfSeq >= 0 {
+		err := fmt.Errorf("Flags --header-offset and --as-of-seq can not be used together")
+		slog.Error(err)
+		return err
+	}
+	if headerOffset >= 0 {
+		return cf.UseHeader(headerOffset)
+	}
+	if asOfSeq >= 0 {
+		return cf.UseHeaderAsOfSeq(asOfSeq)
+	}
+	return nil
+}
+
+func cmdVerifyFunc(cmd *cobra.Command, args []string) error {
+	filename := args[0]
+	f, err := os.Open(filename)
+	if err != nil {
+		slog.Error(err)
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		slog.Error(err)
+		return err
+	}
+	cf, err := couchdbfile.NewWithOptions(f, fi.Size(), couchdbfile.Options{
+		Verify:        true,
+		SkipCorrupted: true,
+	})
+	if err != nil {
+		slog.Error(err)
+		return err
+	}
+
+	ctx, cancel := interruptContext()
+	defer cancel()
+
+	corrupted, err := cf.Verify(ctx)
+	if err != nil {
+		slog.Error(err)
+		return err
+	}
+	for _, c := range corrupted {
+		slog.Errorf("Corrupted block at offset %d", c.Offset)
+	}
+	if len(corrupted) > 0 {
+		return fmt.Errorf("Found %d corrupted blocks in %s", len(corrupted), filename)
+	}
+	slog.Infof("No corrupted blocks found in %s", filename)
+	return nil
+}
+
+// interruptContext returns context which is cancelled on interrupt signal
+func interruptContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		select {
+		case <-signals:
+			cancel()
+		case <-ctx.Done():
+		}
+		signal.Stop(signals)
+	}()
+	return ctx, cancel
+}
@@ -0,0 +1,132 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestInfo(t *testing.T) {
+	var header map[string]interface{}
+	err := json.Unmarshal([]byte(runCommand(t, "info", smallFixture)), &header)
+	if err != nil || header["update_seq"] != 13.0 {
+		t.Errorf("Expected header with update sequence 13, got %v and %v", header, err)
+	}
+
+	rows := readRows(t, runCommand(t, "info", smallFixture, "--all"))
+	var offsets []string
+	for _, row := range rows {
+		offsets = append(offsets, fmt.Sprint(row["offset"]))
+	}
+	if strings.Join(offsets, ",") != "8192,4096,0" {
+		t.Errorf("Expected headers at 8192, 4096 and 0, got %v", offsets)
+	}
+	out := runCommand(t, "info", smallFixture, "--all", "--format", "csv", "--columns", "offset,update_seq")
+	if want := "offset,update_seq\n8192,13\n4096,8\n0,0\n"; out != want {
+		t.Errorf("Expected %q, got %q", want, out)
+	}
+
+	// Output file gets the data, nothing goes to stdout
+	dir, err := ioutil.TempDir("", "info")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "info.json")
+	if out := runCommand(t, "info", smallFixture, "-o", filename); out != "" {
+		t.Errorf("Expected nothing on stdout, got %s", out)
+	}
+	data, err := ioutil.ReadFile(filename)
+	if err != nil || string(data) != runCommand(t, "info", smallFixture) {
+		t.Errorf("Expected header in output file, got %s and %v", data, err)
+	}
+}
+
+func TestCluster(t *testing.T) {
+	const dbsFixture = "../testdata/dbs.couch"
+	// Deleted database has no row, databases are ordered by name
+	out := runCommand(t, "cluster", dbsFixture, "--format", "csv", "--columns", "db,suffix")
+	if want := "db,suffix\nacct/12,.1600000001\nmydb,.1600000000\nother,.1600000002\n"; out != want {
+		t.Errorf("Expected %q, got %q", want, out)
+	}
+
+	dir, err := ioutil.TempDir("", "cluster")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	present := filepath.Join(dir, "shards", "80000000-ffffffff", "mydb.1600000000.couch")
+	err = os.MkdirAll(filepath.Dir(present), 0755)
+	if err == nil {
+		err = ioutil.WriteFile(present, nil, 0644)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Rows are written to the output file before missing shards fail the command
+	filename := filepath.Join(dir, "cluster.json")
+	var buf bytes.Buffer
+	cmd := newRootCommand()
+	cmd.SetArgs([]string{"cluster", dbsFixture, "--data-dir", dir, "--node", "node2@127.0.0.1", "-o", filename})
+	cmd.SetOut(&buf)
+	if err := cmd.Execute(); err == nil || !strings.Contains(err.Error(), "Found 1 missing shard files") {
+		t.Errorf("Expected one missing shard file, got %v", err)
+	}
+	// Cobra prints the error and usage to the same writer
+	if strings.Contains(buf.String(), `"db"`) {
+		t.Errorf("Expected no rows on stdout, got %s", buf.String())
+	}
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, row := range readRows(t, string(data)) {
+		for _, shard := range row["shards"].([]interface{}) {
+			s := shard.(map[string]interface{})
+			got = append(got, fmt.Sprint(row["db"], " ", s["range"], " ", s["present"]))
+		}
+	}
+	// Only shards held by the node are checked
+	want := "acct/12 00000000-7fffffff <nil>,acct/12 80000000-ffffffff <nil>," +
+		"mydb 00000000-7fffffff <nil>,mydb 80000000-ffffffff true,other 00000000-ffffffff false"
+	if strings.Join(got, ",") != want {
+		t.Errorf("Expected %s, got %s", want, strings.Join(got, ","))
+	}
+}
+
+func TestAttachments(t *testing.T) {
+	dir, err := ioutil.TempDir("", "attachments")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	runCommand(t, "attachments", smallFixture, dir)
+
+	var files []string
+	err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			rel, _ := filepath.Rel(dir, path)
+			files = append(files, fmt.Sprint(filepath.ToSlash(rel), " ", info.Size()))
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Attachment names with slashes are written to subdirectories, gzip
+	// encoded data is decoded
+	if want := "small/e/dir/data.json 210,small/e/hello.txt 240"; strings.Join(files, ",") != want {
+		t.Errorf("Expected %s, got %v", want, files)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(dir, "small", "e", "dir", "data.json"))
+	if err != nil || !strings.HasPrefix(string(data), `{"a":1}`) {
+		t.Errorf("Expected decoded attachment, got %s and %v", data, err)
+	}
+}
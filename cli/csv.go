@@ -0,0 +1,300 @@
+package cli
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"github.com/spf13/cobra"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Handling of arrays in CSV output
+const (
+	arraysJSON    = "json"
+	arraysExplode = "explode"
+)
+
+func init() {
+	RegisterWriter("csv", func(output io.Writer, cmd *cobra.Command) (Writer, error) {
+		return newCSVWriter(output, cmd, ',')
+	})
+	RegisterWriter("tsv", func(output io.Writer, cmd *cobra.Command) (Writer, error) {
+		return newCSVWriter(output, cmd, '\t')
+	})
+	RegisterWriterFlags(func(cmd *cobra.Command) {
+		cmd.Flags().String("columns", "", "Comma separated CSV columns, nested fields as dotted paths like address.city")
+		cmd.Flags().Int("sample", 1000, "Number of rows sampled for CSV columns or Parquet schema when --columns or --schema is not given")
+		cmd.Flags().String("arrays", arraysJSON, "CSV handling of arrays: json to write them as JSON cells, explode to write row per element")
+	})
+}
+
+// csvWriter writes rows flattened to dotted paths as CSV. Columns are given
+// or collected from the first rows, fields not in columns are dropped.
+type csvWriter struct {
+	w       *csv.Writer
+	explode bool
+	sample  int
+	columns []string
+	known   map[string]bool
+	// pending holds sampled rows until columns are known
+	pending []map[string]string
+	dropped map[string]bool
+}
+
+func newCSVWriter(output io.Writer, cmd *cobra.Command, comma rune) (Writer, error) {
+	columns, err := cmd.Flags().GetString("columns")
+	if err != nil {
+		slog.Error(err)
+		return nil, err
+	}
+	sample, err := cmd.Flags().GetInt("sample")
+	if err != nil {
+		slog.Error(err)
+		return nil, err
+	}
+	arrays, err := cmd.Flags().GetString("arrays")
+	if err != nil {
+		slog.Error(err)
+		return nil, err
+	}
+	if arrays != arraysJSON && arrays != arraysExplode {
+		err := fmt.Errorf("Unknown --arrays value \"%s\", expecting %s or %s", arrays, arraysJSON, arraysExplode)
+		slog.Error(err)
+		return nil, err
+	}
+	cw := &csvWriter{
+		w:       csv.NewWriter(output),
+		explode: arrays == arraysExplode,
+		sample:  sample,
+		dropped: make(map[string]bool),
+	}
+	cw.w.Comma = comma
+	if columns != "" {
+		for _, c := range strings.Split(columns, ",") {
+			cw.columns = append(cw.columns, strings.TrimSpace(c))
+		}
+		err = cw.writeHeader()
+		if err != nil {
+			slog.Error(err)
+			return nil, err
+		}
+	}
+	return cw, nil
+}
+
+// Write implements Writer
+func (cw *csvWriter) Write(row map[string]interface{}) error {
+	var rows []map[string]string
+	if cw.explode {
+		rows = explodeRow(row)
+	} else {
+		flat := make(map[string]string)
+		flattenValue("", row, flat)
+		rows = []map[string]string{flat}
+	}
+	if cw.columns == nil {
+		cw.pending = append(cw.pending, rows...)
+		if len(cw.pending) < cw.sample {
+			return nil
+		}
+		return cw.writePending()
+	}
+	for _, r := range rows {
+		err := cw.writeRow(r)
+		if err != nil {
+			slog.Error(err)
+			return err
+		}
+	}
+	return nil
+}
+
+// writePending picks columns from sampled rows and writes them out
+func (cw *csvWriter) writePending() error {
+	seen := make(map[string]bool)
+	for _, r := range cw.pending {
+		for k := range r {
+			if !seen[k] {
+				seen[k] = true
+				cw.columns = append(cw.columns, k)
+			}
+		}
+	}
+	sort.Slice(cw.columns, func(i, j int) bool {
+		return columnLess(cw.columns[i], cw.columns[j])
+	})
+	if cw.columns == nil {
+		cw.columns = []string{}
+	}
+	err := cw.writeHeader()
+	if err != nil {
+		slog.Error(err)
+		return err
+	}
+	for _, r := range cw.pending {
+		err = cw.writeRow(r)
+		if err != nil {
+			slog.Error(err)
+			return err
+		}
+	}
+	cw.pending = nil
+	return nil
+}
+
+// metadataColumns are put first in this order when columns are sampled
+var metadataColumns = map[string]int{"_id": 1, "_rev": 2, "_deleted": 3, "_db": 4, "_seq": 5, "_shard": 6}
+
+// columnLess orders sampled columns: metadata fields first, then the
+// remaining fields starting with underscore and body fields last
+func columnLess(a, b string) bool {
+	ma, mb := metadataColumns[a], metadataColumns[b]
+	if ma == 0 && strings.HasPrefix(a, "_") {
+		ma = len(metadataColumns) + 1
+	}
+	if mb == 0 && strings.HasPrefix(b, "_") {
+		mb = len(metadataColumns) + 1
+	}
+	if ma == 0 {
+		ma = len(metadataColumns) + 2
+	}
+	if mb == 0 {
+		mb = len(metadataColumns) + 2
+	}
+	if ma != mb {
+		return ma < mb
+	}
+	return a < b
+}
+
+// writeHeader writes header row once columns are known
+func (cw *csvWriter) writeHeader() error {
+	cw.known = make(map[string]bool, len(cw.columns))
+	for _, c := range cw.columns {
+		cw.known[c] = true
+	}
+	return cw.w.Write(cw.columns)
+}
+
+// writeRow writes cells of the columns, fields outside columns are dropped
+func (cw *csvWriter) writeRow(r map[string]string) error {
+	for k := range r {
+		if !cw.known[k] && !cw.dropped[k] {
+			cw.dropped[k] = true
+			slog.Warnf("Field %s is not in CSV columns, dropping it", k)
+		}
+	}
+	record := make([]string, len(cw.columns))
+	for i, c := range cw.columns {
+		record[i] = r[c]
+	}
+	return cw.w.Write(record)
+}
+
+// Close implements Writer
+func (cw *csvWriter) Close() error {
+	if cw.columns == nil {
+		err := cw.writePending()
+		if err != nil {
+			slog.Error(err)
+			return err
+		}
+	}
+	cw.w.Flush()
+	return cw.w.Error()
+}
+
+// flattenValue flattens value into cells keyed by dotted path. Arrays and
+// empty objects are written as JSON.
+func flattenValue(path string, v interface{}, flat map[string]string) {
+	if obj, ok := v.(map[string]interface{}); ok && (len(obj) > 0 || path == "") {
+		for k, child := range obj {
+			flattenValue(joinPath(path, k), child, flat)
+		}
+		return
+	}
+	flat[path] = csvCell(v)
+}
+
+// explodeRow flattens row writing one row per array element. Several
+// arrays in the row give every combination of their elements.
+func explodeRow(row map[string]interface{}) []map[string]string {
+	rows := []map[string]string{make(map[string]string)}
+	return explodeValue("", row, rows)
+}
+
+// explodeValue adds value at path to every row, array elements multiply rows
+func explodeValue(path string, v interface{}, rows []map[string]string) []map[string]string {
+	switch value := v.(type) {
+	case map[string]interface{}:
+		if len(value) == 0 && path != "" {
+			break
+		}
+		keys := make([]string, 0, len(value))
+		for k := range value {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			rows = explodeValue(joinPath(path, k), value[k], rows)
+		}
+		return rows
+	case []interface{}:
+		if len(value) == 0 {
+			break
+		}
+		var exploded []map[string]string
+		for _, element := range value {
+			copies := make([]map[string]string, len(rows))
+			for i, r := range rows {
+				copies[i] = make(map[string]string, len(r))
+				for k, cell := range r {
+					copies[i][k] = cell
+				}
+			}
+			exploded = append(exploded, explodeValue(path, element, copies)...)
+		}
+		return exploded
+	}
+	for _, r := range rows {
+		r[path] = csvCell(v)
+	}
+	return rows
+}
+
+// joinPath appends key to dotted path
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// csvCell converts scalar value to cell text. Null is empty cell, numbers
+// are written without exponent and other values as JSON.
+func csvCell(v interface{}) string {
+	switch value := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return value
+	case bool:
+		return strconv.FormatBool(value)
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	case int8:
+		return strconv.FormatInt(int64(value), 10)
+	case int64:
+		return strconv.FormatInt(value, 10)
+	default:
+		s, err := json.Marshal(value)
+		if err != nil {
+			slog.Error(err)
+			return ""
+		}
+		return string(s)
+	}
+}
@@ -0,0 +1,117 @@
+package cli
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/spf13/cobra"
+)
+
+// writeCSV writes rows through CSV writer created with given flags
+func writeCSV(t *testing.T, comma rune, rows []map[string]interface{}, flags ...string) string {
+	cmd := &cobra.Command{}
+	addOutputFlags(cmd)
+	err := cmd.Flags().Parse(flags)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	cw, err := newCSVWriter(&buf, cmd, comma)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, row := range rows {
+		err = cw.Write(row)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = cw.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+func TestCSVWriter(t *testing.T) {
+	rows := []map[string]interface{}{
+		{
+			"_id":     "a",
+			"_rev":    "1-x",
+			"name":    "Smith, \"Jr\"\nline",
+			"address": map[string]interface{}{"city": "Tallinn", "zip": nil},
+			"tags":    []interface{}{"x", "y"},
+			"empty":   map[string]interface{}{},
+			"n":       1e21,
+			"ok":      true,
+		},
+		{"_id": "b", "_rev": "1-y", "_deleted": int8(1), "extra": 2.5},
+	}
+	tests := []struct {
+		comma rune
+		flags []string
+		want  string
+	}{
+		// Sampled columns, metadata first, arrays and empty objects as JSON
+		{',', nil, "_id,_rev,_deleted,address.city,address.zip,empty,extra,n,name,ok,tags\n" +
+			"a,1-x,,Tallinn,,{},,1000000000000000000000,\"Smith, \"\"Jr\"\"\nline\",true,\"[\"\"x\"\",\"\"y\"\"]\"\n" +
+			"b,1-y,1,,,,2.5,,,,\n"},
+		{'\t', nil, "_id\t_rev\t_deleted\taddress.city\taddress.zip\tempty\textra\tn\tname\tok\ttags\n" +
+			"a\t1-x\t\tTallinn\t\t{}\t\t1000000000000000000000\t\"Smith, \"\"Jr\"\"\nline\"\ttrue\t\"[\"\"x\"\",\"\"y\"\"]\"\n" +
+			"b\t1-y\t1\t\t\t\t2.5\t\t\t\t\n"},
+		// Given columns keep their order, other fields are dropped
+		{',', []string{"--columns", "address.city, _id,missing"}, "address.city,_id,missing\nTallinn,a,\n,b,\n"},
+		// Columns picked from the first row only
+		{',', []string{"--sample", "1"}, "_id,_rev,address.city,address.zip,empty,n,name,ok,tags\n" +
+			"a,1-x,Tallinn,,{},1000000000000000000000,\"Smith, \"\"Jr\"\"\nline\",true,\"[\"\"x\"\",\"\"y\"\"]\"\n" +
+			"b,1-y,,,,,,,\n"},
+		// Row per array element
+		{',', []string{"--arrays", "explode", "--columns", "_id,tags"}, "_id,tags\na,x\na,y\nb,\n"},
+	}
+	for _, test := range tests {
+		if got := writeCSV(t, test.comma, rows, test.flags...); got != test.want {
+			t.Errorf("Flags %v: expected\n%s\ngot\n%s", test.flags, test.want, got)
+		}
+	}
+
+	// No rows still write the header
+	if got := writeCSV(t, ',', nil, "--columns", "_id"); got != "_id\n" {
+		t.Errorf("Expected header only, got %q", got)
+	}
+	if got := writeCSV(t, ',', nil); got != "\n" {
+		t.Errorf("Expected empty header, got %q", got)
+	}
+}
+
+func TestExplodeRow(t *testing.T) {
+	// Several arrays give every combination, nested arrays are flattened,
+	// empty array is a JSON cell
+	row := map[string]interface{}{
+		"_id":   "a",
+		"a":     []interface{}{1.0, []interface{}{2.0, 3.0}},
+		"b":     []interface{}{map[string]interface{}{"c": "x"}, map[string]interface{}{"c": "y", "d": true}},
+		"empty": []interface{}{},
+	}
+	var got []string
+	for _, r := range explodeRow(row) {
+		got = append(got, r["_id"]+" "+r["a"]+" "+r["b.c"]+" "+r["b.d"]+" "+r["empty"])
+	}
+	want := []string{"a 1 x  []", "a 2 x  []", "a 3 x  []", "a 1 y true []", "a 2 y true []", "a 3 y true []"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+}
+
+func TestDataCSV(t *testing.T) {
+	out := runCommand(t, "data", smallFixture, "--format", "csv", "--columns", "_id,type,value,nested.deep.x", "--prefix", "c")
+	if want := "_id,type,value,nested.deep.x\nc,deal,2000,1.5\n"; out != want {
+		t.Errorf("Expected %q, got %q", want, out)
+	}
+	out = runCommand(t, "data", smallFixture, "--format", "tsv", "--prefix", "user:")
+	if want := "_id\t_rev\t_deleted\t_db\t_seq\tn\tname\ttype\n" +
+		"user:000\t1-3e334e859879af256d3827d651b7804a\t0\tsmall\t5\t0\tUser 0\tuser\n" +
+		"user:001\t1-e4774cdda0793f86414e8b9140bb6db4\t0\tsmall\t7\t1\tUser 1\tuser\n"; out != want {
+		t.Errorf("Expected %q, got %q", want, out)
+	}
+}
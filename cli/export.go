@@ -0,0 +1,451 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/pipedrive/uncouch/couchbytes"
+	"github.com/pipedrive/uncouch/couchdbfile"
+	"github.com/pipedrive/uncouch/couchdir"
+	"github.com/pipedrive/uncouch/jsonser"
+	"github.com/pipedrive/uncouch/mango"
+	"github.com/spf13/cobra"
+	"io"
+	"os"
+	"path"
+	"strings"
+)
+
+// dataOptions are options of data export read from the command flags
+type dataOptions struct {
+	verify         bool
+	skipCorrupted  bool
+	mmap           bool
+	dedupe         bool
+	checkpointFile string
+	docs           couchdbfile.DocumentsOptions
+	// selector limits output to documents matching Mango selector
+	selector *mango.Selector
+}
+
+// readDataOptions reads data export flags and checks they can be used together
+func readDataOptions(cmd *cobra.Command) (*dataOptions, error) {
+	var (
+		newOpts dataOptions
+		err     error
+	)
+	opts := &newOpts
+	flags := cmd.Flags()
+	if opts.verify, err = flags.GetBool("verify"); err != nil {
+		slog.Error(err)
+		return nil, err
+	}
+	if opts.skipCorrupted, err = flags.GetBool("skip-corrupted"); err != nil {
+		slog.Error(err)
+		return nil, err
+	}
+	if opts.mmap, err = flags.GetBool("mmap"); err != nil {
+		slog.Error(err)
+		return nil, err
+	}
+	if opts.dedupe, err = flags.GetBool("dedupe"); err != nil {
+		slog.Error(err)
+		return nil, err
+	}
+	if opts.checkpointFile, err = flags.GetString("checkpoint"); err != nil {
+		slog.Error(err)
+		return nil, err
+	}
+	if opts.docs.Conflicts, err = flags.GetBool("conflicts"); err != nil {
+		slog.Error(err)
+		return nil, err
+	}
+	if opts.docs.AllLeaves, err = flags.GetBool("all-leaves"); err != nil {
+		slog.Error(err)
+		return nil, err
+	}
+	if opts.docs.StartKey, err = flags.GetString("start-key"); err != nil {
+		slog.Error(err)
+		return nil, err
+	}
+	if opts.docs.EndKey, err = flags.GetString("end-key"); err != nil {
+		slog.Error(err)
+		return nil, err
+	}
+	if opts.docs.Prefix, err = flags.GetString("prefix"); err != nil {
+		slog.Error(err)
+		return nil, err
+	}
+	if opts.docs.Workers, err = flags.GetInt("workers"); err != nil {
+		slog.Error(err)
+		return nil, err
+	}
+	if opts.docs.Ordered, err = flags.GetBool("ordered"); err != nil {
+		slog.Error(err)
+		return nil, err
+	}
+	selector, err := flags.GetString("selector")
+	if err != nil {
+		slog.Error(err)
+		return nil, err
+	}
+	if selector != "" {
+		opts.selector, err = mango.Parse([]byte(selector))
+		if err != nil {
+			slog.Error(err)
+			return nil, err
+		}
+	}
+	fields, err := flags.GetStringSlice("fields")
+	if err != nil {
+		slog.Error(err)
+		return nil, err
+	}
+	if len(fields) > 0 {
+		if opts.selector != nil {
+			err := fmt.Errorf("Flag --selector can not be used together with --fields")
+			slog.Error(err)
+			return nil, err
+		}
+		opts.docs.Fields = jsonser.ParseFields(fields)
+	}
+	// ID ranges are read from ID tree to skip subtrees outside of them
+	opts.docs.Tree = couchdbfile.SeqTree
+	if opts.docs.StartKey != "" || opts.docs.EndKey != "" || opts.docs.Prefix != "" {
+		opts.docs.Tree = couchdbfile.IDTree
+	}
+	if opts.docs.Tree == couchdbfile.IDTree && opts.checkpointFile != "" {
+		err := fmt.Errorf("Flag --checkpoint can not be used together with --start-key, --end-key or --prefix")
+		slog.Error(err)
+		return nil, err
+	}
+	if opts.dedupe && opts.checkpointFile != "" {
+		err := fmt.Errorf("Flag --checkpoint can not be used together with --dedupe")
+		slog.Error(err)
+		return nil, err
+	}
+	return opts, nil
+}
+
+// couchFile is opened .couch file
+type couchFile struct {
+	cf     *couchdbfile.CouchDbFile
+	f      *os.File
+	mapped *couchbytes.MappedFile
+}
+
+// openCouchFile opens .couch file for reading with export options
+func openCouchFile(filename string, opts *dataOptions) (*couchFile, error) {
+	var newFile couchFile
+	file := &newFile
+	f, err := os.Open(filename)
+	if err != nil {
+		slog.Error(err)
+		return nil, err
+	}
+	file.f = f
+	fi, err := f.Stat()
+	if err != nil {
+		slog.Error(err)
+		file.Close()
+		return nil, err
+	}
+	var input io.ReaderAt = f
+	if opts.mmap {
+		file.mapped, err = couchbytes.Mmap(f)
+		if err != nil {
+			slog.Error(err)
+			file.Close()
+			return nil, err
+		}
+		input = file.mapped
+	}
+	file.cf, err = couchdbfile.NewWithOptions(input, fi.Size(), couchdbfile.Options{
+		Verify:        opts.verify || opts.skipCorrupted,
+		SkipCorrupted: opts.skipCorrupted,
+	})
+	if err != nil {
+		slog.Error(err)
+		file.Close()
+		return nil, err
+	}
+	return file, nil
+}
+
+// Close releases the mapping and closes the file
+func (file *couchFile) Close() error {
+	if file.mapped != nil {
+		file.mapped.Close()
+	}
+	return file.f.Close()
+}
+
+func cmdDataFunc(cmd *cobra.Command, args []string) error {
+	filename := args[0]
+	opts, err := readDataOptions(cmd)
+	if err != nil {
+		slog.Error(err)
+		return err
+	}
+	fi, err := os.Stat(filename)
+	if err != nil {
+		slog.Error(err)
+		return err
+	}
+
+	var cps checkpoints
+	if opts.checkpointFile != "" {
+		cps, err = readCheckpoints(opts.checkpointFile)
+		if err != nil {
+			slog.Error(err)
+			return err
+		}
+	}
+
+	out, err := openOutput(cmd)
+	if err != nil {
+		slog.Error(err)
+		return err
+	}
+
+	ctx, cancel := interruptContext()
+	defer cancel()
+
+	err = exportData(ctx, cmd, filename, fi.IsDir(), out, opts, cps)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// exportData exports single file or all databases of the data directory
+func exportData(ctx context.Context, cmd *cobra.Command, filename string, isDir bool, out Writer, opts *dataOptions, cps checkpoints) error {
+	if !isDir {
+		shard, ok := couchdir.ParseShardPath(filename)
+		if !ok {
+			shard.DbName = strings.Split(path.Base(filename), ".")[0]
+		}
+		return exportFile(ctx, cmd, shard, out, opts, cps)
+	}
+
+	// Data directory is exported database by database
+	headerOffset, err := cmd.Flags().GetInt64("header-offset")
+	if err != nil {
+		slog.Error(err)
+		return err
+	}
+	asOfSeq, err := cmd.Flags().GetInt64("as-of-seq")
+	if err != nil {
+		slog.Error(err)
+		return err
+	}
+	if headerOffset >= 0 || asOfSeq >= 0 {
+		err := fmt.Errorf("Flags --header-offset and --as-of-seq can only be used with single file")
+		slog.Error(err)
+		return err
+	}
+	dbs, err := couchdir.Find(filename)
+	if err != nil {
+		slog.Error(err)
+		return err
+	}
+	for _, db := range dbs {
+		if opts.dedupe && len(db.Shards) > 1 {
+			err = exportDeduped(ctx, db, out, opts)
+			if err != nil {
+				slog.Error(err)
+				return err
+			}
+			continue
+		}
+		for _, shard := range db.Shards {
+			err = exportFile(ctx, cmd, shard, out, opts, cps)
+			if err != nil {
+				slog.Error(err)
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// exportFile exports documents of single file. With checkpoint file only
+// documents updated since the previous export are written.
+func exportFile(ctx context.Context, cmd *cobra.Command, shard couchdir.Shard, out Writer, opts *dataOptions, cps checkpoints) error {
+	file, err := openCouchFile(shard.Path, opts)
+	if err != nil {
+		slog.Error(err)
+		return err
+	}
+	defer file.Close()
+	cf := file.cf
+
+	err = selectHeader(cmd, cf)
+	if err != nil {
+		slog.Error(err)
+		return err
+	}
+
+	// continue from the update sequence exported last time
+	var key string
+	docsOpts := opts.docs
+	if cps != nil {
+		key, err = checkpointKey(shard.Path)
+		if err != nil {
+			slog.Error(err)
+			return err
+		}
+		since, changed := resumeSince(cps[key], cf, shard.Path)
+		if !changed {
+			return nil
+		}
+		docsOpts.Since = since
+	}
+
+	// JSON rows are encoded and matched by the workers reading documents,
+	// other formats keep state of their own and serialise rows here
+	rw, raw := rawOutput(out)
+	if raw {
+		docsOpts.Encode = func(doc *couchdbfile.CouchDbDocument) ([]byte, error) {
+			return encodeRow(doc, shard, opts.selector)
+		}
+	}
+
+	// read documents from CouchDbFile one by one and print the results
+	it := cf.Documents(ctx, docsOpts)
+	defer it.Close()
+	for it.Next() {
+		if raw {
+			err = rw.WriteRaw(it.Doc().Encoded)
+		} else {
+			err = writeDocument(out, it.Doc(), shard, opts.selector)
+		}
+		if err != nil {
+			slog.Error(err)
+			return err
+		}
+	}
+	if err := it.Err(); err != nil {
+		slog.Error(err)
+		return err
+	}
+
+	if cps != nil {
+		cps[key] = checkpoint{
+			UpdateSeq:    cf.Header.UpdateSeq,
+			HeaderOffset: cf.HeaderOffset(),
+		}
+		return writeCheckpoints(opts.checkpointFile, cps)
+	}
+	return nil
+}
+
+// exportDeduped exports documents of all shards of the database merging
+// documents present in more than one shard to the winning revision
+func exportDeduped(ctx context.Context, db couchdir.Database, out Writer, opts *dataOptions) error {
+	files := make([]*couchdbfile.CouchDbFile, 0, len(db.Shards))
+	for _, shard := range db.Shards {
+		file, err := openCouchFile(shard.Path, opts)
+		if err != nil {
+			slog.Error(err)
+			return err
+		}
+		defer file.Close()
+		files = append(files, file.cf)
+	}
+	it := couchdir.Dedupe(ctx, files, opts.docs)
+	for it.Next() {
+		err := writeDocument(out, it.Doc(), db.Shards[it.Source()], opts.selector)
+		if err != nil {
+			slog.Error(err)
+			return err
+		}
+	}
+	if err := it.Err(); err != nil {
+		slog.Error(err)
+		return err
+	}
+	return nil
+}
+
+// writeDocument writes document row with database and shard fields. With
+// selector the row is written only if it matches, metadata fields included.
+func writeDocument(out Writer, doc *couchdbfile.CouchDbDocument, shard couchdir.Shard, selector *mango.Selector) error {
+	if rw, ok := rawOutput(out); ok {
+		row, err := encodeRow(doc, shard, selector)
+		if err != nil || row == nil {
+			return err
+		}
+		return rw.WriteRaw(row)
+	}
+	line, err := documentRow(doc, shard, selector)
+	if err != nil || line == nil {
+		return err
+	}
+	return out.Write(line)
+}
+
+// documentRow returns row of the document with database and shard fields
+// or nil when it does not match the selector
+func documentRow(doc *couchdbfile.CouchDbDocument, shard couchdir.Shard, selector *mango.Selector) (map[string]interface{}, error) {
+	line := documentLine(doc, shard.DbName, false)
+	if shard.Range != "" {
+		line["_shard"] = shard.Range
+	}
+	if doc.Body != nil {
+		// Body read with --fields is decoded only for formats needing it
+		var value map[string]interface{}
+		err := json.Unmarshal(doc.Body, &value)
+		if err != nil {
+			slog.Error(err)
+			return nil, err
+		}
+		for k, v := range value {
+			line[k] = v
+		}
+	}
+	if selector != nil {
+		// Selectors test _deleted as boolean the way CouchDB shows it,
+		// output keeps the numeric flag
+		line["_deleted"] = doc.Deleted != 0
+		matched := selector.Match(line)
+		line["_deleted"] = doc.Deleted
+		if !matched {
+			return nil, nil
+		}
+	}
+	return line, nil
+}
+
+// encodeRow returns JSON row of the document or nil when it does not match
+// the selector. Body read with --fields is used as it is with metadata
+// fields spliced in front.
+func encodeRow(doc *couchdbfile.CouchDbDocument, shard couchdir.Shard, selector *mango.Selector) ([]byte, error) {
+	if doc.Body == nil {
+		line, err := documentRow(doc, shard, selector)
+		if err != nil || line == nil {
+			return nil, err
+		}
+		row, err := json.Marshal(line)
+		if err != nil {
+			slog.Error(err)
+			return nil, err
+		}
+		return row, nil
+	}
+	meta := documentMeta(doc, shard.DbName, false)
+	if shard.Range != "" {
+		meta["_shard"] = shard.Range
+	}
+	row, err := json.Marshal(meta)
+	if err != nil {
+		slog.Error(err)
+		return nil, err
+	}
+	// Both are JSON objects, body without any fields is {}
+	if len(doc.Body) > 2 {
+		row[len(row)-1] = ','
+		row = append(row, doc.Body[1:]...)
+	}
+	return row, nil
+}
@@ -0,0 +1,156 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/pipedrive/uncouch/couchdbfile"
+	"github.com/pipedrive/uncouch/couchdir"
+	"github.com/pipedrive/uncouch/mango"
+)
+
+// smallFixture has document "d" with two leaf revisions
+const smallFixture = "../testdata/small.couch"
+
+// runCommand runs uncouch with given arguments and returns what it wrote
+// to stdout
+func runCommand(t *testing.T, args ...string) string {
+	var buf bytes.Buffer
+	cmd := newRootCommand()
+	cmd.SetArgs(args)
+	cmd.SetOut(&buf)
+	err := cmd.Execute()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+// readRows parses JSON lines into rows
+func readRows(t *testing.T, lines string) []map[string]interface{} {
+	var rows []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(lines), "\n") {
+		var row map[string]interface{}
+		err := json.Unmarshal([]byte(line), &row)
+		if err != nil {
+			t.Fatalf("Line %s is not valid JSON: %v", line, err)
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+// rowsOf returns _rev and _conflicts of the rows with given ID
+func rowsOf(rows []map[string]interface{}, id string) []string {
+	var revs []string
+	for _, row := range rows {
+		if row["_id"] == id {
+			revs = append(revs, fmt.Sprint(row["_rev"], " ", row["_conflicts"]))
+		}
+	}
+	return revs
+}
+
+func TestConflictRows(t *testing.T) {
+	const (
+		winner = "2-d2a452f0ab3e372da86c88f1d11ba085"
+		loser  = "2-3ed027caea2c9a32c7329eed2dc08242"
+	)
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{nil, winner + " <nil>"},
+		{[]string{"--conflicts"}, winner + " [" + loser + "]"},
+		// Every leaf is a row of its own, only the winner gets _conflicts
+		{[]string{"--all-leaves"}, winner + " <nil>," + loser + " <nil>"},
+		{[]string{"--all-leaves", "--conflicts"}, winner + " [" + loser + "]," + loser + " <nil>"},
+	}
+	for _, test := range tests {
+		for _, command := range [][]string{{"data", smallFixture}, {"get", smallFixture, "d"}} {
+			args := append(append([]string(nil), command...), test.args...)
+			rows := readRows(t, runCommand(t, args...))
+			if got := strings.Join(rowsOf(rows, "d"), ","); got != test.want {
+				t.Errorf("%v: expected %s, got %s", args, test.want, got)
+			}
+			// Documents without conflicts have a single row and no _conflicts
+			if command[0] == "data" && len(rowsOf(rows, "c")) != 1 {
+				t.Errorf("%v: expected single row of c", args)
+			}
+			for _, row := range rows {
+				if _, ok := row["_conflicts"]; ok && row["_id"] != "d" {
+					t.Errorf("%v: unexpected _conflicts in %v", args, row)
+				}
+			}
+		}
+	}
+}
+
+func TestWriteProjected(t *testing.T) {
+	var buf bytes.Buffer
+	out, err := newJSONWriter(&buf, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	shard := couchdir.Shard{DbName: "db", Range: "00000000-7fffffff"}
+	for _, body := range []string{`{"a":1,"b":{"c":"x"}}`, `{}`} {
+		doc := &couchdbfile.CouchDbDocument{Id: "id", Rev: "1-a", Seq: 5, Body: []byte(body)}
+		err = writeDocument(out, doc, shard, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	out.Close()
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	want := []map[string]interface{}{
+		{"_id": "id", "_rev": "1-a", "_db": "db", "_deleted": 0.0, "_seq": 5.0, "_shard": "00000000-7fffffff", "a": 1.0, "b": map[string]interface{}{"c": "x"}},
+		{"_id": "id", "_rev": "1-a", "_db": "db", "_deleted": 0.0, "_seq": 5.0, "_shard": "00000000-7fffffff"},
+	}
+	if len(lines) != len(want) {
+		t.Fatalf("Expected %d lines, got %q", len(want), lines)
+	}
+	for i, line := range lines {
+		var got map[string]interface{}
+		err = json.Unmarshal([]byte(line), &got)
+		if err != nil {
+			t.Errorf("Line %s is not valid JSON: %v", line, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, want[i]) {
+			t.Errorf("Expected %v, got %v", want[i], got)
+		}
+	}
+}
+
+func TestWriteDocumentSelectorDeleted(t *testing.T) {
+	var buf bytes.Buffer
+	out, err := newJSONWriter(&buf, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	selector, err := mango.Parse([]byte(`{"_deleted":true}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, doc := range []*couchdbfile.CouchDbDocument{
+		{Id: "live", Rev: "1-a"},
+		{Id: "gone", Rev: "2-b", Deleted: 1},
+	} {
+		err = writeDocument(out, doc, couchdir.Shard{DbName: "db"}, selector)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	out.Close()
+	// Selector sees boolean, output keeps the numeric flag
+	if !strings.Contains(buf.String(), `"_id":"gone"`) || strings.Contains(buf.String(), `"_id":"live"`) {
+		t.Errorf("Expected only deleted document, got %s", buf.String())
+	}
+	if !strings.Contains(buf.String(), `"_deleted":1`) {
+		t.Errorf("Expected numeric _deleted in %s", buf.String())
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"go.uber.org/zap"
 	"os"
 	"strings"
+	"time"
 )
 
 var (
@@ -20,6 +21,15 @@ func init() {
 
 func Cli() {
 	// defer profile.Start().Stop()
+	err := newRootCommand().Execute()
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}
+
+// newRootCommand creates uncouch command with every subcommand and flag
+func newRootCommand() *cobra.Command {
 	cmdPrint := &cobra.Command{
 		Use:   "print [string to print]",
 		Short: "Print anything to the screen",
@@ -32,11 +42,90 @@ For many years people have printed back to the screen.`,
 	}
 
 	cmdData := &cobra.Command{
-		Use:   "data filename",
-		Short: "Dump .couch file data as JSON lines to stdout",
+		Use:   "data filename|datadir",
+		Short: "Dump .couch file or all databases of CouchDB data directory as JSON lines",
 		Args:  cobra.MinimumNArgs(1),
 		RunE:  cmdDataFunc,
 	}
+	cmdData.Flags().Bool("verify", false, "Verify MD5 hashes of header and document blocks")
+	cmdData.Flags().Bool("skip-corrupted", false, "Skip blocks failing verification, implies --verify")
+	cmdData.Flags().Bool("conflicts", false, "Add _conflicts array with conflicting leaf revisions")
+	cmdData.Flags().Bool("all-leaves", false, "Emit one line per leaf revision with stored body")
+	cmdData.Flags().Int64("header-offset", -1, "Export database as of DB header at given block offset")
+	cmdData.Flags().Int64("as-of-seq", -1, "Export database as of newest DB header with update sequence not above given one")
+	cmdData.Flags().String("start-key", "", "Export documents with ID not below given one, walks ID tree")
+	cmdData.Flags().String("end-key", "", "Export documents with ID not above given one, walks ID tree")
+	cmdData.Flags().String("prefix", "", "Export documents with ID starting with given prefix, walks ID tree")
+	cmdData.Flags().Int("workers", 1, "Number of workers reading nodes and decoding documents concurrently")
+	cmdData.Flags().Bool("dedupe", false, "Merge documents found in more than one shard of the database to the winning revision")
+	cmdData.Flags().Bool("mmap", false, "Read the file through read only memory mapping")
+	cmdData.Flags().Bool("ordered", false, "Keep the tree order of documents when using more than one worker")
+	cmdData.Flags().String("selector", "", "Export only documents matching CouchDB Mango selector given as JSON, like '{\"type\":\"deal\"}'")
+	cmdData.Flags().StringSlice("fields", nil, "Export only given body fields, nested ones as dotted paths like a,b.c, other fields are skipped without decoding")
+	cmdData.Flags().String("checkpoint", "", "Export only documents updated since update sequence recorded in given state file and record the new one")
+
+	cmdExport := &cobra.Command{
+		Use:   "export filename|datadir output",
+		Short: "Export .couch file or all databases of CouchDB data directory into table per database of SQLite file",
+		Args:  cobra.MinimumNArgs(2),
+		RunE:  cmdExportFunc,
+	}
+	cmdExport.Flags().String("to", exportTargetSQLite, "Export target, only sqlite is supported")
+	cmdExport.Flags().StringSlice("generated", nil, "Comma separated generated columns as name=path or path, with nested fields as dotted paths like address.city")
+	cmdExport.Flags().Int("batch-size", 1000, "Number of documents written in single transaction")
+	cmdExport.Flags().Bool("verify", false, "Verify MD5 hashes of header and document blocks")
+	cmdExport.Flags().Bool("skip-corrupted", false, "Skip blocks failing verification, implies --verify")
+	cmdExport.Flags().Bool("mmap", false, "Read the file through read only memory mapping")
+
+	cmdPush := &cobra.Command{
+		Use:   "push filename url",
+		Short: "Store every leaf revision of .couch file documents into CouchDB database at url through _bulk_docs keeping revisions as they are",
+		Args:  cobra.MinimumNArgs(2),
+		RunE:  cmdPushFunc,
+	}
+	cmdPush.Flags().Int("batch-size", 100, "Number of document revisions sent in single _bulk_docs request")
+	cmdPush.Flags().Int("retries", 5, "Number of times failed request is repeated")
+	cmdPush.Flags().Duration("backoff", time.Second, "Delay before the first retry, doubled after each one")
+	cmdPush.Flags().Duration("timeout", 5*time.Minute, "Time limit of single request, timed out request is retried")
+	cmdPush.Flags().Bool("create", false, "Create target database if it does not exist")
+	cmdPush.Flags().String("checkpoint", "", "Push only documents updated since update sequence recorded in given state file and record the new one")
+	cmdPush.Flags().Bool("verify", false, "Verify MD5 hashes of header and document blocks")
+	cmdPush.Flags().Bool("skip-corrupted", false, "Skip blocks failing verification, implies --verify")
+	cmdPush.Flags().Bool("mmap", false, "Read the file through read only memory mapping")
+
+	cmdServe := &cobra.Command{
+		Use:   "serve",
+		Short: "Serve read only CouchDB API over databases of CouchDB data directory",
+		Args:  cobra.NoArgs,
+		RunE:  cmdServeFunc,
+	}
+	cmdServe.Flags().String("dir", "", "CouchDB data directory")
+	cmdServe.Flags().String("addr", "127.0.0.1:5984", "Address to listen on")
+	cmdServe.MarkFlagRequired("dir")
+
+	cmdGet := &cobra.Command{
+		Use:   "get filename docid",
+		Short: "Look up single document by ID and print it as JSON line",
+		Args:  cobra.MinimumNArgs(2),
+		RunE:  cmdGetFunc,
+	}
+	cmdGet.Flags().Bool("conflicts", false, "Add _conflicts array with conflicting leaf revisions")
+	cmdGet.Flags().Bool("all-leaves", false, "Print one line per leaf revision with stored body")
+	cmdGet.Flags().Bool("revs", false, "Add _revisions with revision history of the printed revision")
+	cmdGet.Flags().Int64("header-offset", -1, "Look document up as of DB header at given block offset")
+	cmdGet.Flags().Int64("as-of-seq", -1, "Look document up as of newest DB header with update sequence not above given one")
+
+	cmdChanges := &cobra.Command{
+		Use:   "changes filename",
+		Short: "Print _changes feed rows of documents updated after --since as JSON lines",
+		Args:  cobra.MinimumNArgs(1),
+		RunE:  cmdChangesFunc,
+	}
+	cmdChanges.Flags().Int64("since", 0, "Start after given update sequence")
+	cmdChanges.Flags().Int64("limit", 0, "Maximum number of rows, 0 for no limit")
+	cmdChanges.Flags().Bool("include-docs", false, "Add winning revision of the document as doc")
+	cmdChanges.Flags().Int64("header-offset", -1, "Read changes as of DB header at given block offset")
+	cmdChanges.Flags().Int64("as-of-seq", -1, "Read changes as of newest DB header with update sequence not above given one")
 
 	cmdHeaders := &cobra.Command{
 		Use:   "headers filename path",
@@ -45,6 +134,51 @@ For many years people have printed back to the screen.`,
 		RunE:  cmdHeadersFunc,
 	}
 
+	cmdAttachments := &cobra.Command{
+		Use:   "attachments filename path",
+		Short: "Extract attachments of current document revisions to path/db/docid/name",
+		Args:  cobra.MinimumNArgs(2),
+		RunE:  cmdAttachmentsFunc,
+	}
+
+	cmdLocal := &cobra.Command{
+		Use:   "local filename",
+		Short: "Dump _local documents like replication checkpoints as JSON lines",
+		Args:  cobra.MinimumNArgs(1),
+		RunE:  cmdLocalFunc,
+	}
+
+	cmdInfo := &cobra.Command{
+		Use:   "info filename",
+		Short: "Print parsed DB header as JSON",
+		Args:  cobra.MinimumNArgs(1),
+		RunE:  cmdInfoFunc,
+	}
+	cmdInfo.Flags().Bool("all", false, "Print every valid DB header in the file, newest first, as JSON lines")
+
+	cmdCluster := &cobra.Command{
+		Use:   "cluster _dbs.couch",
+		Short: "Print shard ranges and their nodes of every database in the node local _dbs file as JSON lines",
+		Args:  cobra.MinimumNArgs(1),
+		RunE:  cmdClusterFunc,
+	}
+	cmdCluster.Flags().String("data-dir", "", "Check shard files are present in given data directory")
+	cmdCluster.Flags().String("node", "", "Check only shards held by given node, like node1@127.0.0.1")
+
+	cmdVerify := &cobra.Command{
+		Use:   "verify filename",
+		Short: "Verify MD5 hashes of header, Btree node, document and attachment blocks and report corrupted ones",
+		Args:  cobra.MinimumNArgs(1),
+		RunE:  cmdVerifyFunc,
+	}
+
+	addOutputFlags(cmdData)
+	addOutputFlags(cmdGet)
+	addOutputFlags(cmdChanges)
+	addOutputFlags(cmdLocal)
+	addOutputFlags(cmdInfo)
+	addOutputFlags(cmdCluster)
+
 	rootCmd := &cobra.Command{
 		Use:   "uncouch",
 		Short: "Manage Uncouch related commands",
@@ -52,11 +186,17 @@ For many years people have printed back to the screen.`,
 
 	rootCmd.AddCommand(cmdPrint)
 	rootCmd.AddCommand(cmdData)
+	rootCmd.AddCommand(cmdExport)
+	rootCmd.AddCommand(cmdPush)
+	rootCmd.AddCommand(cmdServe)
+	rootCmd.AddCommand(cmdGet)
+	rootCmd.AddCommand(cmdChanges)
 	rootCmd.AddCommand(cmdHeaders)
+	rootCmd.AddCommand(cmdAttachments)
+	rootCmd.AddCommand(cmdLocal)
+	rootCmd.AddCommand(cmdInfo)
+	rootCmd.AddCommand(cmdCluster)
+	rootCmd.AddCommand(cmdVerify)
 
-	err := rootCmd.Execute()
-	if err != nil {
-		fmt.Println(err)
-		os.Exit(1)
-	}
+	return rootCmd
 }
@@ -0,0 +1,200 @@
+package cli
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/spf13/cobra"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Writer writes exported rows in some output format. Rows are documents
+// with metadata fields like _id and _rev next to body fields.
+type Writer interface {
+	// Write writes single row
+	Write(row map[string]interface{}) error
+	// Close flushes buffered rows, it does not close the underlying output
+	Close() error
+}
+
+// rawWriter is implemented by writers which can take row already encoded
+// as JSON object
+type rawWriter interface {
+	// WriteRaw writes single row given as JSON object
+	WriteRaw(row []byte) error
+}
+
+// rawOutput returns writer taking JSON rows as they are, if the output
+// format supports it
+func rawOutput(out Writer) (rawWriter, bool) {
+	if o, ok := out.(*output); ok {
+		out = o.Writer
+	}
+	rw, ok := out.(rawWriter)
+	return rw, ok
+}
+
+// WriterFactory creates Writer writing into output. Format specific
+// options are read from the command flags.
+type WriterFactory func(output io.Writer, cmd *cobra.Command) (Writer, error)
+
+var (
+	// writerFactories holds output formats by name
+	writerFactories = make(map[string]WriterFactory)
+	// writerFlags add format specific flags to commands with output
+	writerFlags []func(cmd *cobra.Command)
+)
+
+// RegisterWriter makes output format available for --format flag
+func RegisterWriter(format string, factory WriterFactory) {
+	writerFactories[format] = factory
+}
+
+// RegisterWriterFlags adds format specific flags to every command with output
+func RegisterWriterFlags(flags func(cmd *cobra.Command)) {
+	writerFlags = append(writerFlags, flags)
+}
+
+func init() {
+	RegisterWriter("json", newJSONWriter)
+}
+
+// addOutputFlags adds flags selecting output format and destination
+func addOutputFlags(cmd *cobra.Command) {
+	formats := make([]string, 0, len(writerFactories))
+	for format := range writerFactories {
+		formats = append(formats, format)
+	}
+	sort.Strings(formats)
+	cmd.Flags().StringP("output", "o", "", "Write output to given file instead of stdout")
+	cmd.Flags().String("format", "json", "Output format: "+strings.Join(formats, ", "))
+	for _, flags := range writerFlags {
+		flags(cmd)
+	}
+}
+
+// output is Writer together with the file it writes to
+type output struct {
+	Writer
+	file *os.File
+}
+
+// Close flushes the writer and closes output file
+func (o *output) Close() error {
+	err := o.Writer.Close()
+	if o.file != nil {
+		if closeErr := o.file.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		slog.Error(err)
+	}
+	return err
+}
+
+// openOutput creates Writer selected by --format writing to --output file
+// or stdout. Data never goes through the logger which writes diagnostics
+// to stderr.
+func openOutput(cmd *cobra.Command) (*output, error) {
+	format, err := cmd.Flags().GetString("format")
+	if err != nil {
+		slog.Error(err)
+		return nil, err
+	}
+	filename, err := cmd.Flags().GetString("output")
+	if err != nil {
+		slog.Error(err)
+		return nil, err
+	}
+	factory, ok := writerFactories[format]
+	if !ok {
+		err := fmt.Errorf("Unknown output format \"%s\"", format)
+		slog.Error(err)
+		return nil, err
+	}
+	var newOutput output
+	o := &newOutput
+	w := cmd.OutOrStdout()
+	if filename != "" {
+		o.file, err = os.Create(filename)
+		if err != nil {
+			slog.Error(err)
+			return nil, err
+		}
+		w = o.file
+	}
+	o.Writer, err = factory(w, cmd)
+	if err != nil {
+		slog.Error(err)
+		if o.file != nil {
+			o.file.Close()
+		}
+		return nil, err
+	}
+	return o, nil
+}
+
+// writeJSON writes value already encoded as JSON object. Output taking
+// raw rows gets it as it is, other formats get it decoded into row.
+func writeJSON(out Writer, s []byte) error {
+	if rw, ok := rawOutput(out); ok {
+		return rw.WriteRaw(s)
+	}
+	var row map[string]interface{}
+	d := json.NewDecoder(bytes.NewReader(s))
+	d.UseNumber()
+	err := d.Decode(&row)
+	if err != nil {
+		slog.Error(err)
+		return err
+	}
+	return out.Write(row)
+}
+
+// jsonWriter writes rows as newline delimited JSON
+type jsonWriter struct {
+	w *bufio.Writer
+}
+
+func newJSONWriter(output io.Writer, cmd *cobra.Command) (Writer, error) {
+	return &jsonWriter{w: bufio.NewWriter(output)}, nil
+}
+
+// Write implements Writer
+func (jw *jsonWriter) Write(row map[string]interface{}) error {
+	s, err := json.Marshal(row)
+	if err != nil {
+		slog.Error(err)
+		return err
+	}
+	s = append(s, '\n')
+	_, err = jw.w.Write(s)
+	if err != nil {
+		slog.Error(err)
+		return err
+	}
+	return nil
+}
+
+// WriteRaw implements rawWriter
+func (jw *jsonWriter) WriteRaw(row []byte) error {
+	_, err := jw.w.Write(row)
+	if err == nil {
+		err = jw.w.WriteByte('\n')
+	}
+	if err != nil {
+		slog.Error(err)
+		return err
+	}
+	return nil
+}
+
+// Close implements Writer
+func (jw *jsonWriter) Close() error {
+	return jw.w.Flush()
+}
@@ -0,0 +1,514 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/spf13/cobra"
+	"github.com/xitongsys/parquet-go/common"
+	"github.com/xitongsys/parquet-go/writer"
+	"io"
+	"io/ioutil"
+	"math"
+	"sort"
+	"strings"
+)
+
+func init() {
+	RegisterWriter("parquet", newParquetWriter)
+	RegisterWriterFlags(func(cmd *cobra.Command) {
+		cmd.Flags().String("schema", "", "JSON Schema file with Parquet columns, inferred from sampled rows when not given")
+		cmd.Flags().Int64("row-group-size", 64, "Parquet row group size in megabytes")
+	})
+}
+
+// parquetKind is type of Parquet column
+type parquetKind int
+
+const (
+	// parquetNull column has only nulls so far and is written as string
+	parquetNull parquetKind = iota
+	parquetBool
+	parquetInt
+	parquetDouble
+	parquetString
+	// parquetJSON column holds arrays and empty objects as JSON text
+	parquetJSON
+)
+
+// parquetTypes are physical and logical types of the column kinds in
+// parquet-go schema tags
+var parquetTypes = map[parquetKind]string{
+	parquetNull:   "UTF8",
+	parquetBool:   "BOOLEAN",
+	parquetInt:    "INT64",
+	parquetDouble: "DOUBLE",
+	parquetString: "UTF8",
+	parquetJSON:   "UTF8",
+}
+
+// parquetMetadata are columns present in every Parquet file with their kinds
+var parquetMetadata = map[string]parquetKind{
+	"_id":      parquetString,
+	"_rev":     parquetString,
+	"_deleted": parquetBool,
+	"_db":      parquetString,
+	"_seq":     parquetInt,
+}
+
+// parquetColumn is column of the Parquet file holding field at dotted path
+type parquetColumn struct {
+	path string
+	kind parquetKind
+	// mismatch is set once value not matching the kind was written as null
+	mismatch bool
+}
+
+// parquetWriter writes rows flattened to dotted paths as Parquet file,
+// nested objects are written as groups.
+// Schema is read from the JSON Schema file or inferred from the first
+// rows. Rows are buffered in memory until the row group is full, so
+// memory use depends on the row group size and not on the export size.
+type parquetWriter struct {
+	output       io.Writer
+	rowGroupSize int64
+	sample       int
+	w            *writer.JSONWriter
+	columns      []*parquetColumn
+	known        map[string]*parquetColumn
+	// pending holds sampled rows until schema is known
+	pending []map[string]interface{}
+	dropped map[string]bool
+}
+
+func newParquetWriter(output io.Writer, cmd *cobra.Command) (Writer, error) {
+	schemaFile, err := cmd.Flags().GetString("schema")
+	if err != nil {
+		slog.Error(err)
+		return nil, err
+	}
+	sample, err := cmd.Flags().GetInt("sample")
+	if err != nil {
+		slog.Error(err)
+		return nil, err
+	}
+	rowGroupSize, err := cmd.Flags().GetInt64("row-group-size")
+	if err != nil {
+		slog.Error(err)
+		return nil, err
+	}
+	if rowGroupSize <= 0 {
+		err := fmt.Errorf("Flag --row-group-size must be positive, got %d", rowGroupSize)
+		slog.Error(err)
+		return nil, err
+	}
+	pw := &parquetWriter{
+		output:       output,
+		rowGroupSize: rowGroupSize * 1024 * 1024,
+		sample:       sample,
+		dropped:      make(map[string]bool),
+	}
+	if schemaFile != "" {
+		kinds, err := readJSONSchema(schemaFile)
+		if err != nil {
+			slog.Error(err)
+			return nil, err
+		}
+		err = pw.start(kinds)
+		if err != nil {
+			slog.Error(err)
+			return nil, err
+		}
+	}
+	return pw, nil
+}
+
+// Write implements Writer
+func (pw *parquetWriter) Write(row map[string]interface{}) error {
+	flat := make(map[string]interface{}, len(row))
+	flattenFields("", row, flat)
+	// Deleted flag of the document is kept as integer in the rows
+	if deleted, ok := flat["_deleted"].(int8); ok {
+		flat["_deleted"] = deleted != 0
+	}
+	if pw.w == nil {
+		pw.pending = append(pw.pending, flat)
+		if len(pw.pending) < pw.sample {
+			return nil
+		}
+		return pw.writePending()
+	}
+	return pw.writeRow(flat)
+}
+
+// writePending infers schema from sampled rows and writes them out
+func (pw *parquetWriter) writePending() error {
+	kinds := make(map[string]parquetKind)
+	conflicts := make(map[string]bool)
+	for _, r := range pw.pending {
+		for path, v := range r {
+			kind, seen := kinds[path]
+			if !seen {
+				kinds[path] = valueKind(v)
+				continue
+			}
+			vk := valueKind(v)
+			merged := mergeKinds(kind, vk)
+			if merged == parquetString && kind != vk && kind != parquetNull && vk != parquetNull && !conflicts[path] {
+				conflicts[path] = true
+				slog.Warnf("Field %s has conflicting types, writing it as string", path)
+			}
+			kinds[path] = merged
+		}
+	}
+	err := pw.start(kinds)
+	if err != nil {
+		slog.Error(err)
+		return err
+	}
+	for _, r := range pw.pending {
+		err = pw.writeRow(r)
+		if err != nil {
+			slog.Error(err)
+			return err
+		}
+	}
+	pw.pending = nil
+	return nil
+}
+
+// start creates Parquet writer with columns of given kinds and the
+// metadata columns
+func (pw *parquetWriter) start(kinds map[string]parquetKind) error {
+	for path, kind := range parquetMetadata {
+		kinds[path] = kind
+	}
+	paths := make([]string, 0, len(kinds))
+	for path := range kinds {
+		paths = append(paths, path)
+	}
+	sort.Slice(paths, func(i, j int) bool {
+		return columnLess(paths[i], paths[j])
+	})
+
+	root := &parquetField{Tag: "name=uncouch, repetitiontype=REQUIRED"}
+	groups := map[string]*parquetField{"": root}
+	// parquet-go maps names to Go identifiers, which have to be unique
+	// among fields of the same group
+	names := make(map[string]map[string]string)
+	pw.known = make(map[string]*parquetColumn, len(paths))
+	for _, path := range paths {
+		segments := strings.Split(path, ".")
+		if reason := pw.checkColumn(path, segments, groups, names); reason != "" {
+			slog.Warnf("Field %s %s, dropping it", path, reason)
+			continue
+		}
+		// Nested objects become groups, as parquet-go splits column
+		// paths on dots
+		parent := root
+		prefix := ""
+		for i, segment := range segments {
+			groupNames := names[prefix]
+			if groupNames == nil {
+				groupNames = make(map[string]string)
+				names[prefix] = groupNames
+			}
+			groupNames[common.StringToVariableName(segment)] = segment
+			prefix = joinPath(prefix, segment)
+			if i == len(segments)-1 {
+				break
+			}
+			group, ok := groups[prefix]
+			if !ok {
+				group = &parquetField{Tag: fmt.Sprintf("name=%s, repetitiontype=OPTIONAL", segment)}
+				groups[prefix] = group
+				parent.Fields = append(parent.Fields, group)
+			}
+			parent = group
+		}
+		column := &parquetColumn{path: path, kind: kinds[path]}
+		pw.columns = append(pw.columns, column)
+		pw.known[path] = column
+		parent.Fields = append(parent.Fields, &parquetField{
+			Tag: fmt.Sprintf("name=%s, type=%s, repetitiontype=OPTIONAL", segments[len(segments)-1], parquetTypes[column.kind]),
+		})
+	}
+	schema, err := json.Marshal(root)
+	if err != nil {
+		slog.Error(err)
+		return err
+	}
+	pw.w, err = writer.NewJSONWriterFromWriter(string(schema), pw.output, 1)
+	if err != nil {
+		slog.Error(err)
+		return err
+	}
+	pw.w.RowGroupSize = pw.rowGroupSize
+	return nil
+}
+
+// parquetField is field of parquet-go JSON schema, group when it has fields
+type parquetField struct {
+	Tag    string
+	Fields []*parquetField `json:",omitempty"`
+}
+
+// checkColumn returns reason why field at path can not become Parquet
+// column or empty string when it can
+func (pw *parquetWriter) checkColumn(path string, segments []string, groups map[string]*parquetField, names map[string]map[string]string) string {
+	if _, ok := groups[path]; ok {
+		return "holds objects in other rows"
+	}
+	prefix := ""
+	for _, segment := range segments {
+		if segment == "" || strings.ContainsAny(segment, ",=") {
+			return "can not be used as Parquet column name"
+		}
+		if other, ok := names[prefix][common.StringToVariableName(segment)]; ok && other != segment {
+			return "clashes with Parquet column " + joinPath(prefix, other)
+		}
+		prefix = joinPath(prefix, segment)
+		if _, ok := pw.known[prefix]; ok && prefix != path {
+			return "is nested in field " + prefix + " which is not object in other rows"
+		}
+	}
+	return ""
+}
+
+// writeRow converts fields to column kinds and writes the row
+func (pw *parquetWriter) writeRow(r map[string]interface{}) error {
+	record := make(map[string]interface{}, len(pw.columns))
+	for path, v := range r {
+		column, ok := pw.known[path]
+		if !ok {
+			if !pw.dropped[path] {
+				pw.dropped[path] = true
+				slog.Warnf("Field %s is not in Parquet schema, dropping it", path)
+			}
+			continue
+		}
+		value, ok := column.convert(v)
+		if !ok && !column.mismatch {
+			column.mismatch = true
+			slog.Warnf("Field %s has values not matching %s column, writing them as null", path, parquetTypes[column.kind])
+		}
+		setNested(record, strings.Split(path, "."), value)
+	}
+	s, err := json.Marshal(record)
+	if err != nil {
+		slog.Error(err)
+		return err
+	}
+	err = pw.w.Write(string(s))
+	if err != nil {
+		slog.Error(err)
+		return err
+	}
+	return nil
+}
+
+// setNested sets value at path creating objects on the way
+func setNested(record map[string]interface{}, path []string, value interface{}) {
+	for _, segment := range path[:len(path)-1] {
+		child, ok := record[segment].(map[string]interface{})
+		if !ok {
+			child = make(map[string]interface{})
+			record[segment] = child
+		}
+		record = child
+	}
+	record[path[len(path)-1]] = value
+}
+
+// Close implements Writer
+func (pw *parquetWriter) Close() error {
+	if pw.w == nil {
+		err := pw.writePending()
+		if err != nil {
+			slog.Error(err)
+			return err
+		}
+	}
+	err := pw.w.WriteStop()
+	if err != nil {
+		slog.Error(err)
+		return err
+	}
+	return nil
+}
+
+// convert converts value to the column kind. It returns false when
+// value does not fit the column.
+func (c *parquetColumn) convert(v interface{}) (interface{}, bool) {
+	if v == nil {
+		return nil, true
+	}
+	switch c.kind {
+	case parquetBool:
+		b, ok := v.(bool)
+		if !ok {
+			return nil, false
+		}
+		return b, true
+	case parquetInt:
+		switch value := v.(type) {
+		case int8:
+			return int64(value), true
+		case int64:
+			return value, true
+		case float64:
+			if valueKind(value) == parquetInt {
+				return int64(value), true
+			}
+		}
+		return nil, false
+	case parquetDouble:
+		switch value := v.(type) {
+		case int8:
+			return float64(value), true
+		case int64:
+			return float64(value), true
+		case float64:
+			return value, true
+		}
+		return nil, false
+	}
+	return csvCell(v), true
+}
+
+// valueKind returns column kind value fits in
+func valueKind(v interface{}) parquetKind {
+	switch value := v.(type) {
+	case nil:
+		return parquetNull
+	case bool:
+		return parquetBool
+	case int8, int64:
+		return parquetInt
+	case float64:
+		if value == math.Trunc(value) && math.Abs(value) < 1<<63 {
+			return parquetInt
+		}
+		return parquetDouble
+	case string:
+		return parquetString
+	}
+	return parquetJSON
+}
+
+// mergeKinds returns column kind holding values of both kinds. Integers
+// widen to doubles, other conflicting kinds fall back to string.
+func mergeKinds(a, b parquetKind) parquetKind {
+	switch {
+	case a == b || b == parquetNull:
+		return a
+	case a == parquetNull:
+		return b
+	case (a == parquetInt && b == parquetDouble) || (a == parquetDouble && b == parquetInt):
+		return parquetDouble
+	}
+	return parquetString
+}
+
+// flattenFields flattens value into fields keyed by dotted path. Arrays
+// and empty objects are kept as they are.
+func flattenFields(path string, v interface{}, flat map[string]interface{}) {
+	if obj, ok := v.(map[string]interface{}); ok && (len(obj) > 0 || path == "") {
+		for k, child := range obj {
+			flattenFields(joinPath(path, k), child, flat)
+		}
+		return
+	}
+	flat[path] = v
+}
+
+// jsonSchema is part of JSON Schema describing Parquet columns
+type jsonSchema struct {
+	Type       interface{}            `json:"type"`
+	Properties map[string]*jsonSchema `json:"properties"`
+}
+
+// readJSONSchema reads column kinds from JSON Schema file. Properties of
+// nested objects become dotted paths like in the rows.
+func readJSONSchema(filename string) (map[string]parquetKind, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		slog.Error(err)
+		return nil, err
+	}
+	var schema jsonSchema
+	err = json.Unmarshal(data, &schema)
+	if err != nil {
+		err = fmt.Errorf("Invalid JSON Schema %s: %v", filename, err)
+		slog.Error(err)
+		return nil, err
+	}
+	if len(schema.Properties) == 0 {
+		err := fmt.Errorf("JSON Schema %s has no properties", filename)
+		slog.Error(err)
+		return nil, err
+	}
+	kinds := make(map[string]parquetKind)
+	err = schemaKinds("", &schema, kinds)
+	if err != nil {
+		err = fmt.Errorf("Invalid JSON Schema %s: %v", filename, err)
+		slog.Error(err)
+		return nil, err
+	}
+	return kinds, nil
+}
+
+// schemaKinds adds column kinds of the schema at dotted path
+func schemaKinds(path string, schema *jsonSchema, kinds map[string]parquetKind) error {
+	if len(schema.Properties) > 0 {
+		for k, child := range schema.Properties {
+			if child == nil {
+				return fmt.Errorf("property %s has no schema", joinPath(path, k))
+			}
+			err := schemaKinds(joinPath(path, k), child, kinds)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	var types []string
+	switch t := schema.Type.(type) {
+	case nil:
+	case string:
+		types = []string{t}
+	case []interface{}:
+		for _, element := range t {
+			s, ok := element.(string)
+			if !ok {
+				return fmt.Errorf("property %s has invalid type %v", path, element)
+			}
+			types = append(types, s)
+		}
+	default:
+		return fmt.Errorf("property %s has invalid type %v", path, t)
+	}
+	// Without type any value is accepted and written as string
+	kind := parquetNull
+	if len(types) == 0 {
+		kind = parquetString
+	}
+	for _, t := range types {
+		switch t {
+		case "null":
+		case "boolean":
+			kind = mergeKinds(kind, parquetBool)
+		case "integer":
+			kind = mergeKinds(kind, parquetInt)
+		case "number":
+			kind = mergeKinds(kind, parquetDouble)
+		case "string":
+			kind = mergeKinds(kind, parquetString)
+		case "array", "object":
+			kind = mergeKinds(kind, parquetJSON)
+		default:
+			return fmt.Errorf("property %s has unknown type %s", path, t)
+		}
+	}
+	kinds[path] = kind
+	return nil
+}
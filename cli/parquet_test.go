@@ -0,0 +1,121 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/spf13/cobra"
+	"github.com/xitongsys/parquet-go-source/buffer"
+	"github.com/xitongsys/parquet-go/reader"
+)
+
+// writeParquet writes rows with parquet writer and returns the file
+func writeParquet(t *testing.T, rows []map[string]interface{}, args ...string) []byte {
+	cmd := &cobra.Command{Use: "test"}
+	addOutputFlags(cmd)
+	err := cmd.Flags().Parse(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var output bytes.Buffer
+	w, err := newParquetWriter(&output, cmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, row := range rows {
+		err = w.Write(row)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = w.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return output.Bytes()
+}
+
+// readParquet reads the file back as JSON rows
+func readParquet(t *testing.T, data []byte) (*reader.ParquetReader, []map[string]interface{}) {
+	pf, err := buffer.NewBufferFile(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pr, err := reader.NewParquetReader(pf, nil, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pr.ReadStop()
+	values, err := pr.ReadByNumber(int(pr.GetNumRows()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoded, err := json.Marshal(values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rows []map[string]interface{}
+	err = json.Unmarshal(encoded, &rows)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pr, rows
+}
+
+func TestParquetNestedRoundTrip(t *testing.T) {
+	var docs []map[string]interface{}
+	for _, line := range []string{
+		`{"_id":"a","type":"deal","value":1500,"address":{"city":"Tallinn","geo":{"lat":59.4}}}`,
+		`{"_id":"b","type":"deal","value":2.5,"address":{"city":"Tartu"},"tags":["x","y"]}`,
+		`{"_id":"c","type":"user"}`,
+	} {
+		var doc map[string]interface{}
+		err := json.Unmarshal([]byte(line), &doc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		doc["_deleted"] = int8(0)
+		docs = append(docs, doc)
+	}
+	pr, rows := readParquet(t, writeParquet(t, docs))
+
+	// Nested fields have to be groups of the schema tree and column chunk
+	// paths have to match its leaves. Reader renames fields to Go names.
+	schema := pr.Footer.Schema
+	leaves := make(map[string]bool)
+	var walk func(prefix []string)
+	walk = func(prefix []string) {
+		element := schema[0]
+		schema = schema[1:]
+		path := append(append([]string{}, prefix...), element.Name)
+		if element.GetNumChildren() == 0 {
+			leaves[strings.Join(path[1:], "\x00")] = true
+			return
+		}
+		for i := int32(0); i < element.GetNumChildren(); i++ {
+			walk(path)
+		}
+	}
+	walk(nil)
+	for _, path := range [][]string{{"Address", "City"}, {"Address", "Geo", "Lat"}} {
+		if !leaves[strings.Join(path, "\x00")] {
+			t.Errorf("Expected column %q in schema", path)
+		}
+	}
+	for _, column := range pr.Footer.RowGroups[0].Columns {
+		if !leaves[strings.Join(column.MetaData.PathInSchema, "\x00")] {
+			t.Errorf("Column chunk path %q is not in schema", column.MetaData.PathInSchema)
+		}
+	}
+	if len(rows) != 3 {
+		t.Fatalf("Expected 3 rows, got %d", len(rows))
+	}
+	got, _ := json.Marshal(rows)
+	for _, want := range []string{`"City":"Tallinn"`, `"Lat":59.4`, `"City":"Tartu"`, `"Tags":"[\"x\",\"y\"]"`, `"Value":2.5`, `"Value":1500`} {
+		if !strings.Contains(string(got), want) {
+			t.Errorf("Expected %s in %s", want, got)
+		}
+	}
+}
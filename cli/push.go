@@ -0,0 +1,183 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"github.com/pipedrive/uncouch/couchdbfile"
+	"github.com/pipedrive/uncouch/couchpush"
+	"github.com/spf13/cobra"
+)
+
+// pushBatch is batch of _bulk_docs entries waiting to be sent
+type pushBatch struct {
+	docs []map[string]interface{}
+	// seq is update sequence of the last document in the batch
+	seq int64
+}
+
+func cmdPushFunc(cmd *cobra.Command, args []string) error {
+	filename := args[0]
+	target := args[1]
+	var newOpts dataOptions
+	opts := &newOpts
+	flags := cmd.Flags()
+	var err error
+	if opts.verify, err = flags.GetBool("verify"); err != nil {
+		slog.Error(err)
+		return err
+	}
+	if opts.skipCorrupted, err = flags.GetBool("skip-corrupted"); err != nil {
+		slog.Error(err)
+		return err
+	}
+	if opts.mmap, err = flags.GetBool("mmap"); err != nil {
+		slog.Error(err)
+		return err
+	}
+	if opts.checkpointFile, err = flags.GetString("checkpoint"); err != nil {
+		slog.Error(err)
+		return err
+	}
+	batchSize, err := flags.GetInt("batch-size")
+	if err != nil {
+		slog.Error(err)
+		return err
+	}
+	if batchSize <= 0 {
+		err := fmt.Errorf("Flag --batch-size must be positive, got %d", batchSize)
+		slog.Error(err)
+		return err
+	}
+	create, err := flags.GetBool("create")
+	if err != nil {
+		slog.Error(err)
+		return err
+	}
+	client, err := couchpush.NewClient(target)
+	if err != nil {
+		slog.Error(err)
+		return err
+	}
+	if client.Retries, err = flags.GetInt("retries"); err != nil {
+		slog.Error(err)
+		return err
+	}
+	if client.Backoff, err = flags.GetDuration("backoff"); err != nil {
+		slog.Error(err)
+		return err
+	}
+	if client.HTTPClient.Timeout, err = flags.GetDuration("timeout"); err != nil {
+		slog.Error(err)
+		return err
+	}
+
+	file, err := openCouchFile(filename, opts)
+	if err != nil {
+		slog.Error(err)
+		return err
+	}
+	defer file.Close()
+
+	ctx, cancel := interruptContext()
+	defer cancel()
+
+	err = client.EnsureDatabase(ctx, create)
+	if err != nil {
+		slog.Error(err)
+		return err
+	}
+	return pushFile(ctx, file.cf, filename, client, batchSize, opts.checkpointFile)
+}
+
+// pushFile sends every leaf revision of the documents updated since the
+// checkpoint. Batches are cut between documents, so the checkpoint written
+// after each batch never points into the middle of the document.
+func pushFile(ctx context.Context, cf *couchdbfile.CouchDbFile, filename string, client *couchpush.Client, batchSize int, checkpointFile string) error {
+	var (
+		cps  checkpoints
+		key  string
+		err  error
+		opts = couchdbfile.DocumentsOptions{Tree: couchdbfile.SeqTree, AllLeaves: true}
+	)
+	if checkpointFile != "" {
+		cps, err = readCheckpoints(checkpointFile)
+		if err != nil {
+			slog.Error(err)
+			return err
+		}
+		key, err = checkpointKey(filename)
+		if err != nil {
+			slog.Error(err)
+			return err
+		}
+		since, changed := resumeSince(cps[key], cf, filename)
+		if !changed {
+			return nil
+		}
+		opts.Since = since
+	}
+
+	var (
+		batch  pushBatch
+		pushed int
+		failed int
+	)
+	// send pushes the batch and moves checkpoint to seq as long as no
+	// document has failed so far, failed ones are sent again on resume
+	send := func(seq int64) error {
+		if len(batch.docs) > 0 {
+			errs, err := client.BulkDocs(ctx, batch.docs)
+			if err != nil {
+				slog.Error(err)
+				return err
+			}
+			for _, e := range errs {
+				slog.Errorf("Document \"%s\" revision %s was not stored: %s: %s", e.ID, e.Rev, e.Error, e.Reason)
+			}
+			pushed += len(batch.docs) - len(errs)
+			failed += len(errs)
+			batch.docs = batch.docs[:0]
+		}
+		if cps == nil || failed > 0 {
+			return nil
+		}
+		cps[key] = checkpoint{UpdateSeq: seq, HeaderOffset: cf.HeaderOffset()}
+		return writeCheckpoints(checkpointFile, cps)
+	}
+
+	it := cf.Documents(ctx, opts)
+	defer it.Close()
+	for it.Next() {
+		doc := it.Doc()
+		if len(batch.docs) >= batchSize && doc.Seq != batch.seq {
+			err = send(batch.seq)
+			if err != nil {
+				slog.Error(err)
+				return err
+			}
+		}
+		bulkDoc, err := couchpush.BulkDoc(cf, doc)
+		if err != nil {
+			slog.Error(err)
+			return err
+		}
+		batch.docs = append(batch.docs, bulkDoc)
+		batch.seq = doc.Seq
+	}
+	if err := it.Err(); err != nil {
+		slog.Error(err)
+		return err
+	}
+	err = send(cf.Header.UpdateSeq)
+	if err != nil {
+		slog.Error(err)
+		return err
+	}
+	slog.Infof("Pushed %d document revisions from %s to %s", pushed, filename, couchpush.Redact(client.URL))
+	if failed > 0 {
+		err := fmt.Errorf("Failed to push %d document revisions from %s", failed, filename)
+		slog.Error(err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,197 @@
+package cli
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pipedrive/uncouch/couchpush"
+)
+
+// pushFixture is the shared fixture with 9 documents, "d" with two
+// conflicting leaves, "b" deleted and "e" with plain and gzip encoded
+// attachments
+const pushFixture = "../testdata/small.couch"
+
+// bulkDocsServer records _bulk_docs batches and refuses documents listed
+// in fail
+type bulkDocsServer struct {
+	mu      sync.Mutex
+	batches [][]map[string]interface{}
+	fail    map[string]bool
+}
+
+func (s *bulkDocsServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Docs     []map[string]interface{} `json:"docs"`
+		NewEdits *bool                    `json:"new_edits"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil || r.URL.Path != "/db/_bulk_docs" || req.NewEdits == nil || *req.NewEdits {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.batches = append(s.batches, req.Docs)
+	results := []couchpush.DocError{}
+	for _, doc := range req.Docs {
+		id, _ := doc["_id"].(string)
+		if s.fail[id] {
+			results = append(results, couchpush.DocError{ID: id, Rev: doc["_rev"].(string), Error: "forbidden", Reason: "refused"})
+		}
+	}
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(results)
+}
+
+// ids returns document IDs of every batch
+func (s *bulkDocsServer) ids() [][]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var batches [][]string
+	for _, batch := range s.batches {
+		var ids []string
+		for _, doc := range batch {
+			ids = append(ids, doc["_id"].(string))
+		}
+		batches = append(batches, ids)
+	}
+	return batches
+}
+
+// runPush pushes the fixture to the server and returns checkpoint it left
+func runPush(t *testing.T, s *bulkDocsServer, batchSize int, checkpointFile string) (checkpoint, error) {
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+	client, err := couchpush.NewClient(srv.URL + "/db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.Backoff = time.Millisecond
+	file, err := openCouchFile(pushFixture, &dataOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	pushErr := pushFile(context.Background(), file.cf, pushFixture, client, batchSize, checkpointFile)
+	cps, err := readCheckpoints(checkpointFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := checkpointKey(pushFixture)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cps[key], pushErr
+}
+
+func tempCheckpoint(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "push")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return filepath.Join(dir, "checkpoint.json"), func() { os.RemoveAll(dir) }
+}
+
+func TestPushBatches(t *testing.T) {
+	checkpointFile, cleanup := tempCheckpoint(t)
+	defer cleanup()
+	s := &bulkDocsServer{}
+	cp, err := runPush(t, s, 2, checkpointFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Both leaves of "d" go in the same batch even though it gets too big
+	got := make([]string, 0)
+	for _, ids := range s.ids() {
+		got = append(got, strings.Join(ids, ","))
+	}
+	want := []string{"c,user:000", "deal:000,user:001", "deal:001,a", "b,d,d", "e"}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("Expected batches %v, got %v", want, got)
+	}
+	if cp.UpdateSeq != 13 {
+		t.Errorf("Expected checkpoint at update sequence 13, got %d", cp.UpdateSeq)
+	}
+
+	// Revision histories and attachment data are inlined
+	var doc map[string]interface{}
+	for _, batch := range s.batches {
+		for _, d := range batch {
+			if _, ok := d["_revisions"]; !ok {
+				t.Errorf("Document %v has no _revisions", d["_id"])
+			}
+			if d["_id"] == "e" {
+				doc = d
+			}
+		}
+	}
+	attachments, _ := doc["_attachments"].(map[string]interface{})
+	hello, _ := attachments["hello.txt"].(map[string]interface{})
+	data, _ := base64.StdEncoding.DecodeString(hello["data"].(string))
+	if string(data) != strings.Repeat("hello world ", 20) {
+		t.Errorf("Unexpected hello.txt data %q", data)
+	}
+	if _, ok := hello["digest"]; !ok {
+		t.Error("Expected digest of identity encoded attachment")
+	}
+	gzipped, _ := attachments["dir/data.json"].(map[string]interface{})
+	data, _ = base64.StdEncoding.DecodeString(gzipped["data"].(string))
+	if string(data) != strings.Repeat(`{"a":1}`, 30) {
+		t.Errorf("Unexpected dir/data.json data %q", data)
+	}
+	if _, ok := gzipped["digest"]; ok {
+		t.Error("Digest of gzip encoded attachment does not match decoded data")
+	}
+	if _, ok := gzipped["stub"]; ok {
+		t.Error("Expected attachment data instead of stub")
+	}
+
+	// Nothing is sent when the file did not change
+	s = &bulkDocsServer{}
+	_, err = runPush(t, s, 2, checkpointFile)
+	if err != nil || len(s.batches) != 0 {
+		t.Errorf("Expected nothing pushed again, got %v batches and error %v", len(s.batches), err)
+	}
+}
+
+func TestPushDocErrorStopsCheckpoint(t *testing.T) {
+	checkpointFile, cleanup := tempCheckpoint(t)
+	defer cleanup()
+	s := &bulkDocsServer{fail: map[string]bool{"a": true}}
+	cp, err := runPush(t, s, 2, checkpointFile)
+	if err == nil {
+		t.Error("Expected error for refused document")
+	}
+	// Batches after the failed one are sent, but checkpoint stays before it
+	if len(s.batches) != 5 {
+		t.Errorf("Expected 5 batches, got %d", len(s.batches))
+	}
+	if cp.UpdateSeq != 7 {
+		t.Errorf("Expected checkpoint at update sequence 7, got %d", cp.UpdateSeq)
+	}
+
+	// Resume sends the failed batch again
+	s = &bulkDocsServer{}
+	cp, err = runPush(t, s, 2, checkpointFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := s.ids()
+	if len(got) == 0 || strings.Join(got[0], ",") != "deal:001,a" {
+		t.Errorf("Expected resume from batch with a, got %v", got)
+	}
+	if cp.UpdateSeq != 13 {
+		t.Errorf("Expected checkpoint at update sequence 13, got %d", cp.UpdateSeq)
+	}
+}
@@ -0,0 +1,56 @@
+package cli
+
+import (
+	"context"
+	"github.com/pipedrive/uncouch/couchserve"
+	"github.com/spf13/cobra"
+	"net/http"
+	"time"
+)
+
+// shutdownTimeout is time given to running requests when server stops
+const shutdownTimeout = 10 * time.Second
+
+func cmdServeFunc(cmd *cobra.Command, args []string) error {
+	flags := cmd.Flags()
+	dir, err := flags.GetString("dir")
+	if err != nil {
+		slog.Error(err)
+		return err
+	}
+	addr, err := flags.GetString("addr")
+	if err != nil {
+		slog.Error(err)
+		return err
+	}
+	handler, err := couchserve.New(dir)
+	if err != nil {
+		slog.Error(err)
+		return err
+	}
+	defer handler.Close()
+	server := &http.Server{Addr: addr, Handler: handler}
+
+	ctx, cancel := interruptContext()
+	defer cancel()
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- server.ListenAndServe()
+	}()
+	slog.Infof("Serving %s on http://%s", dir, addr)
+	select {
+	case err = <-errs:
+		slog.Error(err)
+		return err
+	case <-ctx.Done():
+	}
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer shutdownCancel()
+	err = server.Shutdown(shutdownCtx)
+	if err != nil {
+		slog.Error(err)
+		return err
+	}
+	return nil
+}
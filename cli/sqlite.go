@@ -0,0 +1,388 @@
+package cli
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"github.com/pipedrive/uncouch/couchdbfile"
+	"github.com/pipedrive/uncouch/couchdir"
+	"github.com/spf13/cobra"
+	"os"
+	"path"
+	"strings"
+
+	// SQLite driver written in Go, so the binary still builds without cgo
+	_ "modernc.org/sqlite"
+)
+
+// exportTargetSQLite is the only export target so far
+const exportTargetSQLite = "sqlite"
+
+// checkpointsTable holds export checkpoints in the SQLite file itself, so
+// they are committed in the same transaction as the exported rows
+const checkpointsTable = "_uncouch_checkpoints"
+
+// generatedColumn is column computed from the dotted path of the body
+type generatedColumn struct {
+	name string
+	path string
+}
+
+// sqliteExport exports databases into tables of SQLite file
+type sqliteExport struct {
+	db        *sql.DB
+	opts      *dataOptions
+	batchSize int
+	generated []generatedColumn
+	// tables are already created in this run
+	tables map[string]bool
+}
+
+func cmdExportFunc(cmd *cobra.Command, args []string) error {
+	filename := args[0]
+	outputFile := args[1]
+	to, err := cmd.Flags().GetString("to")
+	if err != nil {
+		slog.Error(err)
+		return err
+	}
+	if to != exportTargetSQLite {
+		err := fmt.Errorf("Unknown export target \"%s\", expecting %s", to, exportTargetSQLite)
+		slog.Error(err)
+		return err
+	}
+	var export sqliteExport
+	e := &export
+	e.tables = make(map[string]bool)
+	e.opts = &dataOptions{}
+	flags := cmd.Flags()
+	if e.opts.verify, err = flags.GetBool("verify"); err != nil {
+		slog.Error(err)
+		return err
+	}
+	if e.opts.skipCorrupted, err = flags.GetBool("skip-corrupted"); err != nil {
+		slog.Error(err)
+		return err
+	}
+	if e.opts.mmap, err = flags.GetBool("mmap"); err != nil {
+		slog.Error(err)
+		return err
+	}
+	if e.batchSize, err = flags.GetInt("batch-size"); err != nil {
+		slog.Error(err)
+		return err
+	}
+	if e.batchSize <= 0 {
+		err := fmt.Errorf("Flag --batch-size must be positive, got %d", e.batchSize)
+		slog.Error(err)
+		return err
+	}
+	generated, err := flags.GetStringSlice("generated")
+	if err != nil {
+		slog.Error(err)
+		return err
+	}
+	e.generated, err = parseGeneratedColumns(generated)
+	if err != nil {
+		slog.Error(err)
+		return err
+	}
+	fi, err := os.Stat(filename)
+	if err != nil {
+		slog.Error(err)
+		return err
+	}
+
+	e.db, err = sql.Open("sqlite", outputFile)
+	if err != nil {
+		slog.Error(err)
+		return err
+	}
+	defer e.db.Close()
+	// Single connection keeps pragmas and transactions on the same handle
+	e.db.SetMaxOpenConns(1)
+	_, err = e.db.Exec(fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (path TEXT PRIMARY KEY, update_seq INTEGER NOT NULL, header_offset INTEGER NOT NULL)", quoteIdentifier(checkpointsTable)))
+	if err != nil {
+		slog.Error(err)
+		return err
+	}
+
+	ctx, cancel := interruptContext()
+	defer cancel()
+
+	if !fi.IsDir() {
+		shard, ok := couchdir.ParseShardPath(filename)
+		if !ok {
+			shard.DbName = strings.Split(path.Base(filename), ".")[0]
+		}
+		return e.exportFile(ctx, shard)
+	}
+	dbs, err := couchdir.Find(filename)
+	if err != nil {
+		slog.Error(err)
+		return err
+	}
+	for _, db := range dbs {
+		for _, shard := range db.Shards {
+			err = e.exportFile(ctx, shard)
+			if err != nil {
+				slog.Error(err)
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// parseGeneratedColumns parses name=path or path column definitions, the
+// path itself is used as name when it is not given
+func parseGeneratedColumns(definitions []string) ([]generatedColumn, error) {
+	columns := make([]generatedColumn, 0, len(definitions))
+	seen := make(map[string]bool)
+	for _, d := range definitions {
+		var c generatedColumn
+		parts := strings.SplitN(d, "=", 2)
+		c.name = strings.TrimSpace(parts[0])
+		c.path = c.name
+		if len(parts) == 2 {
+			c.path = strings.TrimSpace(parts[1])
+		}
+		if c.name == "" || c.path == "" {
+			err := fmt.Errorf("Invalid generated column \"%s\", expecting name=path or path", d)
+			slog.Error(err)
+			return nil, err
+		}
+		key := strings.ToLower(c.name)
+		if seen[key] || key == "_id" || key == "_rev" || key == "_seq" || key == "_deleted" || key == "body" {
+			err := fmt.Errorf("Generated column \"%s\" clashes with another column", c.name)
+			slog.Error(err)
+			return nil, err
+		}
+		seen[key] = true
+		columns = append(columns, c)
+	}
+	return columns, nil
+}
+
+// createTable creates table of the database unless it exists and adds
+// generated columns it does not have yet
+func (e *sqliteExport) createTable(table string) error {
+	if e.tables[table] {
+		return nil
+	}
+	_, err := e.db.Exec(fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (_id TEXT PRIMARY KEY, _rev TEXT NOT NULL, _seq INTEGER NOT NULL, _deleted INTEGER NOT NULL, body TEXT NOT NULL)", quoteIdentifier(table)))
+	if err != nil {
+		slog.Error(err)
+		return err
+	}
+	// table_xinfo lists generated columns as well
+	rows, err := e.db.Query(fmt.Sprintf("PRAGMA table_xinfo(%s)", quoteIdentifier(table)))
+	if err != nil {
+		slog.Error(err)
+		return err
+	}
+	existing := make(map[string]bool)
+	for rows.Next() {
+		var (
+			cid, notNull, pk, hidden int
+			name, columnType         string
+			defaultValue             sql.NullString
+		)
+		err = rows.Scan(&cid, &name, &columnType, &notNull, &defaultValue, &pk, &hidden)
+		if err != nil {
+			slog.Error(err)
+			rows.Close()
+			return err
+		}
+		existing[strings.ToLower(name)] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		slog.Error(err)
+		return err
+	}
+	for _, c := range e.generated {
+		if existing[strings.ToLower(c.name)] {
+			continue
+		}
+		_, err = e.db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s GENERATED ALWAYS AS (json_extract(body, %s)) VIRTUAL",
+			quoteIdentifier(table), quoteIdentifier(c.name), quoteString(jsonPath(c.path))))
+		if err != nil {
+			slog.Error(err)
+			return err
+		}
+	}
+	e.tables[table] = true
+	return nil
+}
+
+// readCheckpoint reads checkpoint of the exported file, zero value when
+// the file was not exported yet
+func (e *sqliteExport) readCheckpoint(key string) (checkpoint, error) {
+	var cp checkpoint
+	err := e.db.QueryRow(fmt.Sprintf("SELECT update_seq, header_offset FROM %s WHERE path = ?", quoteIdentifier(checkpointsTable)), key).
+		Scan(&cp.UpdateSeq, &cp.HeaderOffset)
+	if err == sql.ErrNoRows {
+		return cp, nil
+	}
+	if err != nil {
+		slog.Error(err)
+		return cp, err
+	}
+	return cp, nil
+}
+
+// exportFile exports documents updated since the checkpoint into the table
+// of the database. Every batch is committed together with the update
+// sequence of its last document, so interrupted export continues after it.
+func (e *sqliteExport) exportFile(ctx context.Context, shard couchdir.Shard) error {
+	file, err := openCouchFile(shard.Path, e.opts)
+	if err != nil {
+		slog.Error(err)
+		return err
+	}
+	defer file.Close()
+	cf := file.cf
+
+	err = e.createTable(shard.DbName)
+	if err != nil {
+		slog.Error(err)
+		return err
+	}
+	key, err := checkpointKey(shard.Path)
+	if err != nil {
+		slog.Error(err)
+		return err
+	}
+	cp, err := e.readCheckpoint(key)
+	if err != nil {
+		slog.Error(err)
+		return err
+	}
+	since, changed := resumeSince(cp, cf, shard.Path)
+	if !changed {
+		return nil
+	}
+
+	// Seq tree yields documents in update sequence order, so the sequence of
+	// the last written document is a valid checkpoint
+	it := cf.Documents(ctx, couchdbfile.DocumentsOptions{Tree: couchdbfile.SeqTree, Since: since})
+	defer it.Close()
+	batch := make([]*couchdbfile.CouchDbDocument, 0, e.batchSize)
+	for it.Next() {
+		batch = append(batch, it.Doc())
+		if len(batch) < e.batchSize {
+			continue
+		}
+		err = e.writeBatch(shard.DbName, batch, key, checkpoint{
+			UpdateSeq:    batch[len(batch)-1].Seq,
+			HeaderOffset: cf.HeaderOffset(),
+		})
+		if err != nil {
+			slog.Error(err)
+			return err
+		}
+		batch = batch[:0]
+	}
+	if err := it.Err(); err != nil {
+		slog.Error(err)
+		return err
+	}
+	return e.writeBatch(shard.DbName, batch, key, checkpoint{
+		UpdateSeq:    cf.Header.UpdateSeq,
+		HeaderOffset: cf.HeaderOffset(),
+	})
+}
+
+// writeBatch writes documents and the checkpoint in single transaction
+func (e *sqliteExport) writeBatch(table string, docs []*couchdbfile.CouchDbDocument, key string, cp checkpoint) error {
+	tx, err := e.db.Begin()
+	if err != nil {
+		slog.Error(err)
+		return err
+	}
+	err = writeRows(tx, table, docs)
+	if err == nil {
+		_, err = tx.Exec(fmt.Sprintf("INSERT OR REPLACE INTO %s (path, update_seq, header_offset) VALUES (?, ?, ?)", quoteIdentifier(checkpointsTable)),
+			key, cp.UpdateSeq, cp.HeaderOffset)
+	}
+	if err != nil {
+		slog.Error(err)
+		tx.Rollback()
+		return err
+	}
+	err = tx.Commit()
+	if err != nil {
+		slog.Error(err)
+		return err
+	}
+	return nil
+}
+
+// writeRows inserts documents replacing their previously exported revisions
+func writeRows(tx *sql.Tx, table string, docs []*couchdbfile.CouchDbDocument) error {
+	if len(docs) == 0 {
+		return nil
+	}
+	stmt, err := tx.Prepare(fmt.Sprintf("INSERT OR REPLACE INTO %s (_id, _rev, _seq, _deleted, body) VALUES (?, ?, ?, ?, ?)", quoteIdentifier(table)))
+	if err != nil {
+		slog.Error(err)
+		return err
+	}
+	defer stmt.Close()
+	for _, doc := range docs {
+		body, err := json.Marshal(documentBody(doc))
+		if err != nil {
+			slog.Error(err)
+			return err
+		}
+		_, err = stmt.Exec(doc.Id, doc.Rev, doc.Seq, doc.Deleted, string(body))
+		if err != nil {
+			slog.Error(err)
+			return err
+		}
+	}
+	return nil
+}
+
+// documentBody returns body fields of the document with attachment stubs
+func documentBody(doc *couchdbfile.CouchDbDocument) map[string]interface{} {
+	if len(doc.Attachments) == 0 && doc.Value != nil {
+		return doc.Value
+	}
+	body := make(map[string]interface{}, len(doc.Value)+1)
+	for k, v := range doc.Value {
+		body[k] = v
+	}
+	if len(doc.Attachments) > 0 {
+		stubs := make(map[string]interface{}, len(doc.Attachments))
+		for _, a := range doc.Attachments {
+			stubs[a.Name] = a.Stub()
+		}
+		body["_attachments"] = stubs
+	}
+	return body
+}
+
+// jsonPath converts dotted path to SQLite JSON path with quoted keys
+func jsonPath(dotted string) string {
+	var sb strings.Builder
+	sb.WriteString("$")
+	for _, key := range strings.Split(dotted, ".") {
+		sb.WriteString(".\"")
+		sb.WriteString(strings.Replace(key, "\"", "\\\"", -1))
+		sb.WriteString("\"")
+	}
+	return sb.String()
+}
+
+// quoteIdentifier quotes SQL identifier like table or column name
+func quoteIdentifier(name string) string {
+	return "\"" + strings.Replace(name, "\"", "\"\"", -1) + "\""
+}
+
+// quoteString quotes SQL string literal
+func quoteString(s string) string {
+	return "'" + strings.Replace(s, "'", "''", -1) + "'"
+}
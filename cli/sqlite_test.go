@@ -0,0 +1,121 @@
+package cli
+
+import (
+	"database/sql"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// queryStrings returns rows of the query with columns joined by space
+func queryStrings(t *testing.T, db *sql.DB, query string) []string {
+	rows, err := db.Query(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var result []string
+	for rows.Next() {
+		values := make([]sql.NullString, len(columns))
+		dest := make([]interface{}, len(columns))
+		for i := range values {
+			dest[i] = &values[i]
+		}
+		err = rows.Scan(dest...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cells := make([]string, len(values))
+		for i, v := range values {
+			cells[i] = v.String
+		}
+		result = append(result, strings.Join(cells, " "))
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
+func TestExportSQLite(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "out.db")
+	runCommand(t, "export", smallFixture, filename, "--generated", "v=value,nested.deep.x", "--batch-size", "2")
+
+	db, err := sql.Open("sqlite", filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// Table per database, generated columns are hidden from table_info
+	got := queryStrings(t, db, "SELECT name, type, hidden FROM pragma_table_xinfo('small')")
+	want := []string{"_id TEXT 0", "_rev TEXT 0", "_seq INTEGER 0", "_deleted INTEGER 0", "body TEXT 0", "v GENERATED ALWAYS 2", "nested.deep.x GENERATED ALWAYS 2"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("Expected columns %v, got %v", want, got)
+	}
+	got = queryStrings(t, db, `SELECT _id, _seq, _deleted, v, "nested.deep.x" FROM small WHERE v IS NOT NULL OR _deleted ORDER BY _seq`)
+	want = []string{"c 3 0 2000 1.5", "deal:000 6 0 0 ", "deal:001 8 0 100 ", "a 9 0 1500 ", "b 10 1  ", "d 12 0 20 "}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("Expected rows %v, got %v", want, got)
+	}
+	// Body is JSON without metadata, attachments as stubs
+	got = queryStrings(t, db, "SELECT json_extract(body, '$.type'), json_extract(body, '$._id'), json_extract(body, '$._attachments.\"hello.txt\".length') FROM small WHERE _id = 'e'")
+	if strings.Join(got, ",") != "file  240" {
+		t.Errorf("Unexpected body of e %v", got)
+	}
+	key, err := checkpointKey(smallFixture)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got = queryStrings(t, db, "SELECT path, update_seq, header_offset FROM _uncouch_checkpoints")
+	if strings.Join(got, ",") != key+" 13 8192" {
+		t.Errorf("Unexpected checkpoint %v", got)
+	}
+
+	// Export interrupted after a batch continues from its checkpoint, new
+	// generated column is added to the existing table
+	_, err = db.Exec("DELETE FROM small WHERE _seq > 7; UPDATE _uncouch_checkpoints SET update_seq = 7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	runCommand(t, "export", smallFixture, filename, "--generated", "v=value,name")
+	got = queryStrings(t, db, "SELECT count(*), count(v), count(name), max(_seq) FROM small")
+	if strings.Join(got, ",") != "9 5 2 13" {
+		t.Errorf("Expected all rows after resumed export, got %v", got)
+	}
+	got = queryStrings(t, db, "SELECT update_seq FROM _uncouch_checkpoints")
+	if strings.Join(got, ",") != "13" {
+		t.Errorf("Expected checkpoint at 13, got %v", got)
+	}
+}
+
+func TestParseGeneratedColumns(t *testing.T) {
+	columns, err := parseGeneratedColumns([]string{"city=address.city", " n ", "Body2 = a.b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, c := range columns {
+		got = append(got, c.name+":"+c.path+":"+jsonPath(c.path))
+	}
+	want := `city:address.city:$."address"."city",n:n:$."n",Body2:a.b:$."a"."b"`
+	if strings.Join(got, ",") != want {
+		t.Errorf("Expected %s, got %s", want, strings.Join(got, ","))
+	}
+	for _, definitions := range [][]string{{"=a"}, {"a="}, {"a", "A"}, {"_ID=x"}, {"body"}} {
+		if _, err := parseGeneratedColumns(definitions); err == nil {
+			t.Errorf("Expected error for %v", definitions)
+		}
+	}
+}
@@ -1,11 +1,14 @@
 package cli
 
 import (
+	"context"
 	"fmt"
 	"github.com/pipedrive/uncouch/couchdbfile"
 	"github.com/pipedrive/uncouch/leakybucket"
 	"os"
 	"path"
+	"path/filepath"
+	"strings"
 )
 
 func writeHeaders(cf *couchdbfile.CouchDbFile, outputdir string) error {
@@ -22,6 +25,77 @@ func writeHeaders(cf *couchdbfile.CouchDbFile, outputdir string) error {
 	return nil
 }
 
+// writeAttachments extracts attachments of all not deleted documents into
+// outputdir/docid/name files. Only attachment part of the winning revision
+// summary is read, document bodies are not decoded.
+func writeAttachments(ctx context.Context, cf *couchdbfile.CouchDbFile, outputdir string) error {
+	it := cf.DocumentInfos(ctx, couchdbfile.DocumentsOptions{Tree: couchdbfile.IDTree})
+	for it.Next() {
+		di := it.Info()
+		winner := di.WinningRevision()
+		if winner == nil || winner.Deleted != 0 || winner.Offset < 0 {
+			continue
+		}
+		attachments, err := cf.ReadAttachments(winner)
+		if err != nil {
+			slog.Error(err)
+			return err
+		}
+		docID := strings.TrimSpace(string(di.ID))
+		for i := range attachments {
+			a := &attachments[i]
+			filename, err := attachmentPath(outputdir, docID, a.Name)
+			if err != nil {
+				slog.Error(err)
+				return err
+			}
+			err = writeAttachmentToFile(cf, a, filename)
+			if err != nil {
+				slog.Error(err)
+				return err
+			}
+		}
+	}
+	if err := it.Err(); err != nil {
+		slog.Error(err)
+		return err
+	}
+	return nil
+}
+
+// attachmentPath returns file name for the attachment making sure it
+// stays inside the output directory
+func attachmentPath(outputdir string, docID string, name string) (string, error) {
+	base := filepath.Clean(outputdir)
+	filename := filepath.Join(base, filepath.FromSlash(docID), filepath.FromSlash(name))
+	if !strings.HasPrefix(filename, base+string(filepath.Separator)) {
+		err := fmt.Errorf("Attachment \"%s\" of document \"%s\" points outside of %s", name, docID, outputdir)
+		slog.Error(err)
+		return "", err
+	}
+	return filename, nil
+}
+
+func writeAttachmentToFile(cf *couchdbfile.CouchDbFile, a *couchdbfile.Attachment, filename string) error {
+	err := os.MkdirAll(filepath.Dir(filename), 0755)
+	if err != nil {
+		slog.Error(err)
+		return err
+	}
+	f, err := os.Create(filename)
+	if err != nil {
+		slog.Error(err)
+		return err
+	}
+	defer f.Close()
+	err = cf.WriteAttachment(a, f, true)
+	if err != nil {
+		slog.Error(err)
+		return err
+	}
+	return nil
+}
+
 func writeNodeToFile(cf *couchdbfile.CouchDbFile, offset int64, filename string) error {
 	f, err := os.Create(filename)
 	if err != nil {
@@ -5,6 +5,8 @@ package couchbytes
 
 import (
 	"bytes"
+	"compress/zlib"
+	"crypto/md5"
 	"encoding/binary"
 	"fmt"
 	"io"
@@ -19,10 +21,50 @@ const (
 	snappyPrefix   = 1
 	magicNumber    = 131
 	deflateSuffix  = 80
+	binaryTag      = 109
 )
 
-// ReadDbHeaderBytes reads DB header from input Reader at given offset and returns it as byte array
-func ReadDbHeaderBytes(input io.ReadSeeker, offset int64) (*[]byte, error) {
+// ChecksumError is returned when MD5 hash stored in the block does not
+// match the hash of the block data
+type ChecksumError struct {
+	Offset   int64
+	Expected []byte
+	Actual   []byte
+}
+
+// Error implements error interface
+func (e *ChecksumError) Error() string {
+	return fmt.Sprintf("MD5 mismatch in block at offset %d: stored %x, calculated %x", e.Offset, e.Expected, e.Actual)
+}
+
+// TruncatedError is returned when block extends past the end of the input
+type TruncatedError struct {
+	Offset   int64
+	Expected int
+	Actual   int
+}
+
+// Error implements error interface
+func (e *TruncatedError) Error() string {
+	return fmt.Sprintf("Truncated block at offset %d: expected %d bytes, read %d", e.Offset, e.Expected, e.Actual)
+}
+
+// verifyMD5 compares stored MD5 hash against the hash of the data
+func verifyMD5(offset int64, hash []byte, data []byte) error {
+	sum := md5.Sum(data)
+	if !bytes.Equal(hash, sum[:]) {
+		return &ChecksumError{
+			Offset:   offset,
+			Expected: append([]byte(nil), hash...),
+			Actual:   sum[:],
+		}
+	}
+	return nil
+}
+
+// ReadDbHeaderBytes reads DB header from input Reader at given offset and returns it as byte array.
+// If verify is set MD5 hash of the header is checked.
+func ReadDbHeaderBytes(input io.ReaderAt, offset int64, verify bool) (*[]byte, error) {
 	dataSize, bytesSkipped, err := readUint32Skip4K(input, offset)
 	if err != nil {
 		slog.Error(err)
@@ -33,45 +75,90 @@ func ReadDbHeaderBytes(input io.ReadSeeker, offset int64) (*[]byte, error) {
 		slog.Error(err)
 		return nil, err
 	}
-	/*
-		bufReader := bytes.NewReader(*buf)
-		var md5Hash [16]byte
-		err = binary.Read(bufReader, binary.BigEndian, &md5Hash)
+	if verify {
+		err = verifyMD5(offset, (*buf)[:16], (*buf)[16:])
 		if err != nil {
 			slog.Error(err)
 			return nil, err
 		}
-		var magicNumber uint8
-		err = binary.Read(bufReader, binary.BigEndian, &magicNumber)
+	}
+	// Skip MD5 hash, header term can be compressed same way as nodes
+	t := (*buf)[16:]
+	return uncompressBuffer(&t)
+}
+
+// ReadNodeBytes reads Node from input Reader at given offset and returns it as byte array.
+// Nodes are normally stored without MD5 hash, if there is one and verify
+// is set the hash is checked.
+func ReadNodeBytes(input io.ReaderAt, offset int64, verify bool) (*[]byte, error) {
+	buf, err := ReadChunkBytes(input, offset, verify)
+	if err != nil {
+		slog.Error(err)
+		return nil, err
+	}
+	return uncompressBuffer(buf)
+}
+
+// ReadChunkBytes reads length prefixed data chunk from input Reader at
+// given offset and returns it as is. Chunk can be stored with or without
+// MD5 hash, if there is one and verify is set the hash is checked.
+func ReadChunkBytes(input io.ReaderAt, offset int64, verify bool) (*[]byte, error) {
+	combinedSize, bytesSkipped, err := readUint32Skip4K(input, offset)
+	if err != nil {
+		slog.Error(err)
+		return nil, err
+	}
+	md5Flag := (combinedSize & (1 << 31)) >> 31
+	dataSize := combinedSize &^ (1 << 31)
+	if md5Flag != 1 {
+		buf, _, err := readAndSkip4K(input, offset+4+bytesSkipped, dataSize)
 		if err != nil {
 			slog.Error(err)
 			return nil, err
-		}*/
-	t := (*buf)[17:]
-	return &t, nil
-}
-
-// ReadNodeBytes reads Node from input Reader at given offset and returns it as byte array
-func ReadNodeBytes(input io.ReadSeeker, offset int64) (*[]byte, error) {
-	dataSize, bytesSkipped, err := readUint32Skip4K(input, offset)
+		}
+		return buf, nil
+	}
+	buf, _, err := readAndSkip4K(input, offset+4+bytesSkipped, dataSize+16)
 	if err != nil {
 		slog.Error(err)
 		return nil, err
 	}
-	buf, _, err := readAndSkip4K(input, offset+4+bytesSkipped, dataSize)
+	if verify {
+		err = verifyMD5(offset, (*buf)[:16], (*buf)[16:])
+		if err != nil {
+			slog.Error(err)
+			return nil, err
+		}
+	}
+	t := (*buf)[16:]
+	return &t, nil
+}
+
+// ReadDocumentBytes reads actual stored document from input Reader at given offset and returns it as byte array.
+// If verify is set MD5 hash of the document block is checked.
+func ReadDocumentBytes(input io.ReaderAt, offset int64, verify bool) (*[]byte, error) {
+	docBytes, _, err := readDocumentSummary(input, offset, verify, false)
 	if err != nil {
 		slog.Error(err)
 		return nil, err
 	}
-	return uncompressBuffer(buf)
+	return docBytes, nil
+}
+
+// ReadDocumentSummaryBytes reads stored document and its attachment list
+// from input Reader at given offset and returns both as byte arrays.
+// If verify is set MD5 hash of the document block is checked.
+func ReadDocumentSummaryBytes(input io.ReaderAt, offset int64, verify bool) (*[]byte, *[]byte, error) {
+	return readDocumentSummary(input, offset, verify, true)
 }
 
-// ReadDocumentBytes reads actual stored document from input Reader at given offset and returns it as byte array
-func ReadDocumentBytes(input io.ReadSeeker, offset int64) (*[]byte, error) {
+// readDocumentSummary reads document summary block which is serialised
+// {Body, Attachments} tuple of two (compressed) binaries
+func readDocumentSummary(input io.ReaderAt, offset int64, verify bool, withAttachments bool) (*[]byte, *[]byte, error) {
 	combinedSize, bytesSkipped, err := readUint32Skip4K(input, offset)
 	if err != nil {
 		slog.Error(err)
-		return nil, err
+		return nil, nil, err
 	}
 	md5Flag := (combinedSize & (1 << 31)) >> 31
 	dataSize := combinedSize &^ (1 << 31)
@@ -79,26 +166,67 @@ func ReadDocumentBytes(input io.ReadSeeker, offset int64) (*[]byte, error) {
 	if md5Flag != 1 {
 		err := fmt.Errorf("Unknown document block header %v", md5Flag)
 		slog.Error(err)
-		return nil, err
+		return nil, nil, err
 	}
 	buf, _, err := readAndSkip4K(input, offset+4+bytesSkipped, dataSize+16)
 	if err != nil {
 		slog.Error(err)
-		return nil, err
+		return nil, nil, err
+	}
+	if verify {
+		err = verifyMD5(offset, (*buf)[:16], (*buf)[16:])
+		if err != nil {
+			slog.Error(err)
+			return nil, nil, err
+		}
+	}
+	// Summary is magic number, tuple tag and arity, then two binaries
+	// each tagged and prefixed with 32 bit size. Sizes are checked in
+	// int64 so a torn block can not overflow them.
+	bufSize := int64(len(*buf))
+	if bufSize < 24 || (*buf)[19] != binaryTag {
+		err := fmt.Errorf("Unexpected document summary layout at offset %d", offset)
+		slog.Error(err)
+		return nil, nil, err
+	}
+	docSize := int64(binary.BigEndian.Uint32((*buf)[20:24]))
+	if 24+docSize > bufSize {
+		err := fmt.Errorf("Document body of %d bytes does not fit summary block at offset %d", docSize, offset)
+		slog.Error(err)
+		return nil, nil, err
+	}
+
+	var attBytes *[]byte
+	if withAttachments {
+		attStart := 24 + docSize
+		if bufSize < attStart+5 || (*buf)[attStart] != binaryTag {
+			err := fmt.Errorf("Unexpected document summary layout at offset %d", offset)
+			slog.Error(err)
+			return nil, nil, err
+		}
+		attSize := int64(binary.BigEndian.Uint32((*buf)[attStart+1 : attStart+5]))
+		if attStart+5+attSize > bufSize {
+			err := fmt.Errorf("Attachments of %d bytes do not fit summary block at offset %d", attSize, offset)
+			slog.Error(err)
+			return nil, nil, err
+		}
+		// Copy attachments out as uncompressing document body releases the buffer
+		attCopy := leakybucket.GetBytes(int32(attSize))
+		copy(*attCopy, (*buf)[attStart+5:attStart+5+attSize])
+		attBytes, err = uncompressBuffer(attCopy)
+		if err != nil {
+			slog.Error(err)
+			return nil, nil, err
+		}
 	}
-	/*
-		md5Hash := (*buf)[:16]
-		slog.Debug(hex.EncodeToString(md5Hash))
-	*/
-	docSize := binary.BigEndian.Uint32((*buf)[20:24])
 
 	docSlice := (*buf)[24 : docSize+24]
 	docBytes, err := uncompressBuffer(&docSlice)
 	if err != nil {
 		slog.Error(err)
-		return nil, err
+		return nil, nil, err
 	}
-	return docBytes, nil
+	return docBytes, attBytes, nil
 }
 
 // uncompressBuffer uncompresses buffer if needed
@@ -106,6 +234,11 @@ func ReadDocumentBytes(input io.ReadSeeker, offset int64) (*[]byte, error) {
 // CouchDB on how Snappy and Deflate compressions are
 // described in the data file
 func uncompressBuffer(buf *[]byte) (*[]byte, error) {
+	if len(*buf) == 0 {
+		err := fmt.Errorf("Can not uncompress empty block")
+		slog.Error(err)
+		return nil, err
+	}
 	b := uint8((*buf)[0])
 	switch b {
 	case snappyPrefix:
@@ -126,9 +259,7 @@ func uncompressBuffer(buf *[]byte) (*[]byte, error) {
 		b := uint8((*buf)[1])
 		if b == deflateSuffix {
 			// slog.Debug("Deflate compressed node")
-			err := fmt.Errorf("Deflate un-compression is not implemented yet")
-			slog.Error(err)
-			return nil, err
+			return inflateBuffer(buf)
 		}
 		// slog.Debug("Uncompressed node")
 		t := (*buf)[1:]
@@ -140,51 +271,78 @@ func uncompressBuffer(buf *[]byte) (*[]byte, error) {
 	}
 }
 
-// readInt32Skip4K reads data into 32 bit uint32 and skips 4K hole
-func readUint32Skip4K(input io.ReadSeeker, offset int64) (uint32, int64, error) {
-	buf, bytesSkipped, err := readAndSkip4K(input, offset, 4)
+// inflateBuffer uncompresses Erlang compressed term format where
+// magic number and deflate tag are followed by 4 byte uncompressed
+// size and zlib stream
+func inflateBuffer(buf *[]byte) (*[]byte, error) {
+	if len(*buf) < 6 {
+		err := fmt.Errorf("Deflate compressed block is too short: %v bytes", len(*buf))
+		slog.Error(err)
+		return nil, err
+	}
+	dataSize := binary.BigEndian.Uint32((*buf)[2:6])
+	zr, err := zlib.NewReader(bytes.NewReader((*buf)[6:]))
 	if err != nil {
 		slog.Error(err)
-		return 0, 0, err
+		return nil, err
 	}
-	defer leakybucket.PutBytes(buf)
-	bufReader := *bytes.NewReader(*buf)
-	var result uint32
-	err = binary.Read(&bufReader, binary.BigEndian, &result)
+	defer zr.Close()
+	destBuf := leakybucket.GetBytes(int32(dataSize))
+	_, err = io.ReadFull(zr, *destBuf)
+	if err != nil {
+		slog.Error(err)
+		return nil, err
+	}
+	// Release compressed buffer
+	leakybucket.PutBytes(buf)
+	return destBuf, nil
+}
+
+// readUint32Skip4K reads data into 32 bit uint32 and skips 4K hole
+func readUint32Skip4K(input io.ReaderAt, offset int64) (uint32, int64, error) {
+	buf, bytesSkipped, err := readAndSkip4K(input, offset, 4)
 	if err != nil {
 		slog.Error(err)
 		return 0, 0, err
 	}
-	return result, bytesSkipped, nil
+	defer leakybucket.PutBytes(buf)
+	return binary.BigEndian.Uint32(*buf), bytesSkipped, nil
 }
 
-// ReadAndSkip4K reads data into bye slice and skips 4K holes
-func readAndSkip4K(input io.ReadSeeker, offset int64, dataSize uint32) (*[]byte, int64, error) {
+// readAndSkip4K reads data into byte slice and skips 4K holes
+func readAndSkip4K(input io.ReaderAt, offset int64, dataSize uint32) (*[]byte, int64, error) {
 	// We need to work around CouchDB storage system where 4K aligned bytes
 	// need to be removed before processing
-	_, err := input.Seek(offset, io.SeekStart)
-	if err != nil {
-		if err != io.EOF {
-			slog.Error("Error on seeking while reading buffer.", err)
-			return nil, 0, err
-		}
+	if offset < 0 {
+		err := fmt.Errorf("Invalid block offset %d", offset)
+		slog.Error(err)
+		return nil, 0, err
 	}
 	// Get lower bound of 4K multiplier to offset
 	lowerBound := offset / int64(BlockAlignment)
 	if offset%int64(BlockAlignment) == 0 {
 		lowerBound--
 	}
-	// Get upper bound of 4K multiplier to offset
-	upperBound := (offset + int64(dataSize)) / int64(BlockAlignment)
+	// Get upper bound of 4K multiplier to offset. Only boundaries strictly
+	// inside the span count and every skipped byte extends the span, so
+	// data ending right before a boundary does not eat into the next block.
+	upperBound := lowerBound
+	end := offset + int64(dataSize)
+	for (upperBound+1)*int64(BlockAlignment) < end {
+		upperBound++
+		end++
+	}
 
-	// Read into byte array
+	// Read into byte array, ReadAt fills the whole buffer or fails
 	buf := leakybucket.GetBytes(int32(dataSize) + int32(upperBound-lowerBound))
-	_, err = input.Read(*buf)
-	if err != nil {
-		if err != io.EOF {
-			slog.Error("Error reading buffer.", err)
-			return nil, 0, err
+	n, err := input.ReadAt(*buf, offset)
+	if n < len(*buf) {
+		if err == nil || err == io.EOF {
+			err = &TruncatedError{Offset: offset, Expected: len(*buf), Actual: n}
 		}
+		leakybucket.PutBytes(buf)
+		slog.Error(err)
+		return nil, 0, err
 	}
 	for i := upperBound; i > lowerBound; i-- {
 		// Cycle from back to forward and remove byte on 4K boundary
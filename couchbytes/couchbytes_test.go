@@ -0,0 +1,205 @@
+package couchbytes
+
+import (
+	"bytes"
+	"compress/zlib"
+	"crypto/md5"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"testing"
+)
+
+// layout returns file holding data at offset with a marker byte at every
+// 4K boundary the way CouchDB writes it, file ends right after the data
+func layout(offset int64, data []byte) []byte {
+	file := make([]byte, offset, offset+int64(len(data))+int64(len(data))/BlockAlignment+1)
+	for _, b := range data {
+		if len(file)%BlockAlignment == 0 {
+			file = append(file, 0)
+		}
+		file = append(file, b)
+	}
+	return file
+}
+
+func TestReadAndSkip4K(t *testing.T) {
+	tests := []struct {
+		offset int64
+		size   int
+		holes  int64
+	}{
+		{100, 20, 0},
+		{1, BlockAlignment - 1, 0},
+		{4000, 96, 0},
+		{4000, 97, 1},
+		{BlockAlignment, 10, 1},
+		{BlockAlignment, BlockAlignment - 1, 1},
+		{BlockAlignment, BlockAlignment, 2},
+		{4000, 2*BlockAlignment - 4000 + BlockAlignment - 2, 2},
+		{4000, 2*BlockAlignment - 4000 + BlockAlignment - 1, 3},
+	}
+	for _, test := range tests {
+		data := make([]byte, test.size)
+		for i := range data {
+			data[i] = byte(i%251) + 1
+		}
+		file := layout(test.offset, data)
+		buf, holes, err := readAndSkip4K(bytes.NewReader(file), test.offset, uint32(test.size))
+		if err != nil {
+			t.Errorf("Offset %d size %d: %v", test.offset, test.size, err)
+			continue
+		}
+		if !bytes.Equal(*buf, data) {
+			t.Errorf("Offset %d size %d: data mismatch", test.offset, test.size)
+		}
+		if holes != test.holes {
+			t.Errorf("Offset %d size %d: expected %d holes, got %d", test.offset, test.size, test.holes, holes)
+		}
+	}
+}
+
+// testTerm returns serialised Erlang list of binaries without magic number,
+// long enough for compressed block to cross 4K boundary at low levels
+func testTerm() []byte {
+	var term bytes.Buffer
+	term.Write([]byte{108, 0, 0, 2, 0})
+	for i := 0; i < 512; i++ {
+		value := []byte(fmt.Sprintf("value-%d-%x", i, md5.Sum([]byte{byte(i), byte(i >> 8)})))
+		term.WriteByte(binaryTag)
+		binary.Write(&term, binary.BigEndian, uint32(len(value)))
+		term.Write(value)
+	}
+	term.WriteByte(106)
+	return term.Bytes()
+}
+
+// deflateTerm compresses term the way term_to_binary does with given
+// compression level: magic number, deflate tag, uncompressed size and zlib
+// stream of the term
+func deflateTerm(t *testing.T, term []byte, level int) []byte {
+	var out bytes.Buffer
+	out.Write([]byte{magicNumber, deflateSuffix})
+	binary.Write(&out, binary.BigEndian, uint32(len(term)))
+	zw, err := zlib.NewWriterLevel(&out, level)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw.Write(term)
+	err = zw.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out.Bytes()
+}
+
+// chunk prefixes data with its size and optional MD5 hash
+func chunk(data []byte, withMD5 bool) []byte {
+	var out bytes.Buffer
+	size := uint32(len(data))
+	if withMD5 {
+		size |= 1 << 31
+	}
+	binary.Write(&out, binary.BigEndian, size)
+	if withMD5 {
+		sum := md5.Sum(data)
+		out.Write(sum[:])
+	}
+	out.Write(data)
+	return out.Bytes()
+}
+
+// binaryTerm serialises byte slice as Erlang binary
+func binaryTerm(data []byte) []byte {
+	var out bytes.Buffer
+	out.WriteByte(binaryTag)
+	binary.Write(&out, binary.BigEndian, uint32(len(data)))
+	out.Write(data)
+	return out.Bytes()
+}
+
+func TestDeflateLevels(t *testing.T) {
+	term := testTerm()
+	attachments := []byte{106}
+	for level := 1; level <= 9; level++ {
+		compressed := deflateTerm(t, term, level)
+
+		node := layout(4000, chunk(compressed, false))
+		got, err := ReadNodeBytes(bytes.NewReader(node), 4000, true)
+		if err != nil {
+			t.Errorf("deflate_%d node: %v", level, err)
+		} else if !bytes.Equal(*got, term) {
+			t.Errorf("deflate_%d node: term mismatch", level)
+		}
+
+		// Header block starts on 4K boundary with header marker
+		header := layout(BlockAlignment, chunk(compressed, true))
+		header[BlockAlignment] = 1
+		// Header size covers MD5 hash and has no MD5 flag
+		binary.BigEndian.PutUint32(header[BlockAlignment+1:], uint32(len(compressed)+16))
+		got, err = ReadDbHeaderBytes(bytes.NewReader(header), BlockAlignment, true)
+		if err != nil {
+			t.Errorf("deflate_%d header: %v", level, err)
+		} else if !bytes.Equal(*got, term) {
+			t.Errorf("deflate_%d header: term mismatch", level)
+		}
+
+		// Document summary is {Body, Attachments} tuple of compressed terms
+		summary := append([]byte{magicNumber, 104, 2}, binaryTerm(compressed)...)
+		summary = append(summary, binaryTerm(deflateTerm(t, attachments, level))...)
+		doc := layout(100, chunk(summary, true))
+		body, atts, err := ReadDocumentSummaryBytes(bytes.NewReader(doc), 100, true)
+		if err != nil {
+			t.Errorf("deflate_%d body: %v", level, err)
+			continue
+		}
+		if !bytes.Equal(*body, term) {
+			t.Errorf("deflate_%d body: term mismatch", level)
+		}
+		if !bytes.Equal(*atts, attachments) {
+			t.Errorf("deflate_%d attachments: got %v", level, *atts)
+		}
+	}
+}
+
+func TestTornSummary(t *testing.T) {
+	compressed := deflateTerm(t, testTerm(), 6)
+	summary := append([]byte{magicNumber, 104, 2}, binaryTerm(compressed)...)
+	summary = append(summary, binaryTerm([]byte{magicNumber, 106})...)
+	block := chunk(summary, true)
+	file := layout(100, block)
+	for _, cut := range []int{0, 3, 4, 20, 24, 30, len(block) / 2, len(block) - 1} {
+		// File ends inside the block
+		_, _, err := ReadDocumentSummaryBytes(bytes.NewReader(file[:100+cut]), 100, true)
+		var truncatedErr *TruncatedError
+		if !errors.As(err, &truncatedErr) {
+			t.Errorf("File cut at %d: expected truncated error, got %v", cut, err)
+		}
+	}
+	for _, cut := range []int{0, 3, 7, 8, 100, len(summary) - 8, len(summary) - 7, len(summary) - 1} {
+		// Block is whole but the summary inside it is not, body alone
+		// is complete when only attachments are cut off
+		torn := layout(100, chunk(summary[:cut], true))
+		for _, withAttachments := range []bool{false, true} {
+			_, _, err := readDocumentSummary(bytes.NewReader(torn), 100, false, withAttachments)
+			if err == nil && (withAttachments || cut < len(summary)-7) {
+				t.Errorf("Summary cut at %d: expected error", cut)
+			}
+		}
+	}
+
+	// Sizes near uint32 limit do not overflow
+	huge := append([]byte(nil), summary...)
+	binary.BigEndian.PutUint32(huge[4:], math.MaxUint32-10)
+	_, _, err := ReadDocumentSummaryBytes(bytes.NewReader(layout(100, chunk(huge, true))), 100, true)
+	if err == nil {
+		t.Error("Expected error for body size past the block")
+	}
+	huge = append([]byte(nil), summary...)
+	binary.BigEndian.PutUint32(huge[3+5+len(compressed)+1:], math.MaxUint32)
+	_, _, err = ReadDocumentSummaryBytes(bytes.NewReader(layout(100, chunk(huge, true))), 100, true)
+	if err == nil {
+		t.Error("Expected error for attachments size past the block")
+	}
+}
@@ -0,0 +1,31 @@
+package couchbytes
+
+import (
+	"fmt"
+	"io"
+)
+
+// MappedFile is io.ReaderAt over memory mapped file
+type MappedFile struct {
+	data []byte
+}
+
+// ReadAt implements io.ReaderAt
+func (m *MappedFile) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("Negative offset %d", off)
+	}
+	if off >= int64(len(m.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, m.data[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// Size returns length of the mapped file
+func (m *MappedFile) Size() int64 {
+	return int64(len(m.data))
+}
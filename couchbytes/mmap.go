@@ -0,0 +1,38 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd || dragonfly
+// +build linux darwin freebsd netbsd openbsd dragonfly
+
+package couchbytes
+
+import (
+	"os"
+	"syscall"
+)
+
+// Mmap maps the whole file read only into memory. Reads from the mapping
+// do not need system calls which helps when nodes are read concurrently.
+func Mmap(f *os.File) (*MappedFile, error) {
+	fi, err := f.Stat()
+	if err != nil {
+		slog.Error(err)
+		return nil, err
+	}
+	if fi.Size() == 0 {
+		return &MappedFile{}, nil
+	}
+	data, err := syscall.Mmap(int(f.Fd()), 0, int(fi.Size()), syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		slog.Error(err)
+		return nil, err
+	}
+	return &MappedFile{data: data}, nil
+}
+
+// Close unmaps the file, MappedFile can not be used afterwards
+func (m *MappedFile) Close() error {
+	if m.data == nil {
+		return nil
+	}
+	data := m.data
+	m.data = nil
+	return syscall.Munmap(data)
+}
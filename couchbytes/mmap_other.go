@@ -0,0 +1,21 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd && !dragonfly
+// +build !linux,!darwin,!freebsd,!netbsd,!openbsd,!dragonfly
+
+package couchbytes
+
+import (
+	"fmt"
+	"os"
+)
+
+// Mmap is not supported on this platform
+func Mmap(f *os.File) (*MappedFile, error) {
+	err := fmt.Errorf("Memory mapped files are not supported on this platform")
+	slog.Error(err)
+	return nil, err
+}
+
+// Close implements io.Closer
+func (m *MappedFile) Close() error {
+	return nil
+}
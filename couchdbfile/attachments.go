@@ -0,0 +1,207 @@
+package couchdbfile
+
+import (
+	"compress/gzip"
+	"encoding/base64"
+	"fmt"
+	"io"
+
+	"github.com/pipedrive/uncouch/couchbytes"
+	"github.com/pipedrive/uncouch/erldeser"
+	"github.com/pipedrive/uncouch/leakybucket"
+	"github.com/pipedrive/uncouch/termite"
+)
+
+// Attachment is metadata of document attachment stored in CouchDB file.
+// Length is the size of stored (possibly encoded) data and DiskLength
+// is the size of decoded data.
+type Attachment struct {
+	Name        string
+	ContentType string
+	Length      int64
+	DiskLength  int64
+	RevPos      int64
+	Digest      []byte
+	Encoding    string
+	Pointers    []StreamPointer
+}
+
+// StreamPointer points to single chunk of attachment data
+type StreamPointer struct {
+	Offset int64
+	Size   int64
+}
+
+// Stub returns attachment stub as CouchDB shows it in _attachments object
+func (a *Attachment) Stub() map[string]interface{} {
+	stub := map[string]interface{}{
+		"content_type": a.ContentType,
+		"revpos":       a.RevPos,
+		"length":       a.DiskLength,
+		"stub":         true,
+	}
+	if len(a.Digest) > 0 {
+		stub["digest"] = "md5-" + base64.StdEncoding.EncodeToString(a.Digest)
+	}
+	if a.Encoding != "identity" {
+		stub["encoding"] = a.Encoding
+		stub["encoded_length"] = a.Length
+	}
+	return stub
+}
+
+// ReadAttachments reads metadata of attachments stored with the revision
+func (cf *CouchDbFile) ReadAttachments(r *Revision) ([]Attachment, error) {
+	if r.Offset < 0 {
+		err := fmt.Errorf("Body of revision %s is not stored in the file", r.String())
+		slog.Error(err)
+		return nil, err
+	}
+	docBytes, attBytes, err := couchbytes.ReadDocumentSummaryBytes(cf.input, r.Offset, cf.opts.Verify)
+	if err != nil {
+		slog.Error(err)
+		return nil, err
+	}
+	leakybucket.PutBytes(docBytes)
+	defer leakybucket.PutBytes(attBytes)
+	return readAttachments(*attBytes)
+}
+
+// readAttachments parses attachment list term
+func readAttachments(buf []byte) ([]Attachment, error) {
+	s, err := erldeser.NewScanner(buf)
+	if err != nil {
+		slog.Error(err)
+		return nil, err
+	}
+	tb, err := termite.NewBuilder()
+	if err != nil {
+		slog.Error(err)
+		return nil, err
+	}
+	t, err := tb.ReadTermite(s)
+	if err != nil {
+		slog.Error(err)
+		return nil, err
+	}
+	defer t.Release()
+	if t.T.Term != erldeser.ListExt {
+		return nil, nil
+	}
+	// Last child is the list tail
+	attachments := make([]Attachment, 0, len(t.Children)-1)
+	for _, at := range t.Children[:len(t.Children)-1] {
+		var a Attachment
+		switch len(at.Children) {
+		case 8:
+			// {Name, Type, Sp, AttLen, DiskLen, RevPos, Md5, Enc}
+			a.Length = at.Children[3].T.IntegerValue
+			a.DiskLength = at.Children[4].T.IntegerValue
+			a.RevPos = at.Children[5].T.IntegerValue
+			a.Digest = append([]byte(nil), at.Children[6].T.Binary...)
+			a.Encoding = attachmentEncoding(string(at.Children[7].T.Binary))
+		case 6:
+			// Pre-encoding format {Name, Type, Sp, AttLen, RevPos, Md5}
+			a.Length = at.Children[3].T.IntegerValue
+			a.DiskLength = a.Length
+			a.RevPos = at.Children[4].T.IntegerValue
+			a.Digest = append([]byte(nil), at.Children[5].T.Binary...)
+			a.Encoding = "identity"
+		default:
+			err := fmt.Errorf("Unknown attachment format with %d fields", len(at.Children))
+			slog.Error(err)
+			return nil, err
+		}
+		a.Name = string(at.Children[0].T.Binary)
+		a.ContentType = string(at.Children[1].T.Binary)
+		a.Pointers = readStreamPointers(at.Children[2])
+		attachments = append(attachments, a)
+	}
+	return attachments, nil
+}
+
+// attachmentEncoding maps stored encoding atom to encoding name,
+// old files used boolean for gzip encoding
+func attachmentEncoding(enc string) string {
+	switch enc {
+	case "true":
+		return "gzip"
+	case "false":
+		return "identity"
+	default:
+		return enc
+	}
+}
+
+// readStreamPointers reads list of {Offset, Size} chunk pointers,
+// older files stored plain offsets
+func readStreamPointers(t *termite.Termite) []StreamPointer {
+	if t.T.Term != erldeser.ListExt {
+		return nil
+	}
+	pointers := make([]StreamPointer, 0, len(t.Children)-1)
+	for _, p := range t.Children[:len(t.Children)-1] {
+		if p.T.Term == erldeser.SmallTupleExt {
+			pointers = append(pointers, StreamPointer{
+				Offset: p.Children[0].T.IntegerValue,
+				Size:   p.Children[1].T.IntegerValue,
+			})
+		} else {
+			pointers = append(pointers, StreamPointer{Offset: p.T.IntegerValue, Size: -1})
+		}
+	}
+	return pointers
+}
+
+// attachmentReader reads attachment data chunk by chunk
+type attachmentReader struct {
+	cf       *CouchDbFile
+	pointers []StreamPointer
+	buf      *[]byte
+	pos      int
+}
+
+// Read implements io.Reader
+func (ar *attachmentReader) Read(p []byte) (int, error) {
+	for ar.buf == nil || ar.pos >= len(*ar.buf) {
+		if ar.buf != nil {
+			leakybucket.PutBytes(ar.buf)
+			ar.buf = nil
+		}
+		if len(ar.pointers) == 0 {
+			return 0, io.EOF
+		}
+		buf, err := couchbytes.ReadChunkBytes(ar.cf.input, ar.pointers[0].Offset, ar.cf.opts.Verify)
+		if err != nil {
+			slog.Error(err)
+			return 0, err
+		}
+		ar.pointers = ar.pointers[1:]
+		ar.buf = buf
+		ar.pos = 0
+	}
+	n := copy(p, (*ar.buf)[ar.pos:])
+	ar.pos += n
+	return n, nil
+}
+
+// WriteAttachment writes attachment data to output. If decode is set
+// gzip encoded attachments are written decoded.
+func (cf *CouchDbFile) WriteAttachment(a *Attachment, output io.Writer, decode bool) error {
+	var input io.Reader = &attachmentReader{cf: cf, pointers: a.Pointers}
+	if decode && a.Encoding == "gzip" {
+		zr, err := gzip.NewReader(input)
+		if err != nil {
+			slog.Error(err)
+			return err
+		}
+		defer zr.Close()
+		input = zr
+	}
+	_, err := io.Copy(output, input)
+	if err != nil {
+		slog.Error(err)
+		return err
+	}
+	return nil
+}
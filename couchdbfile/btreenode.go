@@ -50,9 +50,14 @@ type DocumentInfo struct {
 	Revisions []Revision
 }
 
-// Revision is a subset of data in CouchDB Btree node we need for data extraction
+// Revision is a subset of data in CouchDB Btree node we need for data extraction.
+// Pos is revision number and Parent is index of parent revision in
+// DocumentInfo.Revisions or -1 for the root of the branch.
 type Revision struct {
 	RevID     []byte
+	Pos       int64
+	Parent    int
+	Leaf      bool
 	Offset    int64
 	UpdateSeq int64
 	Deleted   int8
@@ -102,33 +107,72 @@ func (n *KvNode) readFromTermite(t *termite.Termite) error {
 
 	for i := int32(0); i < n.Length; i++ {
 		t1 := t.Children[1].Children[i]
-		n.Documents[i].ID = append([]byte(nil), t1.Children[1].Children[0].T.Binary...)
-		n.Documents[i].UpdateSeq = t1.Children[1].Children[0].T.IntegerValue
+		if t1.Children[0].T.Term == erldeser.BinaryExt {
+			// ID Btree entry {Id, {Seq, Deleted, Sizes, RevTree}}
+			n.Documents[i].ID = append([]byte(nil), t1.Children[0].T.Binary...)
+			n.Documents[i].UpdateSeq = t1.Children[1].Children[0].T.IntegerValue
+		} else {
+			// Sequence Btree entry {Seq, {Id, Deleted, Sizes, RevTree}}
+			n.Documents[i].ID = append([]byte(nil), t1.Children[1].Children[0].T.Binary...)
+			n.Documents[i].UpdateSeq = t1.Children[0].T.IntegerValue
+		}
 		n.Documents[i].Deleted = int8(t1.Children[1].Children[1].T.IntegerValue)
 		n.Documents[i].Size1 = int32(t1.Children[1].Children[2].Children[0].T.IntegerValue)
 		n.Documents[i].Size2 = int32(t1.Children[1].Children[2].Children[1].T.IntegerValue)
-		n.Documents[i].Revisions = make([]Revision, 0, 5)
-		revNode := t1.Children[1].Children[3].Children[0].Children[1]
-		// What this extra list tuple(2) wrapper is doing here?
-		// Branching?
-		for {
-			r := new(Revision)
-			r.RevID = append([]byte(nil), revNode.Children[0].T.Binary...)
-			if revNode.Children[1].T.Term == erldeser.NilExt {
-				r.Offset = -1
+		n.Documents[i].Revisions = readRevTree(t1.Children[1].Children[3])
+	}
+	return nil
+}
+
+// readRevTree flattens revision tree into Revision list. Tree is stored
+// as list of {Pos, Node} branches where Pos is revision number of branch
+// root and Node is {RevId, Value, [Children]}. Revisions are listed in
+// depth first order, each of them pointing to its parent.
+func readRevTree(t *termite.Termite) []Revision {
+	revisions := make([]Revision, 0, 5)
+	if t.T.Term != erldeser.ListExt {
+		return revisions
+	}
+	// Last child is the list tail
+	for _, branch := range t.Children[:len(t.Children)-1] {
+		revisions = readRevNode(branch.Children[1], branch.Children[0].T.IntegerValue, -1, revisions)
+	}
+	return revisions
+}
+
+// readRevNode adds revision tree node and its descendants to revisions
+func readRevNode(node *termite.Termite, pos int64, parent int, revisions []Revision) []Revision {
+	r := Revision{
+		RevID:  append([]byte(nil), node.Children[0].T.Binary...),
+		Pos:    pos,
+		Parent: parent,
+	}
+	value := node.Children[1]
+	if value.T.Term == erldeser.NilExt {
+		// Revision body is missing
+		r.Offset = -1
+	} else {
+		r.Deleted = int8(value.Children[0].T.IntegerValue)
+		r.Offset = value.Children[1].T.IntegerValue
+		r.UpdateSeq = value.Children[2].T.IntegerValue
+		if len(value.Children) > 3 {
+			if value.Children[3].T.Term == erldeser.SmallTupleExt {
+				r.Size1 = int32(value.Children[3].Children[0].T.IntegerValue)
+				r.Size2 = int32(value.Children[3].Children[1].T.IntegerValue)
 			} else {
-				r.Deleted = int8(revNode.Children[1].Children[0].T.IntegerValue)
-				r.Offset = revNode.Children[1].Children[1].T.IntegerValue
-				r.UpdateSeq = revNode.Children[1].Children[2].T.IntegerValue
-				r.Size1 = int32(revNode.Children[1].Children[3].Children[0].T.IntegerValue)
-				r.Size2 = int32(revNode.Children[1].Children[3].Children[1].T.IntegerValue)
-			}
-			n.Documents[i].Revisions = append(n.Documents[i].Revisions, *r)
-			if revNode.Children[2].T.Term == erldeser.NilExt {
-				break
+				r.Size1 = int32(value.Children[3].T.IntegerValue)
 			}
-			revNode = revNode.Children[2].Children[0]
 		}
 	}
-	return nil
+	index := len(revisions)
+	revisions = append(revisions, r)
+	children := node.Children[2]
+	if children.T.Term != erldeser.ListExt {
+		revisions[index].Leaf = true
+		return revisions
+	}
+	for _, child := range children.Children[:len(children.Children)-1] {
+		revisions = readRevNode(child, pos+1, index, revisions)
+	}
+	return revisions
 }
@@ -0,0 +1,107 @@
+package couchdbfile
+
+import (
+	"context"
+)
+
+// ChangeRevision is revision entry of the change row
+type ChangeRevision struct {
+	Rev string `json:"rev"`
+}
+
+// Change is row of the changes feed in the same shape as CouchDB _changes
+// returns it. Only the latest update of every document is present in the
+// seq tree, so each document appears at most once.
+type Change struct {
+	Seq     int64                  `json:"seq"`
+	ID      string                 `json:"id"`
+	Changes []ChangeRevision       `json:"changes"`
+	Deleted bool                   `json:"deleted,omitempty"`
+	Doc     map[string]interface{} `json:"doc,omitempty"`
+}
+
+// ChangesIterator is cursor over changes feed of CouchDbFile
+type ChangesIterator struct {
+	ctx    context.Context
+	cf     *CouchDbFile
+	w      *treeWalker
+	limit  int64
+	count  int64
+	di     *DocumentInfo
+	change *Change
+	err    error
+}
+
+// Changes returns iterator over documents updated after since sequence in
+// update sequence order. Limit caps number of rows, 0 means no limit.
+func (cf *CouchDbFile) Changes(ctx context.Context, since, limit int64) *ChangesIterator {
+	return &ChangesIterator{
+		ctx:   ctx,
+		cf:    cf,
+		w:     cf.newTreeWalker(DocumentsOptions{Tree: SeqTree, Since: since}),
+		limit: limit,
+	}
+}
+
+// Next advances iterator to the next change. It returns false when
+// there are no more changes, limit was reached, iteration was cancelled
+// or error occurred.
+func (it *ChangesIterator) Next() bool {
+	it.di = nil
+	it.change = nil
+	if it.err != nil || (it.limit > 0 && it.count >= it.limit) {
+		return false
+	}
+	var (
+		di     *DocumentInfo
+		winner *Revision
+		err    error
+	)
+	for winner == nil {
+		select {
+		case <-it.ctx.Done():
+			it.err = it.ctx.Err()
+			return false
+		default:
+		}
+		di, err = it.w.next()
+		if err != nil {
+			slog.Error(err)
+			it.err = err
+			return false
+		}
+		if di == nil {
+			return false
+		}
+		winner = di.WinningRevision()
+	}
+	it.count++
+	it.di = di
+	it.change = &Change{
+		Seq:     di.UpdateSeq,
+		ID:      string(di.ID),
+		Changes: []ChangeRevision{{Rev: winner.String()}},
+		Deleted: winner.Deleted != 0,
+	}
+	return true
+}
+
+// Change returns change row iterator is currently positioned at
+func (it *ChangesIterator) Change() *Change {
+	return it.change
+}
+
+// Info returns DocumentInfo of the current change with all its revisions
+func (it *ChangesIterator) Info() *DocumentInfo {
+	return it.di
+}
+
+// Doc reads winning revision of the document of the current change
+func (it *ChangesIterator) Doc() (*CouchDbDocument, error) {
+	return it.cf.ReadDocument(it.di)
+}
+
+// Err returns error which stopped the iteration, if any
+func (it *ChangesIterator) Err() error {
+	return it.err
+}
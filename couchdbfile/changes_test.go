@@ -0,0 +1,89 @@
+package couchdbfile
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// readChanges returns changes rows as "seq id rev" joined with comma
+func readChanges(t *testing.T, cf *CouchDbFile, since, limit int64) string {
+	it := cf.Changes(context.Background(), since, limit)
+	var rows []string
+	for it.Next() {
+		c := it.Change()
+		row := fmt.Sprint(c.Seq, " ", c.ID)
+		if c.Deleted {
+			row += " deleted"
+		}
+		if len(c.Changes) != 1 || string(it.Info().ID) != c.ID {
+			t.Errorf("Unexpected change row %+v", c)
+		}
+		rows = append(rows, row)
+	}
+	if it.Err() != nil {
+		t.Fatal(it.Err())
+	}
+	if it.Change() != nil || it.Next() {
+		t.Error("Expected iterator to stay at the end")
+	}
+	return strings.Join(rows, ",")
+}
+
+func TestChanges(t *testing.T) {
+	cf, _ := openFixture(t, smallFixture)
+	all := []string{"3 c", "5 user:000", "6 deal:000", "7 user:001", "8 deal:001", "9 a", "10 b deleted", "12 d", "13 e"}
+	tests := []struct {
+		since, limit int64
+		want         []string
+	}{
+		{0, 0, all},
+		{0, 3, all[:3]},
+		{0, 100, all},
+		// Since is exclusive, sequences not in the tree start at the next one
+		{3, 0, all[1:]},
+		{4, 2, all[1:3]},
+		{11, 0, all[7:]},
+		{12, 1, all[8:]},
+		{13, 0, nil},
+		{100, 0, nil},
+	}
+	for _, test := range tests {
+		got := readChanges(t, cf, test.since, test.limit)
+		if want := strings.Join(test.want, ","); got != want {
+			t.Errorf("Since %d limit %d: expected %s, got %s", test.since, test.limit, want, got)
+		}
+	}
+
+	// Subtrees of multi level tree below since are skipped
+	large, _ := openFixture(t, largeFixture)
+	ids, seqs := collectDocuments(t, large, DocumentsOptions{Tree: SeqTree})
+	for _, i := range []int{0, 1, 50, len(seqs) - 2, len(seqs) - 1} {
+		var want []string
+		for j := i + 1; j < len(seqs); j++ {
+			want = append(want, fmt.Sprint(seqs[j], " ", ids[j]))
+		}
+		got := strings.Replace(readChanges(t, large, seqs[i], 0), " deleted", "", -1)
+		if got != strings.Join(want, ",") {
+			t.Errorf("Since %d: expected %d rows, got %s", seqs[i], len(want), got)
+		}
+	}
+
+	// Document body is read for the winning revision
+	it := cf.Changes(context.Background(), 11, 1)
+	if !it.Next() {
+		t.Fatal(it.Err())
+	}
+	doc, err := it.Doc()
+	if err != nil || doc.Id != "d" || doc.Rev != it.Change().Changes[0].Rev {
+		t.Errorf("Expected winning revision of d, got %+v and %v", doc, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	it = cf.Changes(ctx, 0, 0)
+	if it.Next() || it.Err() != context.Canceled {
+		t.Errorf("Expected cancelled iteration, got error %v", it.Err())
+	}
+}
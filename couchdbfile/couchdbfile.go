@@ -1,21 +1,41 @@
 // Package couchdbfile provides interface to Couch DB file. It takes
-// provided Reader as input and tries to parse it as CouchDB file. It
-// contains methods to read Nodes and Documents.
+// provided ReaderAt as input and tries to parse it as CouchDB file. It
+// contains methods to read Nodes and Documents. Reads do not share any
+// position so CouchDbFile can be used from many goroutines.
 package couchdbfile
 
 import (
 	"io"
+
+	"github.com/pipedrive/uncouch/couchbytes"
 )
 
+// Options controls optional behaviour of CouchDbFile
+type Options struct {
+	// Verify enables MD5 hash checks of header and document blocks
+	Verify bool
+	// SkipCorrupted skips blocks failing verification instead of failing
+	SkipCorrupted bool
+}
+
 // CouchDbFile is main interface to interact with single CouchDB file
 type CouchDbFile struct {
-	Header DbHeader
-	input  io.ReadSeeker
-	size   int64
+	Header       DbHeader
+	input        io.ReaderAt
+	size         int64
+	opts         Options
+	headerOffset int64
+	// corruptedHeaders holds newer headers skipped due to failed verification
+	corruptedHeaders []*couchbytes.ChecksumError
 }
 
 // New will return CouchDbFile
-func New(input io.ReadSeeker, size int64) (cf *CouchDbFile, err error) {
+func New(input io.ReaderAt, size int64) (cf *CouchDbFile, err error) {
+	return NewWithOptions(input, size, Options{})
+}
+
+// NewWithOptions will return CouchDbFile using given options
+func NewWithOptions(input io.ReaderAt, size int64, opts Options) (cf *CouchDbFile, err error) {
 	var (
 		newCouchDbFile CouchDbFile
 	)
@@ -23,6 +43,7 @@ func New(input io.ReadSeeker, size int64) (cf *CouchDbFile, err error) {
 	// Add handle to internal input variable
 	cf.input = input
 	cf.size = size
+	cf.opts = opts
 	header, err := cf.ReadDbHeader()
 	if err != nil {
 		slog.Error(err)
@@ -1,88 +1,176 @@
 package couchdbfile
 
 import (
-	"encoding/binary"
 	"fmt"
 	"github.com/pipedrive/uncouch/couchbytes"
+	"github.com/pipedrive/uncouch/erldeser"
 	"github.com/pipedrive/uncouch/termite"
 	"io"
 )
 
-// TreeState is subset of data in db header we care for our purposes
+// Supported range of CouchDB disk versions
+const (
+	minDiskVersion = 5
+	maxDiskVersion = 8
+)
+
+// TreeState is root of the Btree stored in db header. Offset is 0 for empty tree.
 type TreeState struct {
-	Offset int64
-	Size   int32
+	Offset int64 `json:"offset"`
+	Size   int64 `json:"size"`
 }
 
-// DbHeader is subset of data db header we care for our purposes
+// Epoch is {Node, Seq} pair recording which node owned the file from given sequence
+type Epoch struct {
+	Node string `json:"node"`
+	Seq  int64  `json:"seq"`
+}
+
+// DbHeader is db header mapped from its tuple. Purge fields depend on the
+// disk version: up to version 6 there is PurgeSeq and PurgedDocsPtr, since
+// version 7 purge and purge sequence trees. Pointers are 0 when not set.
 type DbHeader struct {
-	DiskVersion  uint8
-	UpdateSeq    int32
-	IDTreeState  TreeState
-	SeqTreeState TreeState
+	DiskVersion       uint8     `json:"disk_version"`
+	UpdateSeq         int64     `json:"update_seq"`
+	IDTreeState       TreeState `json:"id_tree_state"`
+	SeqTreeState      TreeState `json:"seq_tree_state"`
+	LocalTreeState    TreeState `json:"local_tree_state"`
+	PurgeSeq          int64     `json:"purge_seq"`
+	PurgedDocsPtr     int64     `json:"purged_docs_ptr"`
+	PurgeTreeState    TreeState `json:"purge_tree_state"`
+	PurgeSeqTreeState TreeState `json:"purge_seq_tree_state"`
+	SecurityPtr       int64     `json:"security_ptr"`
+	RevsLimit         int64     `json:"revs_limit"`
+	UUID              string    `json:"uuid"`
+	Epochs            []Epoch   `json:"epochs"`
+	CompactedSeq      int64     `json:"compacted_seq"`
+	PurgedDocsLimit   int64     `json:"purged_docs_limit"`
+	PropsPtr          int64     `json:"props_ptr"`
 }
 
-// findHeader tries to locate DB Header from provided input.
+// findHeader tries to locate DB Header from provided input searching
+// backwards from the block containing the last of size bytes.
 // It returns offset if header was found.
-func (dbh *DbHeader) findHeader(input io.ReadSeeker, size int64) (offset int64, err error) {
-	latestBlockIndex := size / couchbytes.BlockAlignment
-	for {
-		if latestBlockIndex < 0 {
-			// We reached beginning of the file and didn't find DB header block, something must be wrong
-			err = fmt.Errorf("Could not find DB Header block in the file")
-			slog.Error(err)
-			return -1, err
-		}
-		offset, err = input.Seek(latestBlockIndex*couchbytes.BlockAlignment, io.SeekStart)
-		if err != nil {
-			slog.Error(err)
-			return -1, err
-		}
-		var headerFlag uint8
-		err = binary.Read(input, binary.BigEndian, &headerFlag)
+func (dbh *DbHeader) findHeader(input io.ReaderAt, size int64) (int64, error) {
+	offset, err := dbh.previousHeader(input, size)
+	if err != nil {
+		slog.Error(err)
+		return -1, err
+	}
+	if offset < 0 {
+		// We reached beginning of the file and didn't find DB header block, something must be wrong
+		err = fmt.Errorf("Could not find DB Header block in the file")
+		slog.Error(err)
+		return -1, err
+	}
+	return offset, nil
+}
+
+// previousHeader searches backwards from the block containing the last
+// of size bytes like findHeader, but returns -1 without error when the
+// beginning of the file is reached.
+func (dbh *DbHeader) previousHeader(input io.ReaderAt, size int64) (int64, error) {
+	latestBlockIndex := (size - 1) / couchbytes.BlockAlignment
+	if size <= 0 {
+		latestBlockIndex = -1
+	}
+	for ; latestBlockIndex >= 0; latestBlockIndex-- {
+		offset := latestBlockIndex * couchbytes.BlockAlignment
+		var headerFlag [1]byte
+		_, err := input.ReadAt(headerFlag[:], offset)
 		if err != nil {
 			slog.Error(err)
 			return -1, err
 		}
-		switch headerFlag {
+		switch headerFlag[0] {
 		case 0:
-			latestBlockIndex--
 		case 1:
-			offset++
-			return offset, err
+			return offset + 1, nil
 		default:
-			err := fmt.Errorf("Unknown DB Header starting byte %v", headerFlag)
+			err := fmt.Errorf("Unknown DB Header starting byte %v", headerFlag[0])
 			slog.Error(err)
 			return -1, err
 		}
 	}
+	return -1, nil
 }
 
-// readFromTermite reads header structure out of Termite structure
+// readFromTermite reads header structure out of Termite structure.
+// Header record grew over time so fields are mapped by position and
+// missing trailing fields are left empty.
 func (dbh *DbHeader) readFromTermite(t *termite.Termite) error {
 	if string(t.Children[0].T.Binary) != "db_header" {
 		err := fmt.Errorf("Term header is \"%s\". Expecting \"db_header\"", string(t.Children[0].T.Binary))
 		slog.Error(err)
 		return err
 	}
+	if len(t.Children) < 11 {
+		err := fmt.Errorf("DB header has %d fields, expecting at least 11", len(t.Children))
+		slog.Error(err)
+		return err
+	}
 	dbh.DiskVersion = uint8(t.Children[1].T.IntegerValue)
-	dbh.UpdateSeq = int32(t.Children[2].T.IntegerValue)
-
-	if len(t.Children[4].Children) >= 3 {
-		dbh.IDTreeState.Offset = int64(t.Children[4].Children[0].T.IntegerValue)
-		dbh.IDTreeState.Size = int32(t.Children[4].Children[2].T.IntegerValue)
+	if dbh.DiskVersion < minDiskVersion || dbh.DiskVersion > maxDiskVersion {
+		slog.Warnf("Unsupported disk version %d, reading header as version %d", dbh.DiskVersion, maxDiskVersion)
+	}
+	dbh.UpdateSeq = readInteger(t.Children[2])
+	dbh.IDTreeState = readTreeState(t.Children[4])
+	dbh.SeqTreeState = readTreeState(t.Children[5])
+	dbh.LocalTreeState = readTreeState(t.Children[6])
+	if dbh.DiskVersion <= 6 {
+		dbh.PurgeSeq = readInteger(t.Children[7])
+		dbh.PurgedDocsPtr = readInteger(t.Children[8])
 	} else {
-		dbh.IDTreeState.Offset = 0
-		dbh.IDTreeState.Size = 0
+		dbh.PurgeTreeState = readTreeState(t.Children[7])
+		dbh.PurgeSeqTreeState = readTreeState(t.Children[8])
+	}
+	dbh.SecurityPtr = readInteger(t.Children[9])
+	dbh.RevsLimit = readInteger(t.Children[10])
+	if len(t.Children) > 11 && t.Children[11].T.Term == erldeser.BinaryExt {
+		dbh.UUID = string(t.Children[11].T.Binary)
+	}
+	if len(t.Children) > 12 && t.Children[12].T.Term == erldeser.ListExt {
+		epochs := t.Children[12].Children
+		// Last child is the list tail
+		for _, e := range epochs[:len(epochs)-1] {
+			dbh.Epochs = append(dbh.Epochs, Epoch{
+				Node: string(e.Children[0].T.Binary),
+				Seq:  readInteger(e.Children[1]),
+			})
+		}
+	}
+	if len(t.Children) > 13 {
+		dbh.CompactedSeq = readInteger(t.Children[13])
 	}
+	if len(t.Children) > 14 {
+		dbh.PurgedDocsLimit = readInteger(t.Children[14])
+	}
+	if len(t.Children) > 15 {
+		dbh.PropsPtr = readInteger(t.Children[15])
+	}
+	return nil
+}
 
-	if len(t.Children[5].Children) >= 3 {
-		dbh.SeqTreeState.Offset = int64(t.Children[5].Children[0].T.IntegerValue)
-		dbh.SeqTreeState.Size = int32(t.Children[5].Children[2].T.IntegerValue)
-	} else {
-		dbh.SeqTreeState.Offset = 0
-		dbh.SeqTreeState.Size = 0
+// readTreeState reads Btree root which is {Offset, Reduction, Size},
+// {Offset, Reduction} before disk version 6 or nil for empty tree
+func readTreeState(t *termite.Termite) TreeState {
+	var ts TreeState
+	if t.T.Term != erldeser.SmallTupleExt || len(t.Children) < 2 {
+		return ts
 	}
+	ts.Offset = t.Children[0].T.IntegerValue
+	if len(t.Children) >= 3 {
+		ts.Size = readInteger(t.Children[2])
+	}
+	return ts
+}
 
-	return nil
+// readInteger reads integer value, other terms like nil or undefined are read as 0
+func readInteger(t *termite.Termite) int64 {
+	switch t.T.Term {
+	case erldeser.SmallIntegerExt, erldeser.IntegerExt, erldeser.SmallBigExt:
+		return t.T.IntegerValue
+	default:
+		return 0
+	}
 }
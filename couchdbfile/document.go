@@ -2,6 +2,8 @@ package couchdbfile
 
 import (
 	"bytes"
+	"encoding/base64"
+	"fmt"
 
 	"github.com/pipedrive/uncouch/couchbytes"
 	"github.com/pipedrive/uncouch/erldeser"
@@ -9,37 +11,150 @@ import (
 	"github.com/pipedrive/uncouch/leakybucket"
 )
 
+// CouchDbDocument is decoded revision of the document
 type CouchDbDocument struct {
-	Id      string
-	Deleted int8
-	Rev     string
-	Value   map[string]interface{}
+	Id          string
+	Deleted     int8
+	Rev         string
+	Seq         int64
+	Conflicts   []string
+	Revisions   RevisionHistory
+	Attachments []Attachment
+	Value       map[string]interface{}
+	// Body is JSON of the body limited by DocumentsOptions.Fields, such
+	// body is not decoded into Value
+	Body []byte
+	// Encoded is output row made by DocumentsOptions.Encode
+	Encoded []byte
 }
 
-// WriteDocument writes document as JSON object into output buffer
+// DocumentJSONOptions selects optional fields of the document JSON
+type DocumentJSONOptions struct {
+	// Revisions adds _revisions with revision history
+	Revisions bool
+	// Attachments inlines attachment data as base64 instead of stubs
+	Attachments bool
+}
+
+// DocumentJSON returns document the way CouchDB shows it, with body fields
+// next to _id, _rev and other special fields
+func (cf *CouchDbFile) DocumentJSON(doc *CouchDbDocument, opts DocumentJSONOptions) (map[string]interface{}, error) {
+	value := make(map[string]interface{}, len(doc.Value)+5)
+	for k, v := range doc.Value {
+		value[k] = v
+	}
+	value["_id"] = doc.Id
+	value["_rev"] = doc.Rev
+	if doc.Deleted != 0 {
+		value["_deleted"] = true
+	}
+	if len(doc.Conflicts) > 0 {
+		value["_conflicts"] = doc.Conflicts
+	}
+	if opts.Revisions && len(doc.Revisions.IDs) > 0 {
+		value["_revisions"] = doc.Revisions
+	}
+	if len(doc.Attachments) == 0 {
+		return value, nil
+	}
+	attachments := make(map[string]interface{}, len(doc.Attachments))
+	for i := range doc.Attachments {
+		a := &doc.Attachments[i]
+		if !opts.Attachments {
+			attachments[a.Name] = a.Stub()
+			continue
+		}
+		var data bytes.Buffer
+		err := cf.WriteAttachment(a, &data, true)
+		if err != nil {
+			slog.Error(err)
+			return nil, err
+		}
+		attachment := map[string]interface{}{
+			"content_type": a.ContentType,
+			"revpos":       a.RevPos,
+			"data":         base64.StdEncoding.EncodeToString(data.Bytes()),
+		}
+		if len(a.Digest) > 0 {
+			attachment["digest"] = "md5-" + base64.StdEncoding.EncodeToString(a.Digest)
+		}
+		attachments[a.Name] = attachment
+	}
+	value["_attachments"] = attachments
+	return value, nil
+}
+
+// WriteDocument writes winning revision of the document as JSON object into output buffer
 func (cf *CouchDbFile) WriteDocument(di *DocumentInfo, output *bytes.Buffer) error {
-	// Get buffer
-	docBytes, err := couchbytes.ReadDocumentBytes(cf.input, di.Revisions[len(di.Revisions)-1].Offset)
+	winner := di.WinningRevision()
+	if winner == nil {
+		err := fmt.Errorf("Document \"%s\" has no revisions", string(di.ID))
+		slog.Error(err)
+		return err
+	}
+	return cf.WriteRevision(winner, output)
+}
+
+// WriteRevision writes given revision of the document as JSON object into output buffer
+func (cf *CouchDbFile) WriteRevision(r *Revision, output *bytes.Buffer) error {
+	_, err := cf.writeRevision(r, output, false, nil)
 	if err != nil {
 		slog.Error(err)
 		return err
 	}
+	return nil
+}
+
+// writeRevision writes given revision of the document as JSON object into output
+// buffer and if requested returns attachments stored with it. Non nil fields
+// limit the body to the listed fields.
+func (cf *CouchDbFile) writeRevision(r *Revision, output *bytes.Buffer, withAttachments bool, fields *jsonser.Fields) ([]Attachment, error) {
+	if r.Offset < 0 {
+		err := fmt.Errorf("Body of revision %s is not stored in the file", r.String())
+		slog.Error(err)
+		return nil, err
+	}
+	// Get buffer
+	var (
+		docBytes, attBytes *[]byte
+		err                error
+	)
+	if withAttachments {
+		docBytes, attBytes, err = couchbytes.ReadDocumentSummaryBytes(cf.input, r.Offset, cf.opts.Verify)
+	} else {
+		docBytes, err = couchbytes.ReadDocumentBytes(cf.input, r.Offset, cf.opts.Verify)
+	}
+	if err != nil {
+		slog.Error(err)
+		return nil, err
+	}
 	defer leakybucket.PutBytes(docBytes)
+	if attBytes != nil {
+		defer leakybucket.PutBytes(attBytes)
+	}
 	scanner, err := erldeser.NewScanner(*docBytes)
 	if err != nil {
 		slog.Error(err)
-		return err
+		return nil, err
 	}
 	js, err := jsonser.New(scanner)
 	if err != nil {
 		slog.Error(err)
-		return err
+		return nil, err
 	}
+	js.SetFields(fields)
 	err = js.WriteJSONToBuffer(output)
 	if err != nil {
 		slog.Error(err)
-		return err
+		return nil, err
 	}
-
-	return nil
+	if attBytes == nil {
+		return nil, nil
+	}
+	attachments, err := readAttachments(*attBytes)
+	if err != nil {
+		slog.Error(err)
+		return nil, err
+	}
+	return attachments, nil
 }
@@ -33,7 +33,7 @@ func (cf *CouchDbFile) Explore() error {
 				slog.Debugf("%v", string(kvNode.Documents[i].ID))
 				for _, rev := range kvNode.Documents[i].Revisions {
 					if rev.Offset > 0 {
-						docBytes, err := couchbytes.ReadDocumentBytes(cf.input, rev.Offset)
+						docBytes, err := couchbytes.ReadDocumentBytes(cf.input, rev.Offset, cf.opts.Verify)
 						if err != nil {
 							slog.Error(err)
 							return err
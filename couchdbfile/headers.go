@@ -0,0 +1,99 @@
+package couchdbfile
+
+import (
+	"fmt"
+
+	"github.com/pipedrive/uncouch/couchbytes"
+)
+
+// WalkHeaders calls fn for every valid DB header in the file starting from
+// the newest one and walking backwards. Offset passed to fn is the start of
+// the header block. Headers failing MD5 verification or parsing are skipped.
+// Walking stops when fn returns false.
+func (cf *CouchDbFile) WalkHeaders(fn func(offset int64, header *DbHeader) bool) error {
+	size := cf.size
+	for size > 0 {
+		offset, err := cf.Header.previousHeader(cf.input, size)
+		if err != nil {
+			slog.Error(err)
+			return err
+		}
+		if offset < 0 {
+			// No more headers before this point
+			return nil
+		}
+		blockOffset := offset - 1
+		size = blockOffset
+		buf, err := couchbytes.ReadDbHeaderBytes(cf.input, offset, true)
+		if err != nil {
+			slog.Warnf("Skipping invalid DB header at offset %d: %v", blockOffset, err)
+			continue
+		}
+		header, err := cf.parseDbHeader(buf)
+		if err != nil {
+			slog.Warnf("Skipping invalid DB header at offset %d: %v", blockOffset, err)
+			continue
+		}
+		if !fn(blockOffset, header) {
+			return nil
+		}
+	}
+	return nil
+}
+
+// HeaderOffset returns start of the block holding DB header currently in use
+func (cf *CouchDbFile) HeaderOffset() int64 {
+	return cf.headerOffset - 1
+}
+
+// UseHeader switches CouchDbFile to the DB header stored in the block
+// starting at given offset. All following reads see the database as it was
+// when that header was written.
+func (cf *CouchDbFile) UseHeader(offset int64) error {
+	if offset < 0 || offset%couchbytes.BlockAlignment != 0 || offset >= cf.size {
+		err := fmt.Errorf("Offset %d is not a block start inside the file", offset)
+		slog.Error(err)
+		return err
+	}
+	found, err := cf.Header.findHeader(cf.input, offset+1)
+	if err != nil {
+		slog.Error(err)
+		return err
+	}
+	if found != offset+1 {
+		err := fmt.Errorf("There is no DB header at offset %d", offset)
+		slog.Error(err)
+		return err
+	}
+	header, err := cf.readDbHeaderAt(found)
+	if err != nil {
+		slog.Error(err)
+		return err
+	}
+	cf.Header = *header
+	cf.headerOffset = found
+	return nil
+}
+
+// UseHeaderAsOfSeq switches CouchDbFile to the newest DB header with update
+// sequence not greater than seq
+func (cf *CouchDbFile) UseHeaderAsOfSeq(seq int64) error {
+	headerOffset := int64(-1)
+	err := cf.WalkHeaders(func(offset int64, header *DbHeader) bool {
+		if header.UpdateSeq <= seq {
+			headerOffset = offset
+			return false
+		}
+		return true
+	})
+	if err != nil {
+		slog.Error(err)
+		return err
+	}
+	if headerOffset < 0 {
+		err := fmt.Errorf("There is no DB header with update sequence %d or lower", seq)
+		slog.Error(err)
+		return err
+	}
+	return cf.UseHeader(headerOffset)
+}
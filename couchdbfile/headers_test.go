@@ -0,0 +1,67 @@
+package couchdbfile
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+// walkHeaders opens the fixture changed by modify and returns offsets of
+// headers WalkHeaders yields and messages logged at error level
+func walkHeaders(t *testing.T, modify func(data []byte)) ([]int64, []string, error) {
+	data, err := ioutil.ReadFile(verifyFixture)
+	if err != nil {
+		t.Fatal(err)
+	}
+	modify(data)
+	cf, err := New(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	core, logs := observer.New(zapcore.ErrorLevel)
+	saved := slog
+	slog = zap.New(core).Sugar()
+	defer func() { slog = saved }()
+
+	var offsets []int64
+	err = cf.WalkHeaders(func(offset int64, header *DbHeader) bool {
+		offsets = append(offsets, offset)
+		return true
+	})
+	var messages []string
+	for _, entry := range logs.All() {
+		messages = append(messages, entry.Message)
+	}
+	return offsets, messages, err
+}
+
+func TestWalkHeaders(t *testing.T) {
+	// Fixture has headers in blocks 0, 1 and 2
+	offsets, messages, err := walkHeaders(t, func(data []byte) {})
+	if err != nil || len(offsets) != 3 || offsets[0] != 8192 || offsets[2] != 0 {
+		t.Errorf("Expected headers at 8192, 4096 and 0, got %v and error %v", offsets, err)
+	}
+	if len(messages) != 0 {
+		t.Errorf("Expected no errors logged, got %v", messages)
+	}
+
+	// Reaching the first block without a header ends the walk too
+	offsets, messages, err = walkHeaders(t, func(data []byte) { data[0] = 0 })
+	if err != nil || len(offsets) != 2 || offsets[1] != 4096 {
+		t.Errorf("Expected headers at 8192 and 4096, got %v and error %v", offsets, err)
+	}
+	if len(messages) != 0 {
+		t.Errorf("Expected no errors logged, got %v", messages)
+	}
+
+	// Unknown block flag is an error, not the end of the headers
+	_, _, err = walkHeaders(t, func(data []byte) { data[4096] = 7 })
+	if err == nil {
+		t.Error("Expected error for unknown block flag")
+	}
+}
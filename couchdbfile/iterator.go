@@ -0,0 +1,462 @@
+package couchdbfile
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/pipedrive/uncouch/couchbytes"
+	"github.com/pipedrive/uncouch/jsonser"
+	"github.com/pipedrive/uncouch/leakybucket"
+)
+
+// TreeType selects Btree used for walking the documents
+type TreeType int
+
+const (
+	// SeqTree walks documents in update sequence order
+	SeqTree TreeType = iota
+	// IDTree walks documents in document ID order
+	IDTree
+)
+
+// DocumentsOptions controls how documents are walked
+type DocumentsOptions struct {
+	Tree TreeType
+	// Conflicts adds conflicting revisions to the winning document
+	Conflicts bool
+	// AllLeaves yields every leaf revision with stored body instead of
+	// the winning revision only
+	AllLeaves bool
+	// StartKey and EndKey limit documents to the ID range, both inclusive
+	StartKey string
+	EndKey   string
+	// Prefix limits documents to IDs starting with it
+	Prefix string
+	// Since limits documents to ones updated after given update sequence
+	Since int64
+	// Workers sets number of goroutines reading nodes and decoding
+	// documents concurrently
+	Workers int
+	// Ordered keeps the tree order of documents when Workers is above 1
+	Ordered bool
+	// Fields limits document bodies to the listed fields, nil reads
+	// whole bodies
+	Fields *jsonser.Fields
+	// Encode turns document into output row right after it is read, so
+	// with more than one worker rows are encoded concurrently too. Row is
+	// kept in CouchDbDocument.Encoded, documents encoded to nil are left
+	// out.
+	Encode func(doc *CouchDbDocument) ([]byte, error)
+}
+
+// treeWalker walks Btree depth first and returns DocumentInfo
+// records one by one, keeping in memory only the pending node offsets
+// and the currently open kv_node. When key range is set ID tree
+// subtrees outside of it are not read at all, in seq tree documents are
+// filtered one by one. Likewise seq tree subtrees not updated since
+// the given sequence are skipped.
+type treeWalker struct {
+	cf    *CouchDbFile
+	tree  TreeType
+	keys  *keyRange
+	since int64
+	stack []int64
+	infos []DocumentInfo
+	skip  func(err error) bool
+}
+
+// newTreeWalker returns walker starting from the root of the tree selected by options
+func (cf *CouchDbFile) newTreeWalker(opts DocumentsOptions) *treeWalker {
+	w := &treeWalker{
+		cf:    cf,
+		tree:  opts.Tree,
+		keys:  newKeyRange(opts),
+		since: opts.Since,
+		skip:  cf.skipCorrupted,
+	}
+	var root int64
+	switch opts.Tree {
+	case IDTree:
+		root = cf.Header.IDTreeState.Offset
+	default:
+		root = cf.Header.SeqTreeState.Offset
+	}
+	if root != 0 {
+		w.stack = append(w.stack, root)
+	}
+	return w
+}
+
+// next returns next DocumentInfo in the tree or nil when tree is exhausted
+func (w *treeWalker) next() (*DocumentInfo, error) {
+	for len(w.infos) == 0 {
+		if len(w.stack) == 0 {
+			return nil, nil
+		}
+		offset := w.stack[len(w.stack)-1]
+		w.stack = w.stack[:len(w.stack)-1]
+		err := w.readNode(offset)
+		if err != nil && w.skip(err) {
+			slog.Warnf("Skipping corrupted node at offset %d: %v", offset, err)
+			continue
+		}
+		if err != nil {
+			slog.Error(err)
+			return nil, err
+		}
+	}
+	di := &w.infos[0]
+	w.infos = w.infos[1:]
+	return di, nil
+}
+
+// readNode reads node at offset and either pushes its children to the stack
+// or makes its documents available for reading
+func (w *treeWalker) readNode(offset int64) error {
+	offsets, infos, stop, err := w.expandNode(offset)
+	if err != nil {
+		slog.Error(err)
+		return err
+	}
+	// Push in reverse to keep the original tree order
+	for i := len(offsets) - 1; i >= 0; i-- {
+		w.stack = append(w.stack, offsets[i])
+	}
+	if stop {
+		w.stack = w.stack[:0]
+	}
+	w.infos = infos
+	return nil
+}
+
+// expandNode reads node at offset and returns offsets of its children
+// in tree order for kp_node or its documents for kv_node. Stop is set
+// when there are no more documents in the range after this node.
+func (w *treeWalker) expandNode(offset int64) ([]int64, []DocumentInfo, bool, error) {
+	var (
+		offsets []int64
+		kvNode  *KvNode
+	)
+	switch w.tree {
+	case IDTree:
+		kpNode, kv, err := w.cf.ReadIDNode(offset)
+		if err != nil {
+			slog.Error(err)
+			return nil, nil, false, err
+		}
+		if kpNode != nil {
+			pointers := kpNode.Pointers
+			if w.keys != nil {
+				pointers = w.keys.pointers(pointers)
+			}
+			for _, p := range pointers {
+				offsets = append(offsets, p.Offset)
+			}
+		}
+		kvNode = kv
+	default:
+		kpNode, kv, err := w.cf.ReadSeqNode(offset)
+		if err != nil {
+			slog.Error(err)
+			return nil, nil, false, err
+		}
+		if kpNode != nil {
+			for _, p := range kpNode.Pointers {
+				// Seq of the pointer is the greatest one in its subtree
+				if p.Seq > w.since {
+					offsets = append(offsets, p.Offset)
+				}
+			}
+		}
+		kvNode = kv
+	}
+	if kvNode == nil {
+		return offsets, nil, false, nil
+	}
+	if w.keys != nil || w.since > 0 {
+		infos, stop := w.filterInfos(kvNode.Documents)
+		return offsets, infos, stop, nil
+	}
+	return offsets, kvNode.Documents, false, nil
+}
+
+// filterInfos keeps documents with ID in the key range and updated after
+// since sequence. In ID tree the walk stops at the first document past
+// the range.
+func (w *treeWalker) filterInfos(infos []DocumentInfo) ([]DocumentInfo, bool) {
+	filtered := infos[:0]
+	for _, di := range infos {
+		if w.keys != nil && w.tree == IDTree && w.keys.after(di.ID) {
+			return filtered, true
+		}
+		if w.keys != nil && !w.keys.contains(di.ID) {
+			continue
+		}
+		if di.UpdateSeq > w.since {
+			filtered = append(filtered, di)
+		}
+	}
+	return filtered, false
+}
+
+// DocumentIterator is cursor over documents stored in CouchDbFile.
+// Use Next to advance, Doc to get current document and Err to check
+// why iteration stopped.
+type DocumentIterator struct {
+	ctx     context.Context
+	cf      *CouchDbFile
+	opts    DocumentsOptions
+	w       *treeWalker
+	p       *parallelWalker
+	pending []*CouchDbDocument
+	doc     *CouchDbDocument
+	err     error
+}
+
+// Documents returns iterator yielding documents one at a time. With more
+// than one worker call Close when iteration is abandoned early.
+func (cf *CouchDbFile) Documents(ctx context.Context, opts DocumentsOptions) *DocumentIterator {
+	it := &DocumentIterator{
+		ctx:  ctx,
+		cf:   cf,
+		opts: opts,
+	}
+	if opts.Workers > 1 {
+		it.p = cf.newParallelWalker(opts)
+	} else {
+		it.w = cf.newTreeWalker(opts)
+	}
+	return it
+}
+
+// Next advances iterator to the next document. It returns false when
+// there are no more documents, iteration was cancelled or error occurred.
+func (it *DocumentIterator) Next() bool {
+	if it.err != nil {
+		return false
+	}
+	select {
+	case <-it.ctx.Done():
+		it.err = it.ctx.Err()
+		it.doc = nil
+		return false
+	default:
+	}
+	for len(it.pending) == 0 && it.p != nil {
+		docs, err := it.p.next(it.ctx)
+		if err != nil {
+			slog.Error(err)
+			it.err = err
+		}
+		if docs == nil {
+			it.doc = nil
+			it.Close()
+			return false
+		}
+		it.pending = docs
+	}
+	for len(it.pending) == 0 {
+		di, err := it.w.next()
+		if err != nil {
+			slog.Error(err)
+			it.err = err
+			it.doc = nil
+			return false
+		}
+		if di == nil {
+			it.doc = nil
+			return false
+		}
+		it.pending, err = it.cf.ReadDocuments(di, it.opts)
+		if err != nil {
+			slog.Error(err)
+			it.err = err
+			it.doc = nil
+			return false
+		}
+	}
+	it.doc = it.pending[0]
+	it.pending = it.pending[1:]
+	return true
+}
+
+// ReadDocuments reads document revisions selected by options for the DocumentInfo
+func (cf *CouchDbFile) ReadDocuments(di *DocumentInfo, opts DocumentsOptions) ([]*CouchDbDocument, error) {
+	winner := di.WinningRevision()
+	if winner == nil {
+		err := fmt.Errorf("Document \"%s\" has no revisions", string(di.ID))
+		slog.Error(err)
+		return nil, err
+	}
+	revisions := []*Revision{winner}
+	if opts.AllLeaves {
+		revisions = revisions[:0]
+		for _, r := range di.Leaves() {
+			if r.Offset >= 0 {
+				revisions = append(revisions, r)
+			}
+		}
+	}
+	docs := make([]*CouchDbDocument, 0, len(revisions))
+	for _, r := range revisions {
+		doc, err := cf.readRevision(di, r, opts.Fields)
+		if err != nil && cf.skipCorrupted(err) {
+			slog.Warnf("Skipping corrupted document \"%s\" revision %s: %v", string(di.ID), r.String(), err)
+			continue
+		}
+		if err != nil {
+			slog.Error(err)
+			return nil, err
+		}
+		if opts.Conflicts && r == winner {
+			for _, c := range di.Conflicts() {
+				doc.Conflicts = append(doc.Conflicts, c.String())
+			}
+		}
+		if opts.Encode != nil {
+			doc.Encoded, err = opts.Encode(doc)
+			if err != nil {
+				slog.Error(err)
+				return nil, err
+			}
+			if doc.Encoded == nil {
+				continue
+			}
+		}
+		docs = append(docs, doc)
+	}
+	return docs, nil
+}
+
+// Doc returns document iterator is currently positioned at
+func (it *DocumentIterator) Doc() *CouchDbDocument {
+	return it.doc
+}
+
+// Err returns error which stopped the iteration, if any
+func (it *DocumentIterator) Err() error {
+	return it.err
+}
+
+// Close stops workers reading documents ahead
+func (it *DocumentIterator) Close() {
+	if it.p != nil {
+		it.p.close()
+	}
+}
+
+// skipCorrupted checks if error is caused by corrupted block which should be skipped
+func (cf *CouchDbFile) skipCorrupted(err error) bool {
+	var checksumErr *couchbytes.ChecksumError
+	return cf.opts.SkipCorrupted && errors.As(err, &checksumErr)
+}
+
+// ReadDocument reads and decodes winning revision of the document described by DocumentInfo
+func (cf *CouchDbFile) ReadDocument(di *DocumentInfo) (*CouchDbDocument, error) {
+	winner := di.WinningRevision()
+	if winner == nil {
+		err := fmt.Errorf("Document \"%s\" has no revisions", string(di.ID))
+		slog.Error(err)
+		return nil, err
+	}
+	return cf.ReadRevision(di, winner)
+}
+
+// ReadRevision reads and decodes given revision of the document described by DocumentInfo
+func (cf *CouchDbFile) ReadRevision(di *DocumentInfo, r *Revision) (*CouchDbDocument, error) {
+	return cf.readRevision(di, r, nil)
+}
+
+// readRevision reads revision with body limited to fields, nil reads it whole
+func (cf *CouchDbFile) readRevision(di *DocumentInfo, r *Revision, fields *jsonser.Fields) (*CouchDbDocument, error) {
+	output := leakybucket.GetBuffer()
+	defer leakybucket.PutBuffer(output)
+	attachments, err := cf.writeRevision(r, output, true, fields)
+	if err != nil {
+		slog.Error(err)
+		return nil, err
+	}
+	doc := CouchDbDocument{
+		Id:          strings.TrimSpace(string(di.ID)),
+		Deleted:     r.Deleted,
+		Rev:         r.String(),
+		Seq:         di.UpdateSeq,
+		Revisions:   di.RevisionHistory(r),
+		Attachments: attachments,
+	}
+	if fields != nil {
+		// Projected body is passed on as JSON, output buffer is reused
+		doc.Body = append([]byte(nil), output.Bytes()...)
+		return &doc, nil
+	}
+	err = json.Unmarshal(output.Bytes(), &doc.Value)
+	if err != nil {
+		slog.Error(err)
+		return nil, err
+	}
+	return &doc, nil
+}
+
+// DocumentInfoIterator is cursor over DocumentInfo records of the Btree,
+// documents themselves are not read
+type DocumentInfoIterator struct {
+	ctx context.Context
+	w   *treeWalker
+	di  *DocumentInfo
+	err error
+}
+
+// DocumentInfos returns iterator yielding DocumentInfo records of the tree
+// selected by options. Options selecting revisions are ignored.
+func (cf *CouchDbFile) DocumentInfos(ctx context.Context, opts DocumentsOptions) *DocumentInfoIterator {
+	return &DocumentInfoIterator{ctx: ctx, w: cf.newTreeWalker(opts)}
+}
+
+// Next advances iterator to the next DocumentInfo. It returns false when
+// there are no more records, iteration was cancelled or error occurred.
+func (it *DocumentInfoIterator) Next() bool {
+	it.di = nil
+	if it.err != nil {
+		return false
+	}
+	select {
+	case <-it.ctx.Done():
+		it.err = it.ctx.Err()
+		return false
+	default:
+	}
+	it.di, it.err = it.w.next()
+	return it.di != nil
+}
+
+// Info returns DocumentInfo iterator is currently positioned at
+func (it *DocumentInfoIterator) Info() *DocumentInfo {
+	return it.di
+}
+
+// Err returns error which stopped the iteration, if any
+func (it *DocumentInfoIterator) Err() error {
+	return it.err
+}
+
+// ReadOffset appends documents of the sequence Btree subtree at offset to
+// the slice and panics on error.
+//
+// Deprecated: ReadOffset keeps every document in memory, use Documents to
+// stream them instead.
+func (cf *CouchDbFile) ReadOffset(offset int64, couchDbDocuments []CouchDbDocument) []CouchDbDocument {
+	it := cf.Documents(context.Background(), DocumentsOptions{Tree: SeqTree})
+	defer it.Close()
+	// Walk the given subtree instead of the whole tree
+	it.w.stack = []int64{offset}
+	for it.Next() {
+		couchDbDocuments = append(couchDbDocuments, *it.Doc())
+	}
+	if err := it.Err(); err != nil {
+		panic(err)
+	}
+	return couchDbDocuments
+}
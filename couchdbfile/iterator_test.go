@@ -0,0 +1,108 @@
+package couchdbfile
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/pipedrive/uncouch/couchbytes"
+)
+
+// smallFixture is shared fixture with 9 documents, "b" deleted, "d" with
+// two conflicting leaves and "e" with attachments
+const smallFixture = "../testdata/small.couch"
+
+func TestReadOffset(t *testing.T) {
+	cf, _ := openFixture(t, largeFixture)
+	want, _ := collectDocuments(t, cf, DocumentsOptions{Tree: SeqTree})
+	docs := cf.ReadOffset(cf.Header.SeqTreeState.Offset, nil)
+	var got []string
+	for _, doc := range docs {
+		got = append(got, doc.Id)
+	}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+
+	// Subtree of the root holds only part of the documents
+	kpNode, _, err := cf.ReadSeqNode(cf.Header.SeqTreeState.Offset)
+	if err != nil || kpNode == nil {
+		t.Fatalf("Expected kp_node at the root, got %v", err)
+	}
+	first := cf.ReadOffset(kpNode.Pointers[0].Offset, nil)
+	if len(first) == 0 || len(first) >= len(docs) || first[0].Id != docs[0].Id {
+		t.Errorf("Expected leading part of %d documents from the first subtree, got %d", len(docs), len(first))
+	}
+}
+
+func TestDocumentIterator(t *testing.T) {
+	cf, _ := openFixture(t, smallFixture)
+	for _, workers := range []int{0, 4} {
+		it := cf.Documents(context.Background(), DocumentsOptions{Tree: IDTree, Workers: workers, Ordered: true})
+		var ids []string
+		for it.Next() {
+			ids = append(ids, it.Doc().Id)
+		}
+		want := "a,b,c,d,deal:000,deal:001,e,user:000,user:001"
+		if strings.Join(ids, ",") != want {
+			t.Errorf("%d workers: expected %s, got %v", workers, want, ids)
+		}
+		if it.Err() != nil || it.Doc() != nil {
+			t.Errorf("%d workers: expected no error and no document at the end, got %v and %v", workers, it.Err(), it.Doc())
+		}
+		// Iterator stays at the end and can be closed more than once
+		if it.Next() {
+			t.Errorf("%d workers: Next after the end returned true", workers)
+		}
+		it.Close()
+		it.Close()
+	}
+
+	// Closing early leaves the rest of the documents unread
+	it := cf.Documents(context.Background(), DocumentsOptions{Tree: SeqTree, Workers: 4})
+	if !it.Next() {
+		t.Fatal(it.Err())
+	}
+	it.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	it = cf.Documents(ctx, DocumentsOptions{Tree: IDTree})
+	if it.Next() || it.Err() != context.Canceled {
+		t.Errorf("Expected cancelled iteration, got error %v", it.Err())
+	}
+}
+
+func TestDocumentIteratorError(t *testing.T) {
+	// Fixture with Btree nodes stored with MD5 hash, corrupted ID tree root
+	data, err := ioutil.ReadFile(verifyFixture)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cf, err := New(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[cf.Header.IDTreeState.Offset+30] ^= 0xff
+
+	for _, skip := range []bool{false, true} {
+		cf, err := NewWithOptions(bytes.NewReader(data), int64(len(data)), Options{Verify: true, SkipCorrupted: skip})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, workers := range []int{0, 4} {
+			it := cf.Documents(context.Background(), DocumentsOptions{Tree: IDTree, Workers: workers})
+			if it.Next() {
+				t.Errorf("Skip %v, %d workers: expected no documents under corrupted root", skip, workers)
+			}
+			var checksumErr *couchbytes.ChecksumError
+			if skip != (it.Err() == nil) || (!skip && !errors.As(it.Err(), &checksumErr)) {
+				t.Errorf("Skip %v, %d workers: unexpected error %v", skip, workers, it.Err())
+			}
+			it.Close()
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package couchdbfile
+
+import (
+	"bytes"
+)
+
+// keyRange limits documents to the IDs between StartKey and EndKey, both
+// inclusive, and starting with Prefix. Empty values do not limit the range.
+type keyRange struct {
+	start  []byte
+	end    []byte
+	prefix []byte
+}
+
+// newKeyRange returns range for the options or nil if they do not limit IDs
+func newKeyRange(opts DocumentsOptions) *keyRange {
+	if opts.StartKey == "" && opts.EndKey == "" && opts.Prefix == "" {
+		return nil
+	}
+	kr := &keyRange{
+		start:  []byte(opts.StartKey),
+		prefix: []byte(opts.Prefix),
+	}
+	if opts.EndKey != "" {
+		kr.end = []byte(opts.EndKey)
+	}
+	return kr
+}
+
+// before checks if key sorts before all keys in the range
+func (kr *keyRange) before(key []byte) bool {
+	return bytes.Compare(key, kr.start) < 0 || bytes.Compare(key, kr.prefix) < 0
+}
+
+// after checks if key sorts after all keys in the range
+func (kr *keyRange) after(key []byte) bool {
+	if kr.end != nil && bytes.Compare(key, kr.end) > 0 {
+		return true
+	}
+	return len(kr.prefix) > 0 && bytes.Compare(key, kr.prefix) > 0 && !bytes.HasPrefix(key, kr.prefix)
+}
+
+// contains checks if key is in the range
+func (kr *keyRange) contains(key []byte) bool {
+	return !kr.before(key) && !kr.after(key)
+}
+
+// pointers selects kp_node pointers whose subtrees may hold keys in the range.
+// Key of the pointer is the greatest key in its subtree, so subtrees with
+// Key before the range are skipped and everything past the first subtree
+// with Key after the range is cut off.
+func (kr *keyRange) pointers(pointers []PointerID) []PointerID {
+	first := 0
+	for first < len(pointers) && kr.before(pointers[first].Key) {
+		first++
+	}
+	last := first
+	for last < len(pointers) {
+		last++
+		if kr.after(pointers[last-1].Key) {
+			break
+		}
+	}
+	return pointers[first:last]
+}
@@ -0,0 +1,136 @@
+package couchdbfile
+
+import (
+	"strings"
+	"testing"
+)
+
+// kvNodeKeys returns first and last ID of every kv_node of the ID tree
+// in tree order
+func kvNodeKeys(t *testing.T, cf *CouchDbFile, offset int64) [][2]string {
+	kpNode, kvNode, err := cf.ReadIDNode(offset)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kvNode != nil {
+		docs := kvNode.Documents
+		return [][2]string{{string(docs[0].ID), string(docs[len(docs)-1].ID)}}
+	}
+	var keys [][2]string
+	for _, p := range kpNode.Pointers {
+		keys = append(keys, kvNodeKeys(t, cf, p.Offset)...)
+	}
+	return keys
+}
+
+func TestKeyRangeScan(t *testing.T) {
+	cf, _ := openFixture(t, largeFixture)
+	all, _ := collectDocuments(t, cf, DocumentsOptions{Tree: IDTree})
+
+	// Ranges starting and ending on both sides of kv_node boundaries
+	var ranges []DocumentsOptions
+	nodes := kvNodeKeys(t, cf, cf.Header.IDTreeState.Offset)
+	if len(nodes) < 8 {
+		t.Fatalf("Expected fixture with many kv_nodes, got %d", len(nodes))
+	}
+	for i := 1; i < len(nodes); i++ {
+		last, first := nodes[i-1][1], nodes[i][0]
+		ranges = append(ranges,
+			DocumentsOptions{StartKey: last},
+			DocumentsOptions{StartKey: first},
+			DocumentsOptions{EndKey: last},
+			DocumentsOptions{EndKey: first},
+			DocumentsOptions{StartKey: last, EndKey: last},
+			DocumentsOptions{StartKey: first, EndKey: first},
+			DocumentsOptions{StartKey: last, EndKey: first},
+		)
+	}
+	ranges = append(ranges,
+		// Empty ranges
+		DocumentsOptions{StartKey: "deal:0005", EndKey: "deal:0006"},
+		DocumentsOptions{StartKey: "user:001", EndKey: "deal:001"},
+		DocumentsOptions{StartKey: "zzz"},
+		DocumentsOptions{EndKey: " "},
+		// Prefixes, also matching nothing and ending with 0xff
+		DocumentsOptions{Prefix: "deal:"},
+		DocumentsOptions{Prefix: "user:05"},
+		DocumentsOptions{Prefix: "e"},
+		DocumentsOptions{Prefix: "deal:9"},
+		DocumentsOptions{Prefix: "x"},
+		DocumentsOptions{Prefix: "d\xff"},
+		DocumentsOptions{Prefix: "\xff"},
+		DocumentsOptions{Prefix: "deal:", StartKey: "deal:010", EndKey: "user:000"},
+	)
+
+	for _, opts := range ranges {
+		var want []string
+		for _, id := range all {
+			if inRange(id, opts) {
+				want = append(want, id)
+			}
+		}
+		for _, workers := range []int{0, 4} {
+			opts.Tree = IDTree
+			opts.Workers = workers
+			opts.Ordered = true
+			got, _ := collectDocuments(t, cf, opts)
+			if strings.Join(got, ",") != strings.Join(want, ",") {
+				t.Errorf("Range %q-%q prefix %q, %d workers: expected %v, got %v", opts.StartKey, opts.EndKey, opts.Prefix, workers, want, got)
+			}
+		}
+	}
+}
+
+// inRange checks key range of the options by plain string comparisons
+func inRange(id string, opts DocumentsOptions) bool {
+	return id >= opts.StartKey && (opts.EndKey == "" || id <= opts.EndKey) && strings.HasPrefix(id, opts.Prefix)
+}
+
+func TestKeyRangePointers(t *testing.T) {
+	// Key of the pointer is the greatest key in its subtree
+	keys := []string{"a", "b\xff", "b\xff\xff", "b\xff\xffz", "c", "d"}
+	pointers := make([]PointerID, len(keys))
+	for i, key := range keys {
+		pointers[i] = PointerID{Key: []byte(key), Offset: int64(i)}
+	}
+	tests := []struct {
+		opts DocumentsOptions
+		want string
+	}{
+		// Subtree ending with "c" may still hold "b\xff\xffzz"
+		{DocumentsOptions{Prefix: "b\xff"}, "1,2,3,4"},
+		{DocumentsOptions{Prefix: "b\xff\xff"}, "2,3,4"},
+		// Every key sorts before the prefix
+		{DocumentsOptions{Prefix: "\xff"}, ""},
+		{DocumentsOptions{StartKey: "b\xff", EndKey: "b\xff"}, "1,2"},
+		{DocumentsOptions{StartKey: "c"}, "4,5"},
+		{DocumentsOptions{StartKey: "d\x00"}, ""},
+		{DocumentsOptions{EndKey: "a"}, "0,1"},
+		{DocumentsOptions{EndKey: " "}, "0"},
+	}
+	for _, test := range tests {
+		var got []string
+		for _, p := range newKeyRange(test.opts).pointers(pointers) {
+			got = append(got, string('0'+byte(p.Offset)))
+		}
+		if strings.Join(got, ",") != test.want {
+			t.Errorf("Range %q-%q prefix %q: expected pointers %s, got %s", test.opts.StartKey, test.opts.EndKey, test.opts.Prefix, test.want, strings.Join(got, ","))
+		}
+	}
+
+	// Keys with prefix ending in 0xff are inside, following keys after
+	kr := newKeyRange(DocumentsOptions{Prefix: "b\xff"})
+	for key, want := range map[string][3]bool{
+		"b":             {true, false, false},
+		"b\xfe\xff":     {true, false, false},
+		"b\xff":         {false, true, false},
+		"b\xff\xff\x00": {false, true, false},
+		"c":             {false, false, true},
+	} {
+		k := []byte(key)
+		got := [3]bool{kr.before(k), kr.contains(k), kr.after(k)}
+		if got != want {
+			t.Errorf("Key %q: expected before, contains, after %v, got %v", key, want, got)
+		}
+	}
+}
@@ -0,0 +1,221 @@
+package couchdbfile
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/pipedrive/uncouch/couchbytes"
+	"github.com/pipedrive/uncouch/erldeser"
+	"github.com/pipedrive/uncouch/erlterm"
+	"github.com/pipedrive/uncouch/jsonser"
+	"github.com/pipedrive/uncouch/leakybucket"
+	"github.com/pipedrive/uncouch/termite"
+)
+
+// LocalDocument is document stored in local documents Btree like
+// replication checkpoints. Local documents are not revisioned, Rev is
+// always "0-N".
+type LocalDocument struct {
+	Id    string
+	Rev   string
+	Value map[string]interface{}
+}
+
+// ReadLocalNode reads local documents Btree node from the given offset.
+// It returns child offsets for kp_node and documents for kv_node.
+// Local documents are stored inline in kv_node as {Id, {Rev, Body}}.
+func (cf *CouchDbFile) ReadLocalNode(offset int64) ([]int64, []LocalDocument, error) {
+	buf, err := couchbytes.ReadNodeBytes(cf.input, offset, cf.opts.Verify)
+	if err != nil {
+		slog.Error(err)
+		return nil, nil, err
+	}
+	defer leakybucket.PutBytes(buf)
+	s, err := erldeser.NewScanner(*buf)
+	if err != nil {
+		slog.Error(err)
+		return nil, nil, err
+	}
+	var t erlterm.Term
+	t.Reset()
+	// Node is {NodeType, List}
+	for i := 0; i < 2; i++ {
+		err = s.Scan(&t)
+		if err != nil {
+			slog.Error(err)
+			return nil, nil, err
+		}
+	}
+	switch string(t.Binary) {
+	case "kp_node":
+		s.Rewind()
+		offsets, err := readLocalKpNode(s)
+		if err != nil {
+			slog.Error(err)
+			return nil, nil, err
+		}
+		return offsets, nil, nil
+	case "kv_node":
+		docs, err := readLocalKvNode(s)
+		if err != nil {
+			slog.Error(err)
+			return nil, nil, err
+		}
+		return nil, docs, nil
+	default:
+		err := fmt.Errorf("Unknown node type: %v", string(t.Binary))
+		slog.Error(err)
+		return nil, nil, err
+	}
+}
+
+// readLocalKpNode reads child offsets of local Btree kp_node
+func readLocalKpNode(s *erldeser.Scanner) ([]int64, error) {
+	tb, err := termite.NewBuilder()
+	if err != nil {
+		slog.Error(err)
+		return nil, err
+	}
+	t, err := tb.ReadTermite(s)
+	if err != nil {
+		slog.Error(err)
+		return nil, err
+	}
+	defer t.Release()
+	pointers := t.Children[1].Children
+	offsets := make([]int64, 0, len(pointers))
+	// Last child is the list tail
+	for _, p := range pointers[:len(pointers)-1] {
+		offsets = append(offsets, p.Children[1].Children[0].T.IntegerValue)
+	}
+	return offsets, nil
+}
+
+// readLocalKvNode reads documents of local Btree kv_node, scanner has
+// to be positioned right after the node type
+func readLocalKvNode(s *erldeser.Scanner) ([]LocalDocument, error) {
+	var t erlterm.Term
+	t.Reset()
+	err := s.Scan(&t)
+	if err != nil {
+		slog.Error(err)
+		return nil, err
+	}
+	if t.Term != erldeser.ListExt {
+		return nil, nil
+	}
+	length := t.IntegerValue
+	docs := make([]LocalDocument, length)
+	output := leakybucket.GetBuffer()
+	defer leakybucket.PutBuffer(output)
+	for i := int64(0); i < length; i++ {
+		// {Id, {Rev, Body}}
+		err = s.Scan(&t)
+		if err == nil {
+			err = s.Scan(&t)
+		}
+		if err != nil {
+			slog.Error(err)
+			return nil, err
+		}
+		docs[i].Id = string(t.Binary)
+		err = s.Scan(&t)
+		if err == nil {
+			err = s.Scan(&t)
+		}
+		if err != nil {
+			slog.Error(err)
+			return nil, err
+		}
+		// Revision is stored as integer, older files used binary
+		if t.Term == erldeser.BinaryExt {
+			docs[i].Rev = "0-" + string(t.Binary)
+		} else {
+			docs[i].Rev = "0-" + strconv.FormatInt(t.IntegerValue, 10)
+		}
+		js, err := jsonser.New(s)
+		if err != nil {
+			slog.Error(err)
+			return nil, err
+		}
+		output.Reset()
+		err = js.WriteJSONToBuffer(output)
+		if err != nil {
+			slog.Error(err)
+			return nil, err
+		}
+		err = json.Unmarshal(output.Bytes(), &docs[i].Value)
+		if err != nil {
+			slog.Error(err)
+			return nil, err
+		}
+	}
+	return docs, nil
+}
+
+// LocalDocumentIterator is cursor over local documents stored in CouchDbFile
+type LocalDocumentIterator struct {
+	ctx     context.Context
+	cf      *CouchDbFile
+	stack   []int64
+	pending []LocalDocument
+	doc     *LocalDocument
+	err     error
+}
+
+// LocalDocuments returns iterator yielding local documents in ID order
+func (cf *CouchDbFile) LocalDocuments(ctx context.Context) *LocalDocumentIterator {
+	it := &LocalDocumentIterator{ctx: ctx, cf: cf}
+	if cf.Header.LocalTreeState.Offset != 0 {
+		it.stack = append(it.stack, cf.Header.LocalTreeState.Offset)
+	}
+	return it
+}
+
+// Next advances iterator to the next local document. It returns false when
+// there are no more documents, iteration was cancelled or error occurred.
+func (it *LocalDocumentIterator) Next() bool {
+	if it.err != nil {
+		return false
+	}
+	it.doc = nil
+	for len(it.pending) == 0 {
+		select {
+		case <-it.ctx.Done():
+			it.err = it.ctx.Err()
+			return false
+		default:
+		}
+		if len(it.stack) == 0 {
+			return false
+		}
+		offset := it.stack[len(it.stack)-1]
+		it.stack = it.stack[:len(it.stack)-1]
+		offsets, docs, err := it.cf.ReadLocalNode(offset)
+		if err != nil {
+			slog.Error(err)
+			it.err = err
+			return false
+		}
+		// Push in reverse to keep the original tree order
+		for i := len(offsets) - 1; i >= 0; i-- {
+			it.stack = append(it.stack, offsets[i])
+		}
+		it.pending = docs
+	}
+	it.doc = &it.pending[0]
+	it.pending = it.pending[1:]
+	return true
+}
+
+// Doc returns local document iterator is currently positioned at
+func (it *LocalDocumentIterator) Doc() *LocalDocument {
+	return it.doc
+}
+
+// Err returns error which stopped the iteration, if any
+func (it *LocalDocumentIterator) Err() error {
+	return it.err
+}
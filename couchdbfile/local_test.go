@@ -0,0 +1,76 @@
+package couchdbfile
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/pipedrive/uncouch/couchbytes"
+)
+
+func TestLocalDocumentIterator(t *testing.T) {
+	cf, _ := openFixture(t, smallFixture)
+	it := cf.LocalDocuments(context.Background())
+	var got []string
+	for it.Next() {
+		doc := it.Doc()
+		got = append(got, fmt.Sprint(doc.Id, " ", doc.Rev, " ", doc.Value))
+	}
+	// Only the latest revision of _local/repl1 is kept
+	want := []string{
+		"_local/other 0-1 map[x:1]",
+		"_local/repl1 0-2 map[session_id:def source_last_seq:60]",
+		"_local/zzz 0-3 map[y:[1 2]]",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+	if it.Err() != nil || it.Doc() != nil || it.Next() {
+		t.Errorf("Expected iterator to stay at the end without error, got %v", it.Err())
+	}
+
+	// Database without local documents
+	cf.Header.LocalTreeState.Offset = 0
+	it = cf.LocalDocuments(context.Background())
+	if it.Next() || it.Err() != nil {
+		t.Errorf("Expected no local documents, got error %v", it.Err())
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	cf, _ = openFixture(t, smallFixture)
+	it = cf.LocalDocuments(ctx)
+	if it.Next() || it.Err() != context.Canceled {
+		t.Errorf("Expected cancelled iteration, got error %v", it.Err())
+	}
+}
+
+func TestLocalDocumentIteratorError(t *testing.T) {
+	// Fixture with Btree nodes stored with MD5 hash, corrupted local tree root
+	data, err := ioutil.ReadFile(verifyFixture)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cf, err := New(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[cf.Header.LocalTreeState.Offset+30] ^= 0xff
+
+	cf, err = NewWithOptions(bytes.NewReader(data), int64(len(data)), Options{Verify: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	it := cf.LocalDocuments(context.Background())
+	if it.Next() {
+		t.Error("Expected no local documents under corrupted root")
+	}
+	var checksumErr *couchbytes.ChecksumError
+	if !errors.As(it.Err(), &checksumErr) {
+		t.Errorf("Expected checksum error, got %v", it.Err())
+	}
+}
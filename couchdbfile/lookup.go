@@ -0,0 +1,94 @@
+package couchdbfile
+
+import (
+	"bytes"
+	"errors"
+	"sort"
+)
+
+// ErrNotFound is returned when document is not present in the file
+var ErrNotFound = errors.New("Document not found")
+
+// FindDocumentInfo looks document up by ID walking down the ID Btree.
+// Only nodes on the path to the document are read. Key of each kp_node
+// pointer is the greatest document ID in the subtree it points to.
+func (cf *CouchDbFile) FindDocumentInfo(id string) (*DocumentInfo, error) {
+	key := []byte(id)
+	offset := cf.Header.IDTreeState.Offset
+	for offset != 0 {
+		kpNode, kvNode, err := cf.ReadIDNode(offset)
+		if err != nil {
+			slog.Error(err)
+			return nil, err
+		}
+		if kpNode != nil {
+			i := sort.Search(len(kpNode.Pointers), func(i int) bool {
+				return bytes.Compare(kpNode.Pointers[i].Key, key) >= 0
+			})
+			if i == len(kpNode.Pointers) {
+				break
+			}
+			offset = kpNode.Pointers[i].Offset
+			continue
+		}
+		if kvNode != nil {
+			i := sort.Search(len(kvNode.Documents), func(i int) bool {
+				return bytes.Compare(kvNode.Documents[i].ID, key) >= 0
+			})
+			if i < len(kvNode.Documents) && bytes.Equal(kvNode.Documents[i].ID, key) {
+				return &kvNode.Documents[i], nil
+			}
+		}
+		break
+	}
+	return nil, ErrNotFound
+}
+
+// GetDocument returns winning revision of the document with given ID
+func (cf *CouchDbFile) GetDocument(id string) (*CouchDbDocument, error) {
+	di, err := cf.FindDocumentInfo(id)
+	if err != nil {
+		return nil, err
+	}
+	return cf.ReadDocument(di)
+}
+
+// GetDocumentRevisions returns revisions of the document with given ID
+// selected by Conflicts and AllLeaves options
+func (cf *CouchDbFile) GetDocumentRevisions(id string, opts DocumentsOptions) ([]*CouchDbDocument, error) {
+	di, err := cf.FindDocumentInfo(id)
+	if err != nil {
+		return nil, err
+	}
+	return cf.ReadDocuments(di, opts)
+}
+
+// DocCount returns number of not deleted and deleted documents. Counts are
+// taken from reductions of the ID tree root, so only the root node is read.
+func (cf *CouchDbFile) DocCount() (int64, int64, error) {
+	var count, deleted int64
+	if cf.Header.IDTreeState.Offset == 0 {
+		return 0, 0, nil
+	}
+	kpNode, kvNode, err := cf.ReadIDNode(cf.Header.IDTreeState.Offset)
+	if err != nil {
+		slog.Error(err)
+		return 0, 0, err
+	}
+	if kpNode != nil {
+		for _, p := range kpNode.Pointers {
+			count += p.Count
+			deleted += p.Count2
+		}
+	}
+	if kvNode != nil {
+		for _, di := range kvNode.Documents {
+			if di.Deleted != 0 {
+				deleted++
+			} else {
+				count++
+			}
+		}
+	}
+	return count, deleted, nil
+}
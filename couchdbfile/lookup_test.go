@@ -0,0 +1,53 @@
+package couchdbfile
+
+import (
+	"sync/atomic"
+	"testing"
+)
+
+func TestFindDocumentInfo(t *testing.T) {
+	cf, input := openFixture(t, largeFixture)
+	ids, _ := collectDocuments(t, cf, DocumentsOptions{Tree: IDTree})
+	fullScan := atomic.LoadInt64(&input.n)
+
+	// Every document including the first and the last key is found reading
+	// only nodes on its path
+	for _, id := range ids {
+		atomic.StoreInt64(&input.n, 0)
+		di, err := cf.FindDocumentInfo(id)
+		if err != nil || string(di.ID) != id {
+			t.Errorf("Expected to find %s, got %v", id, err)
+			continue
+		}
+		if read := atomic.LoadInt64(&input.n); read >= fullScan/4 {
+			t.Errorf("Lookup of %s read %d bytes, full scan reads %d", id, read, fullScan)
+		}
+	}
+	if ids[0] != "a" || ids[len(ids)-1] != "user:059" {
+		t.Fatalf("Unexpected first and last key %s and %s", ids[0], ids[len(ids)-1])
+	}
+
+	// Misses before the first key, between keys, after the last key
+	for _, id := range []string{"", "0", "deal:0005", "deal:06", "user:", "user:0590", "zzz"} {
+		di, err := cf.FindDocumentInfo(id)
+		if err != ErrNotFound || di != nil {
+			t.Errorf("Expected %q not to be found, got %v and %v", id, di, err)
+		}
+	}
+}
+
+func TestGetDocument(t *testing.T) {
+	cf, _ := openFixture(t, smallFixture)
+	// Deleted document is found, its winning revision is the tombstone
+	doc, err := cf.GetDocument("b")
+	if err != nil || doc.Id != "b" || doc.Deleted == 0 {
+		t.Errorf("Expected deleted document b, got %+v and %v", doc, err)
+	}
+	docs, err := cf.GetDocumentRevisions("d", DocumentsOptions{AllLeaves: true})
+	if err != nil || len(docs) != 2 {
+		t.Errorf("Expected both leaves of d, got %d and %v", len(docs), err)
+	}
+	if _, err = cf.GetDocument("missing"); err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+}
@@ -0,0 +1,234 @@
+package couchdbfile
+
+import (
+	"context"
+	"sync"
+)
+
+// walkJob is a single Btree node read by the worker. For kp_node it
+// holds offsets of the children, for kv_node decoded documents.
+type walkJob struct {
+	offset int64
+	// path holds child indexes from the root, it orders jobs in tree order
+	path      []int
+	submitted bool
+	offsets   []int64
+	docs      []*CouchDbDocument
+	// stop is set when no documents in the range follow this node
+	stop bool
+	err  error
+	done chan struct{}
+}
+
+// parallelWalker hands Btree nodes out to the pool of workers. Workers
+// read through the shared io.ReaderAt and decode documents of the
+// kv_nodes they read. Number of nodes read ahead of the consumer is
+// limited by the window, so memory use does not depend on the file size.
+type parallelWalker struct {
+	ordered   bool
+	window    int
+	running   int
+	pending   []*walkJob
+	jobs      chan *walkJob
+	completed chan *walkJob
+	// stopAt is path of the node past which the key range ends
+	stopAt    []int
+	workers   sync.WaitGroup
+	closeOnce sync.Once
+}
+
+// parallelWindowFactor is number of nodes per worker read ahead of the consumer
+const parallelWindowFactor = 4
+
+// newParallelWalker starts workers walking the tree selected by options
+func (cf *CouchDbFile) newParallelWalker(opts DocumentsOptions) *parallelWalker {
+	p := &parallelWalker{
+		ordered:   opts.Ordered,
+		window:    opts.Workers * parallelWindowFactor,
+		jobs:      make(chan *walkJob),
+		completed: make(chan *walkJob, opts.Workers*parallelWindowFactor),
+	}
+	w := cf.newTreeWalker(opts)
+	for i, offset := range w.stack {
+		p.pending = append(p.pending, newWalkJob(offset, nil, i))
+	}
+	p.workers.Add(opts.Workers)
+	for i := 0; i < opts.Workers; i++ {
+		go p.work(w, opts)
+	}
+	return p
+}
+
+// newWalkJob returns job reading i-th child of the node at parent path
+func newWalkJob(offset int64, parent []int, i int) *walkJob {
+	path := make([]int, len(parent)+1)
+	copy(path, parent)
+	path[len(parent)] = i
+	return &walkJob{offset: offset, path: path, done: make(chan struct{})}
+}
+
+// children returns jobs reading children of the node in tree order
+func (job *walkJob) children() []*walkJob {
+	children := make([]*walkJob, len(job.offsets))
+	for i, offset := range job.offsets {
+		children[i] = newWalkJob(offset, job.path, i)
+	}
+	return children
+}
+
+// pastStop reports if job is after the node ending the key range, such
+// nodes hold no documents in the range and are not read
+func (p *parallelWalker) pastStop(job *walkJob) bool {
+	if p.stopAt == nil {
+		return false
+	}
+	for i := 0; i < len(job.path) && i < len(p.stopAt); i++ {
+		if job.path[i] != p.stopAt[i] {
+			return job.path[i] > p.stopAt[i]
+		}
+	}
+	return len(job.path) > len(p.stopAt)
+}
+
+// stop records node ending the key range and drops pending jobs after it.
+// In ordered mode pending jobs may already be handed out, nobody waits
+// for those any more so they are no longer counted as running.
+func (p *parallelWalker) stop(job *walkJob) {
+	if p.stopAt != nil && !p.pastStop(job) {
+		return
+	}
+	p.stopAt = job.path
+	pending := p.pending[:0]
+	for _, j := range p.pending {
+		if !p.pastStop(j) {
+			pending = append(pending, j)
+			continue
+		}
+		if j.submitted {
+			p.running--
+		}
+	}
+	p.pending = pending
+}
+
+// work reads nodes until jobs channel is closed
+func (p *parallelWalker) work(w *treeWalker, opts DocumentsOptions) {
+	defer p.workers.Done()
+	for job := range p.jobs {
+		offsets, infos, stop, err := w.expandNode(job.offset)
+		if err != nil && w.skip(err) {
+			slog.Warnf("Skipping corrupted node at offset %d: %v", job.offset, err)
+			err = nil
+		}
+		job.offsets = offsets
+		job.stop = stop
+		job.err = err
+		for i := 0; i < len(infos) && job.err == nil; i++ {
+			docs, err := w.cf.ReadDocuments(&infos[i], opts)
+			job.docs = append(job.docs, docs...)
+			job.err = err
+		}
+		close(job.done)
+		if !p.ordered {
+			p.completed <- job
+		}
+	}
+}
+
+// next returns documents of the next kv_node or nil when the tree is exhausted
+func (p *parallelWalker) next(ctx context.Context) ([]*CouchDbDocument, error) {
+	if p.ordered {
+		return p.nextOrdered(ctx)
+	}
+	return p.nextUnordered(ctx)
+}
+
+// nextOrdered waits for nodes in the tree order. Pending jobs are kept in
+// the depth first order and the first window of them is read ahead.
+func (p *parallelWalker) nextOrdered(ctx context.Context) ([]*CouchDbDocument, error) {
+	for len(p.pending) > 0 {
+		job := p.pending[0]
+		// The first job is always read so the window can not block waiting on it
+		p.submit(job)
+		for i := 1; i < len(p.pending) && p.running < p.window; i++ {
+			p.submit(p.pending[i])
+		}
+		select {
+		case <-job.done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		p.running--
+		if job.err != nil {
+			slog.Error(job.err)
+			return nil, job.err
+		}
+		p.pending = append(job.children(), p.pending[1:]...)
+		if job.stop {
+			p.stop(job)
+		}
+		if len(job.docs) > 0 {
+			return job.docs, nil
+		}
+	}
+	return nil, nil
+}
+
+// nextUnordered takes nodes as they are read. Pending jobs are used as a
+// stack so the output roughly follows the tree order.
+func (p *parallelWalker) nextUnordered(ctx context.Context) ([]*CouchDbDocument, error) {
+	for {
+		for len(p.pending) > 0 && p.running < p.window {
+			job := p.pending[len(p.pending)-1]
+			p.pending = p.pending[:len(p.pending)-1]
+			p.submit(job)
+		}
+		if p.running == 0 {
+			return nil, nil
+		}
+		var job *walkJob
+		select {
+		case job = <-p.completed:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		p.running--
+		if p.pastStop(job) {
+			continue
+		}
+		if job.err != nil {
+			slog.Error(job.err)
+			return nil, job.err
+		}
+		// Push in reverse to keep the original tree order
+		children := job.children()
+		for i := len(children) - 1; i >= 0; i-- {
+			p.pending = append(p.pending, children[i])
+		}
+		if job.stop {
+			p.stop(job)
+		}
+		if len(job.docs) > 0 {
+			return job.docs, nil
+		}
+	}
+}
+
+// submit hands job to the workers unless it was already handed out
+func (p *parallelWalker) submit(job *walkJob) {
+	if job.submitted {
+		return
+	}
+	job.submitted = true
+	p.running++
+	p.jobs <- job
+}
+
+// close stops workers and waits until they finish jobs already handed
+// out, so the input can be closed right after
+func (p *parallelWalker) close() {
+	p.closeOnce.Do(func() {
+		close(p.jobs)
+		p.workers.Wait()
+	})
+}
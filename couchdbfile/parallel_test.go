@@ -0,0 +1,176 @@
+package couchdbfile
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"runtime"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// largeFixture has 125 documents in Btrees four levels deep
+const largeFixture = "../testdata/large.couch"
+
+// countingReader counts bytes read through it
+type countingReader struct {
+	r io.ReaderAt
+	n int64
+}
+
+func (c *countingReader) ReadAt(p []byte, off int64) (int, error) {
+	n, err := c.r.ReadAt(p, off)
+	atomic.AddInt64(&c.n, int64(n))
+	return n, err
+}
+
+// openFixture opens fixture file read through countingReader
+func openFixture(t *testing.T, filename string) (*CouchDbFile, *countingReader) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	input := &countingReader{r: bytes.NewReader(data)}
+	cf, err := New(input, int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cf, input
+}
+
+// collectDocuments returns IDs and update sequences of the documents in
+// the order iterator yields them
+func collectDocuments(t *testing.T, cf *CouchDbFile, opts DocumentsOptions) ([]string, []int64) {
+	it := cf.Documents(context.Background(), opts)
+	defer it.Close()
+	var (
+		ids  []string
+		seqs []int64
+	)
+	for it.Next() {
+		ids = append(ids, it.Doc().Id)
+		seqs = append(seqs, it.Doc().Seq)
+	}
+	if it.Err() != nil {
+		t.Fatal(it.Err())
+	}
+	return ids, seqs
+}
+
+// sorted returns sorted copy of the IDs joined with comma
+func sorted(ids []string) string {
+	ids = append([]string(nil), ids...)
+	sort.Strings(ids)
+	return strings.Join(ids, ",")
+}
+
+func TestParallelWalker(t *testing.T) {
+	cf, _ := openFixture(t, largeFixture)
+	for _, tree := range []TreeType{IDTree, SeqTree} {
+		want, _ := collectDocuments(t, cf, DocumentsOptions{Tree: tree})
+		if len(want) != 125 {
+			t.Fatalf("Tree %d: expected 125 documents, got %d", tree, len(want))
+		}
+		for _, workers := range []int{1, 4, 16} {
+			for _, ordered := range []bool{false, true} {
+				ids, seqs := collectDocuments(t, cf, DocumentsOptions{Tree: tree, Workers: workers, Ordered: ordered})
+				if sorted(ids) != sorted(want) {
+					t.Errorf("Tree %d, %d workers, ordered %v: got different documents", tree, workers, ordered)
+				}
+				if !ordered {
+					continue
+				}
+				if strings.Join(ids, ",") != strings.Join(want, ",") {
+					t.Errorf("Tree %d, %d workers: documents are out of tree order", tree, workers)
+				}
+				for i := 1; i < len(seqs) && tree == SeqTree; i++ {
+					if seqs[i] <= seqs[i-1] {
+						t.Errorf("%d workers: seq %d follows %d", workers, seqs[i], seqs[i-1])
+						break
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestParallelWalkerEndKey(t *testing.T) {
+	cf, input := openFixture(t, largeFixture)
+	collectDocuments(t, cf, DocumentsOptions{Tree: IDTree, Workers: 4})
+	fullScan := atomic.LoadInt64(&input.n)
+	want := "a,b,c,d,deal:000,deal:001,deal:002"
+	for _, ordered := range []bool{false, true} {
+		atomic.StoreInt64(&input.n, 0)
+		it := cf.Documents(context.Background(), DocumentsOptions{Tree: IDTree, EndKey: "deal:002", Workers: 4, Ordered: ordered})
+		var ids []string
+		for it.Next() {
+			ids = append(ids, it.Doc().Id)
+		}
+		it.Close()
+		if it.Err() != nil {
+			t.Fatal(it.Err())
+		}
+		if sorted(ids) != want {
+			t.Errorf("Ordered %v: expected %s, got %v", ordered, want, ids)
+		}
+		// Subtrees past the end key are not read
+		if read := atomic.LoadInt64(&input.n); read >= fullScan/2 {
+			t.Errorf("Ordered %v: read %d bytes, full scan reads %d", ordered, read, fullScan)
+		}
+		// Jobs dropped after the end key do not keep the window taken
+		if it.p.running != 0 {
+			t.Errorf("Ordered %v: %d jobs still counted as running", ordered, it.p.running)
+		}
+	}
+}
+
+func TestParallelWalkerCancel(t *testing.T) {
+	cf, _ := openFixture(t, largeFixture)
+	before := runtime.NumGoroutine()
+	for _, ordered := range []bool{false, true} {
+		ctx, cancel := context.WithCancel(context.Background())
+		it := cf.Documents(ctx, DocumentsOptions{Tree: SeqTree, Workers: 16, Ordered: ordered})
+		if !it.Next() {
+			t.Fatal(it.Err())
+		}
+		cancel()
+		for it.Next() {
+		}
+		if it.Err() != context.Canceled {
+			t.Errorf("Ordered %v: expected context.Canceled, got %v", ordered, it.Err())
+		}
+		it.Close()
+	}
+	// Workers exit once Close returns, allow runtime a moment to reap them
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if after := runtime.NumGoroutine(); after > before {
+		t.Errorf("Expected %d goroutines after Close, got %d", before, after)
+	}
+}
+
+func TestParallelWalkerStop(t *testing.T) {
+	// Ordered walker keeps jobs already handed out in pending, dropping
+	// them after the stop node must release their place in the window
+	job := func(submitted bool, path ...int) *walkJob {
+		return &walkJob{path: path, submitted: submitted}
+	}
+	p := &parallelWalker{
+		ordered: true,
+		running: 3,
+		pending: []*walkJob{job(true, 0, 1), job(true, 0, 2), job(true, 1), job(false, 2)},
+	}
+	p.stop(job(true, 0, 1))
+	if len(p.pending) != 1 || p.pending[0].path[1] != 1 {
+		t.Errorf("Expected only job before the stop to stay pending, got %d jobs", len(p.pending))
+	}
+	if p.running != 1 {
+		t.Errorf("Expected 1 running job, got %d", p.running)
+	}
+}
@@ -1,9 +1,8 @@
 package couchdbfile
 
 import (
-	"encoding/json"
+	"errors"
 	"fmt"
-	"strings"
 
 	"github.com/pipedrive/uncouch/leakybucket"
 	"github.com/pipedrive/uncouch/termite"
@@ -14,13 +13,13 @@ import (
 
 // ReadNodeBytes reads node bytes from given offset
 func (cf *CouchDbFile) ReadNodeBytes(offset int64) (*[]byte, error) {
-	return couchbytes.ReadNodeBytes(cf.input, offset)
+	return couchbytes.ReadNodeBytes(cf.input, offset, cf.opts.Verify)
 }
 
 // ReadIDNode reads ID Btree node from the given offset
 func (cf *CouchDbFile) ReadIDNode(offset int64) (*KpNodeID, *KvNode, error) {
 	// slog.Debugf("Starting readNode with offset %d", offset)
-	buf, err := couchbytes.ReadNodeBytes(cf.input, offset)
+	buf, err := couchbytes.ReadNodeBytes(cf.input, offset, cf.opts.Verify)
 	if err != nil {
 		slog.Error(err)
 		return nil, nil, err
@@ -75,7 +74,7 @@ func (cf *CouchDbFile) ReadSeqNode(offset int64) (*KpNodeSeq, *KvNode, error) {
 		return nil, nil, nil
 	}
 
-	buf, err := couchbytes.ReadNodeBytes(cf.input, offset)
+	buf, err := couchbytes.ReadNodeBytes(cf.input, offset, cf.opts.Verify)
 	if err != nil {
 		slog.Error(err)
 		return nil, nil, err
@@ -123,18 +122,54 @@ func (cf *CouchDbFile) ReadSeqNode(offset int64) (*KpNodeSeq, *KvNode, error) {
 	}
 }
 
-// ReadDbHeader reads DB header from input Reader
+// ReadDbHeader reads DB header from input Reader. Header truncated by
+// interrupted write at the end of the file is passed over in favour of
+// the previous one like CouchDB itself does. When corrupted blocks are
+// skipped, the same applies to headers failing verification.
 func (cf *CouchDbFile) ReadDbHeader() (*DbHeader, error) {
-	offset, err := cf.Header.findHeader(cf.input, cf.size)
-	if err != nil {
-		slog.Error(err)
-		return nil, err
+	size := cf.size
+	for {
+		offset, err := cf.Header.findHeader(cf.input, size)
+		if err != nil {
+			slog.Error(err)
+			return nil, err
+		}
+		header, err := cf.readDbHeaderAt(offset)
+		var truncatedErr *couchbytes.TruncatedError
+		if err != nil && errors.As(err, &truncatedErr) {
+			slog.Warnf("Skipping truncated DB header at offset %d", offset)
+			size = offset - 1
+			continue
+		}
+		var checksumErr *couchbytes.ChecksumError
+		if err != nil && cf.skipCorrupted(err) && errors.As(err, &checksumErr) && offset > couchbytes.BlockAlignment {
+			slog.Warnf("Skipping corrupted DB header at offset %d", offset)
+			cf.corruptedHeaders = append(cf.corruptedHeaders, checksumErr)
+			// Continue search from the block before the corrupted one
+			size = offset - 1
+			continue
+		}
+		if err != nil {
+			slog.Error(err)
+			return nil, err
+		}
+		cf.headerOffset = offset
+		return header, nil
 	}
-	buf, err := couchbytes.ReadDbHeaderBytes(cf.input, offset)
+}
+
+// readDbHeaderAt reads DB header from the given offset
+func (cf *CouchDbFile) readDbHeaderAt(offset int64) (*DbHeader, error) {
+	buf, err := couchbytes.ReadDbHeaderBytes(cf.input, offset, cf.opts.Verify)
 	if err != nil {
 		slog.Error(err)
 		return nil, err
 	}
+	return cf.parseDbHeader(buf)
+}
+
+// parseDbHeader parses DB header from the header bytes and releases them
+func (cf *CouchDbFile) parseDbHeader(buf *[]byte) (*DbHeader, error) {
 	defer leakybucket.PutBytes(buf)
 	s, err := erldeser.NewScanner(*buf)
 	if err != nil {
@@ -158,44 +193,3 @@ func (cf *CouchDbFile) ReadDbHeader() (*DbHeader, error) {
 	t.Release()
 	return &header, err
 }
-
-func (cf *CouchDbFile) ReadOffset(offset int64, couchDbDocuments []CouchDbDocument) []CouchDbDocument {
-	for {
-		kpNode, kvNode, err := cf.ReadSeqNode(offset)
-		if err != nil {
-			panic(err)
-		}
-		if kpNode != nil && kvNode != nil {
-			log.Info("Empty Node.")
-		}
-		if kpNode != nil {
-			// Pointer node, dig deeper
-			for _, node := range kpNode.Pointers {
-				couchDbDocuments = cf.ReadOffset(node.Offset, couchDbDocuments)
-			}
-		} else if kvNode != nil {
-			for _, document := range kvNode.Documents {
-				output := leakybucket.GetBuffer()
-				err = cf.WriteDocument(&document, output)
-				if err != nil {
-					panic(err)
-				}
-
-				var pl map[string]interface{}
-				if err := json.Unmarshal(output.Bytes(), &pl); err != nil {
-					panic(err)
-				}
-				cd := CouchDbDocument{
-					Id:      strings.TrimSpace(string(document.ID)),
-					Deleted: document.Deleted,
-					Rev:     "",
-					Value:   pl,
-				}
-				couchDbDocuments = append(couchDbDocuments, cd)
-				leakybucket.PutBuffer(output)
-			}
-		}
-		break
-	}
-	return couchDbDocuments
-}
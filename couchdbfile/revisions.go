@@ -0,0 +1,119 @@
+package couchdbfile
+
+import (
+	"bytes"
+	"encoding/hex"
+	"sort"
+	"strconv"
+)
+
+// RevisionHistory is revision path of the document as CouchDB shows
+// it in _revisions object, IDs are listed newest first
+type RevisionHistory struct {
+	Start int64    `json:"start"`
+	IDs   []string `json:"ids"`
+}
+
+// String returns revision in CouchDB "N-hex" format
+func (r *Revision) String() string {
+	return strconv.FormatInt(r.Pos, 10) + "-" + r.IDString()
+}
+
+// IDString returns revision ID without revision number
+func (r *Revision) IDString() string {
+	// Revision IDs created by CouchDB are MD5 hashes, other ones are kept as is
+	if len(r.RevID) == 16 {
+		return hex.EncodeToString(r.RevID)
+	}
+	return string(r.RevID)
+}
+
+// revisionLess reports if revision a loses to revision b according to
+// CouchDB rules: not deleted wins over deleted, then higher revision number
+// wins and finally higher revision ID wins.
+func revisionLess(a, b *Revision) bool {
+	if a.Deleted != b.Deleted {
+		return a.Deleted > b.Deleted
+	}
+	if a.Pos != b.Pos {
+		return a.Pos < b.Pos
+	}
+	return bytes.Compare(a.RevID, b.RevID) < 0
+}
+
+// Wins reports if revision wins over the other one according to CouchDB rules
+func (r *Revision) Wins(other *Revision) bool {
+	return revisionLess(other, r)
+}
+
+// Leaves returns leaf revisions of the revision tree, winning revision first
+func (di *DocumentInfo) Leaves() []*Revision {
+	leaves := make([]*Revision, 0, 1)
+	for i := range di.Revisions {
+		if di.Revisions[i].Leaf {
+			leaves = append(leaves, &di.Revisions[i])
+		}
+	}
+	sort.SliceStable(leaves, func(i, j int) bool {
+		return revisionLess(leaves[j], leaves[i])
+	})
+	return leaves
+}
+
+// WinningRevision returns revision CouchDB would pick as current one
+// or nil if revision tree is empty
+func (di *DocumentInfo) WinningRevision() *Revision {
+	var winner *Revision
+	for i := range di.Revisions {
+		r := &di.Revisions[i]
+		if r.Leaf && (winner == nil || revisionLess(winner, r)) {
+			winner = r
+		}
+	}
+	return winner
+}
+
+// FindRevision returns revision of the tree given in "N-hex" format or nil
+// if there is no such revision
+func (di *DocumentInfo) FindRevision(rev string) *Revision {
+	for i := range di.Revisions {
+		if di.Revisions[i].String() == rev {
+			return &di.Revisions[i]
+		}
+	}
+	return nil
+}
+
+// Conflicts returns not deleted leaf revisions losing to the winning revision
+func (di *DocumentInfo) Conflicts() []*Revision {
+	var conflicts []*Revision
+	for i, r := range di.Leaves() {
+		if i > 0 && r.Deleted == 0 {
+			conflicts = append(conflicts, r)
+		}
+	}
+	return conflicts
+}
+
+// Path returns revision and its ancestors, starting from the revision itself
+func (di *DocumentInfo) Path(r *Revision) []*Revision {
+	path := []*Revision{r}
+	for r.Parent >= 0 {
+		r = &di.Revisions[r.Parent]
+		path = append(path, r)
+	}
+	return path
+}
+
+// RevisionHistory returns revision path from given revision to the root of its branch
+func (di *DocumentInfo) RevisionHistory(r *Revision) RevisionHistory {
+	path := di.Path(r)
+	history := RevisionHistory{
+		Start: r.Pos,
+		IDs:   make([]string, 0, len(path)),
+	}
+	for _, p := range path {
+		history.IDs = append(history.IDs, p.IDString())
+	}
+	return history
+}
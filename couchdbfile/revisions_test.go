@@ -0,0 +1,98 @@
+package couchdbfile
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+// rev returns revision with given number and ID, parent index and flags
+func rev(pos int64, id string, parent int, leaf bool, deleted int8) Revision {
+	return Revision{Pos: pos, RevID: []byte(id), Parent: parent, Leaf: leaf, Deleted: deleted}
+}
+
+// revStrings returns revisions in "N-id" format joined with comma
+func revStrings(revs []*Revision) string {
+	s := make([]string, len(revs))
+	for i, r := range revs {
+		s[i] = r.String()
+	}
+	return strings.Join(s, ",")
+}
+
+func TestRevisionTree(t *testing.T) {
+	// 1-a ─┬─ 2-b ── 3-d (deleted)
+	//      └─ 2-c
+	// 1-e ─── 2-0
+	di := DocumentInfo{Revisions: []Revision{
+		rev(1, "a", -1, false, 0),
+		rev(2, "b", 0, false, 0),
+		rev(2, "c", 0, true, 0),
+		rev(3, "d", 1, true, 1),
+		rev(1, "e", -1, false, 0),
+		rev(2, "0", 4, true, 0),
+	}}
+	// Deleted leaf loses despite higher number, equal numbers compare IDs
+	if w := di.WinningRevision(); w == nil || w.String() != "2-c" {
+		t.Errorf("Expected winner 2-c, got %v", w)
+	}
+	if got := revStrings(di.Leaves()); got != "2-c,2-0,3-d" {
+		t.Errorf("Expected leaves 2-c,2-0,3-d, got %s", got)
+	}
+	if got := revStrings(di.Conflicts()); got != "2-0" {
+		t.Errorf("Expected conflicts 2-0, got %s", got)
+	}
+	if got := revStrings(di.Path(&di.Revisions[3])); got != "3-d,2-b,1-a" {
+		t.Errorf("Expected path 3-d,2-b,1-a, got %s", got)
+	}
+	history := di.RevisionHistory(&di.Revisions[5])
+	if history.Start != 2 || strings.Join(history.IDs, ",") != "0,e" {
+		t.Errorf("Expected history from 2 with 0,e, got %+v", history)
+	}
+	if r := di.FindRevision("2-b"); r != &di.Revisions[1] {
+		t.Errorf("Expected to find 2-b, got %v", r)
+	}
+	if r := di.FindRevision("2-x"); r != nil {
+		t.Errorf("Expected no revision 2-x, got %v", r)
+	}
+
+	// Only deleted leaves, the highest one wins and nothing conflicts
+	deleted := DocumentInfo{Revisions: []Revision{
+		rev(1, "a", -1, false, 0),
+		rev(2, "b", 0, true, 1),
+		rev(3, "c", 0, true, 1),
+	}}
+	if w := deleted.WinningRevision(); w == nil || w.String() != "3-c" {
+		t.Errorf("Expected deleted winner 3-c, got %v", w)
+	}
+	if got := revStrings(deleted.Conflicts()); got != "" {
+		t.Errorf("Expected no conflicts, got %s", got)
+	}
+
+	// Equal leaves are ordered by ID bytes, MD5 IDs are shown in hex
+	equal := DocumentInfo{Revisions: []Revision{
+		rev(1, "\x01\x02\x03\x04\x05\x06\x07\x08\x09\x0a\x0b\x0c\x0d\x0e\x0f\x10", -1, true, 0),
+		rev(1, "\xff\x02\x03\x04\x05\x06\x07\x08\x09\x0a\x0b\x0c\x0d\x0e\x0f\x10", -1, true, 0),
+	}}
+	if got := revStrings(equal.Leaves()); got != "1-ff02030405060708090a0b0c0d0e0f10,1-0102030405060708090a0b0c0d0e0f10" {
+		t.Errorf("Unexpected leaves %s", got)
+	}
+	if (&DocumentInfo{}).WinningRevision() != nil {
+		t.Error("Expected no winner of empty revision tree")
+	}
+}
+
+func TestWinningRevisionRead(t *testing.T) {
+	cf, _ := openFixture(t, smallFixture)
+	it := cf.Documents(context.Background(), DocumentsOptions{Tree: IDTree, StartKey: "d", EndKey: "d", Conflicts: true})
+	defer it.Close()
+	if !it.Next() {
+		t.Fatal(it.Err())
+	}
+	// Both leaves of "d" are at 2, winner has the greater ID and _rev
+	// of the document body read is the winner
+	doc := it.Doc()
+	if doc.Rev != "2-d2a452f0ab3e372da86c88f1d11ba085" || strings.Join(doc.Conflicts, ",") != "2-3ed027caea2c9a32c7329eed2dc08242" {
+		t.Errorf("Unexpected revision %s with conflicts %v", doc.Rev, doc.Conflicts)
+	}
+}
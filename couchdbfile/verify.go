@@ -0,0 +1,180 @@
+package couchdbfile
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"errors"
+
+	"github.com/pipedrive/uncouch/couchbytes"
+	"github.com/pipedrive/uncouch/leakybucket"
+)
+
+// Verify checks MD5 hashes of the DB header, of every document revision
+// body reachable from the sequence Btree and of its attachment data.
+// Nodes of the sequence, ID and local documents Btrees are checked only
+// when file was opened with Verify option. Corrupted blocks are collected
+// and returned together with newer headers skipped while opening the
+// file, walking stops only on other errors.
+func (cf *CouchDbFile) Verify(ctx context.Context) ([]*couchbytes.ChecksumError, error) {
+	corrupted := append([]*couchbytes.ChecksumError(nil), cf.corruptedHeaders...)
+	collect := func(err error) bool {
+		var checksumErr *couchbytes.ChecksumError
+		if errors.As(err, &checksumErr) {
+			slog.Warn(checksumErr)
+			corrupted = append(corrupted, checksumErr)
+			return true
+		}
+		return false
+	}
+
+	buf, err := couchbytes.ReadDbHeaderBytes(cf.input, cf.headerOffset, true)
+	if err != nil && !collect(err) {
+		slog.Error(err)
+		return corrupted, err
+	}
+	if err == nil {
+		leakybucket.PutBytes(buf)
+	}
+
+	err = cf.verifyTree(ctx, SeqTree, collect, func(rev *Revision) error {
+		return cf.verifyRevision(rev, collect)
+	})
+	if err != nil {
+		slog.Error(err)
+		return corrupted, err
+	}
+	// Documents are the same in both trees, ID tree is checked for nodes only
+	err = cf.verifyTree(ctx, IDTree, collect, nil)
+	if err != nil {
+		slog.Error(err)
+		return corrupted, err
+	}
+	err = cf.verifyLocalTree(ctx, collect)
+	if err != nil {
+		slog.Error(err)
+		return corrupted, err
+	}
+	return corrupted, nil
+}
+
+// verifyTree walks every node of the tree and calls verifyRev, if set,
+// for every revision with stored body
+func (cf *CouchDbFile) verifyTree(ctx context.Context, tree TreeType, collect func(err error) bool, verifyRev func(rev *Revision) error) error {
+	w := cf.newTreeWalker(DocumentsOptions{Tree: tree})
+	w.skip = collect
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+		di, err := w.next()
+		if err != nil {
+			slog.Error(err)
+			return err
+		}
+		if di == nil {
+			return nil
+		}
+		if verifyRev == nil {
+			continue
+		}
+		for i := range di.Revisions {
+			if di.Revisions[i].Offset < 0 {
+				// Revision body is not stored
+				continue
+			}
+			err := verifyRev(&di.Revisions[i])
+			if err != nil {
+				slog.Error(err)
+				return err
+			}
+		}
+	}
+}
+
+// verifyRevision checks document summary block of the revision and data
+// of its attachments
+func (cf *CouchDbFile) verifyRevision(rev *Revision, collect func(err error) bool) error {
+	docBytes, attBytes, err := couchbytes.ReadDocumentSummaryBytes(cf.input, rev.Offset, true)
+	if err != nil && collect(err) {
+		return nil
+	}
+	if err != nil {
+		slog.Error(err)
+		return err
+	}
+	leakybucket.PutBytes(docBytes)
+	defer leakybucket.PutBytes(attBytes)
+	attachments, err := readAttachments(*attBytes)
+	if err != nil {
+		slog.Error(err)
+		return err
+	}
+	for i := range attachments {
+		err := cf.verifyAttachment(&attachments[i])
+		if err != nil && !collect(err) {
+			slog.Error(err)
+			return err
+		}
+	}
+	return nil
+}
+
+// verifyAttachment reads every chunk of the attachment and compares MD5
+// hash of the stored data with attachment digest. Mismatch is reported
+// at the offset of the first chunk.
+func (cf *CouchDbFile) verifyAttachment(a *Attachment) error {
+	if len(a.Pointers) == 0 {
+		return nil
+	}
+	hash := md5.New()
+	for _, p := range a.Pointers {
+		buf, err := couchbytes.ReadChunkBytes(cf.input, p.Offset, true)
+		if err != nil {
+			slog.Error(err)
+			return err
+		}
+		hash.Write(*buf)
+		leakybucket.PutBytes(buf)
+	}
+	sum := hash.Sum(nil)
+	if len(a.Digest) > 0 && !bytes.Equal(a.Digest, sum) {
+		err := &couchbytes.ChecksumError{
+			Offset:   a.Pointers[0].Offset,
+			Expected: a.Digest,
+			Actual:   sum,
+		}
+		slog.Error(err)
+		return err
+	}
+	return nil
+}
+
+// verifyLocalTree walks every node of the local documents Btree
+func (cf *CouchDbFile) verifyLocalTree(ctx context.Context, collect func(err error) bool) error {
+	var stack []int64
+	if cf.Header.LocalTreeState.Offset != 0 {
+		stack = append(stack, cf.Header.LocalTreeState.Offset)
+	}
+	for len(stack) > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+		offset := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		offsets, _, err := cf.ReadLocalNode(offset)
+		if err != nil && collect(err) {
+			continue
+		}
+		if err != nil {
+			slog.Error(err)
+			return err
+		}
+		stack = append(stack, offsets...)
+	}
+	return nil
+}
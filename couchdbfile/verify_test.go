@@ -0,0 +1,67 @@
+package couchdbfile
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"testing"
+)
+
+// verifyFixture has local documents, document "e" with attachments and
+// Btree nodes stored with MD5 hash
+const verifyFixture = "testdata/small.couch"
+
+// verifyCorrupted flips byte at offset of the fixture and verifies it
+func verifyCorrupted(t *testing.T, data []byte, offset int) []int64 {
+	corrupt := append([]byte(nil), data...)
+	if offset >= 0 {
+		corrupt[offset] ^= 0xff
+	}
+	cf, err := NewWithOptions(bytes.NewReader(corrupt), int64(len(corrupt)), Options{Verify: true, SkipCorrupted: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	corrupted, err := cf.Verify(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var offsets []int64
+	for _, c := range corrupted {
+		offsets = append(offsets, c.Offset)
+	}
+	return offsets
+}
+
+func TestVerify(t *testing.T) {
+	data, err := ioutil.ReadFile(verifyFixture)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cf, err := New(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := verifyCorrupted(t, data, -1); len(got) != 0 {
+		t.Fatalf("Expected no corrupted blocks, got %v", got)
+	}
+
+	// Byte in the middle of the node data, past size and MD5 hash
+	idRoot := cf.Header.IDTreeState.Offset
+	localRoot := cf.Header.LocalTreeState.Offset
+	for name, root := range map[string]int64{"ID tree": idRoot, "local tree": localRoot} {
+		got := verifyCorrupted(t, data, int(root)+30)
+		if len(got) != 1 || got[0] != root {
+			t.Errorf("%s: expected corrupted node at %d, got %v", name, root, got)
+		}
+	}
+
+	// Attachment data is checked against attachment digest
+	pos := bytes.Index(data, []byte("hello world hello world"))
+	if pos < 0 {
+		t.Fatal("Attachment data not found")
+	}
+	got := verifyCorrupted(t, data, pos+3)
+	if len(got) != 1 || got[0] >= int64(pos) {
+		t.Errorf("Expected corrupted attachment before %d, got %v", pos, got)
+	}
+}
@@ -0,0 +1,147 @@
+// Package couchdir finds database files in CouchDB data directory. Since
+// CouchDB 2.0 every database is split into shards stored as
+// shards/<range>/<db>.<suffix>.couch, older versions keep single <db>.couch
+// file per database.
+package couchdir
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const (
+	couchExtension = ".couch"
+	shardsDir      = "shards"
+)
+
+// shardRange matches directory name of the shard range like 00000000-1fffffff
+var shardRange = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{8}$`)
+
+// nodeLocalDbs are system databases describing the node and the cluster
+// instead of holding user data
+var nodeLocalDbs = map[string]bool{
+	"_dbs":   true,
+	"_nodes": true,
+}
+
+// Shard is single database file. Range and Suffix are empty for database
+// files which are not shards.
+type Shard struct {
+	Path   string
+	DbName string
+	Range  string
+	Suffix string
+}
+
+// Database is logical database made of one or more shard files ordered by range
+type Database struct {
+	Name   string
+	Shards []Shard
+}
+
+// ParseShardPath parses database name, range and suffix out of the shard
+// file path. It returns false if the path is not shards/<range>/<db>.<suffix>.couch.
+// Database name can contain slashes which are stored as subdirectories.
+func ParseShardPath(path string) (Shard, bool) {
+	shard := Shard{Path: path}
+	if !strings.HasSuffix(path, couchExtension) {
+		return shard, false
+	}
+	parts := strings.Split(filepath.ToSlash(filepath.Clean(path)), "/")
+	for i := 0; i+2 < len(parts); i++ {
+		if parts[i] != shardsDir || !shardRange.MatchString(parts[i+1]) {
+			continue
+		}
+		name := strings.TrimSuffix(strings.Join(parts[i+2:], "/"), couchExtension)
+		dot := strings.LastIndex(name, ".")
+		if dot < 0 || !isDigits(name[dot+1:]) {
+			return shard, false
+		}
+		shard.DbName = name[:dot]
+		shard.Range = parts[i+1]
+		shard.Suffix = name[dot+1:]
+		return shard, true
+	}
+	return shard, false
+}
+
+// isDigits checks if string is non empty and made of digits only
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// Find walks data directory and returns databases found there grouped by
+// name. Shard files get database name with the suffix removed, other
+// .couch files are databases of their own except node local system
+// databases. Hidden directories like .shards with view indexes are skipped.
+func Find(dataDir string) ([]Database, error) {
+	dataDir, err := filepath.Abs(dataDir)
+	if err != nil {
+		slog.Error(err)
+		return nil, err
+	}
+	byName := make(map[string]*Database)
+	err = filepath.Walk(dataDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			slog.Error(err)
+			return err
+		}
+		if info.IsDir() {
+			if path != dataDir && strings.HasPrefix(info.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !info.Mode().IsRegular() || !strings.HasSuffix(path, couchExtension) {
+			return nil
+		}
+		shard, ok := ParseShardPath(path)
+		if !ok {
+			rel, err := filepath.Rel(dataDir, path)
+			if err != nil {
+				slog.Error(err)
+				return err
+			}
+			shard.DbName = strings.TrimSuffix(filepath.ToSlash(rel), couchExtension)
+			if nodeLocalDbs[shard.DbName] {
+				return nil
+			}
+		}
+		db, ok := byName[shard.DbName]
+		if !ok {
+			db = &Database{Name: shard.DbName}
+			byName[shard.DbName] = db
+		}
+		db.Shards = append(db.Shards, shard)
+		return nil
+	})
+	if err != nil {
+		slog.Error(err)
+		return nil, err
+	}
+	dbs := make([]Database, 0, len(byName))
+	for _, db := range byName {
+		sort.Slice(db.Shards, func(i, j int) bool {
+			if db.Shards[i].Range != db.Shards[j].Range {
+				return db.Shards[i].Range < db.Shards[j].Range
+			}
+			return db.Shards[i].Path < db.Shards[j].Path
+		})
+		dbs = append(dbs, *db)
+	}
+	sort.Slice(dbs, func(i, j int) bool {
+		return dbs[i].Name < dbs[j].Name
+	})
+	return dbs, nil
+}
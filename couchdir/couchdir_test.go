@@ -0,0 +1,98 @@
+package couchdir
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseShardPath(t *testing.T) {
+	tests := []struct {
+		path  string
+		shard Shard
+		ok    bool
+	}{
+		{"/data/shards/00000000-7fffffff/db.1600000000.couch", Shard{DbName: "db", Range: "00000000-7fffffff", Suffix: "1600000000"}, true},
+		{"shards/80000000-ffffffff/db.name.1.couch", Shard{DbName: "db.name", Range: "80000000-ffffffff", Suffix: "1"}, true},
+		// Database name with slashes is stored in subdirectories
+		{"/data/shards/00000000-ffffffff/org/db.1.couch", Shard{DbName: "org/db", Range: "00000000-ffffffff", Suffix: "1"}, true},
+		{"/data/shards/00000000-7fffffff/db.couch", Shard{}, false},
+		{"/data/shards/00000000-7fffffff/db.1a.couch", Shard{}, false},
+		{"/data/shards/0000000-7fffffff/db.1.couch", Shard{}, false},
+		{"/data/shards/00000000-7fffffff/db.1.couch.compact", Shard{}, false},
+		{"/data/db.couch", Shard{}, false},
+	}
+	for _, test := range tests {
+		shard, ok := ParseShardPath(test.path)
+		test.shard.Path = test.path
+		if ok != test.ok || (ok && shard != test.shard) {
+			t.Errorf("Path %s: expected %+v and %v, got %+v and %v", test.path, test.shard, test.ok, shard, ok)
+		}
+	}
+}
+
+func TestFind(t *testing.T) {
+	dir, err := ioutil.TempDir("", "couchdir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, name := range []string{
+		"shards/80000000-ffffffff/db.1.couch",
+		"shards/00000000-7fffffff/db.1.couch",
+		"shards/00000000-ffffffff/org/db.2.couch",
+		"shards/00000000-ffffffff/other.3.couch",
+		"legacy.couch",
+		"org/legacy.couch",
+		// Node local databases, view indexes and other files are skipped
+		"_dbs.couch",
+		"_nodes.couch",
+		".shards/00000000-7fffffff/db.1_design/mrview/x.view",
+		".shards/00000000-7fffffff/db.1.couch",
+		"shards/00000000-7fffffff/db.1.couch.compact.data",
+		"notes.txt",
+	} {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		err = os.MkdirAll(filepath.Dir(path), 0755)
+		if err == nil {
+			err = ioutil.WriteFile(path, nil, 0644)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dbs, err := Find(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[string][]string)
+	var names []string
+	for _, db := range dbs {
+		names = append(names, db.Name)
+		for _, shard := range db.Shards {
+			rel, _ := filepath.Rel(dir, shard.Path)
+			got[db.Name] = append(got[db.Name], shard.Range+" "+filepath.ToSlash(rel))
+		}
+	}
+	// Databases are ordered by name, shards by range
+	if want := []string{"db", "legacy", "org/db", "org/legacy", "other"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("Expected databases %v, got %v", want, names)
+	}
+	want := map[string][]string{
+		"db":         {"00000000-7fffffff shards/00000000-7fffffff/db.1.couch", "80000000-ffffffff shards/80000000-ffffffff/db.1.couch"},
+		"legacy":     {" legacy.couch"},
+		"org/db":     {"00000000-ffffffff shards/00000000-ffffffff/org/db.2.couch"},
+		"org/legacy": {" org/legacy.couch"},
+		"other":      {"00000000-ffffffff shards/00000000-ffffffff/other.3.couch"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected shards %v, got %v", want, got)
+	}
+
+	if _, err := Find(filepath.Join(dir, "missing")); err == nil {
+		t.Error("Expected error for missing data directory")
+	}
+}
@@ -0,0 +1,206 @@
+package couchdir
+
+import (
+	"bytes"
+	"context"
+
+	"github.com/pipedrive/uncouch/couchdbfile"
+)
+
+// InfoMergeIterator merges DocumentInfo records of several shard files of
+// the same database in document ID order. Document found in more than one
+// file, for example in replicas or after shard split, is returned once
+// from the file holding its winning revision. Document bodies are not read.
+type InfoMergeIterator struct {
+	ctx     context.Context
+	its     []*couchdbfile.DocumentInfoIterator
+	heads   []*couchdbfile.DocumentInfo
+	started bool
+	di      *couchdbfile.DocumentInfo
+	source  int
+	err     error
+}
+
+// MergeInfos returns iterator over DocumentInfo records of the files with
+// duplicates merged. Files are walked through their ID trees limited by
+// key range of the options.
+func MergeInfos(ctx context.Context, files []*couchdbfile.CouchDbFile, opts couchdbfile.DocumentsOptions) *InfoMergeIterator {
+	opts.Tree = couchdbfile.IDTree
+	it := &InfoMergeIterator{
+		ctx:   ctx,
+		its:   make([]*couchdbfile.DocumentInfoIterator, len(files)),
+		heads: make([]*couchdbfile.DocumentInfo, len(files)),
+	}
+	for i, cf := range files {
+		it.its[i] = cf.DocumentInfos(ctx, opts)
+	}
+	return it
+}
+
+// Next advances iterator to the next document. It returns false when
+// there are no more documents, iteration was cancelled or error occurred.
+func (it *InfoMergeIterator) Next() bool {
+	it.di = nil
+	if it.err != nil {
+		return false
+	}
+	if !it.started {
+		it.started = true
+		for i := range it.its {
+			if it.err = it.advance(i); it.err != nil {
+				return false
+			}
+		}
+	}
+	var id []byte
+	for _, di := range it.heads {
+		if di != nil && (id == nil || bytes.Compare(di.ID, id) < 0) {
+			id = di.ID
+		}
+	}
+	if id == nil {
+		return false
+	}
+	copies := make([]*couchdbfile.DocumentInfo, len(it.heads))
+	for i, di := range it.heads {
+		if di != nil && bytes.Equal(di.ID, id) {
+			copies[i] = di
+		}
+	}
+	it.source = WinningSource(copies)
+	it.di = copies[it.source]
+	for i, di := range copies {
+		if di == nil {
+			continue
+		}
+		if it.err = it.advance(i); it.err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// WinningSource returns index of the copy holding winning revision of the
+// document, nil copies are skipped. Revision trees of the copies are not
+// merged, but leaf which is inner revision in another copy was superseded
+// there and can not win.
+func WinningSource(copies []*couchdbfile.DocumentInfo) int {
+	inner := make(map[string]bool)
+	for _, di := range copies {
+		if di == nil {
+			continue
+		}
+		for i := range di.Revisions {
+			if !di.Revisions[i].Leaf {
+				inner[di.Revisions[i].String()] = true
+			}
+		}
+	}
+	best := -1
+	var winner *couchdbfile.Revision
+	for i, di := range copies {
+		if di == nil {
+			continue
+		}
+		if best < 0 {
+			best = i
+		}
+		for _, r := range di.Leaves() {
+			if !inner[r.String()] && (winner == nil || r.Wins(winner)) {
+				best = i
+				winner = r
+			}
+		}
+	}
+	return best
+}
+
+// advance moves iterator of the i-th file to its next document
+func (it *InfoMergeIterator) advance(i int) error {
+	if it.its[i].Next() {
+		it.heads[i] = it.its[i].Info()
+		return nil
+	}
+	it.heads[i] = nil
+	if err := it.its[i].Err(); err != nil {
+		slog.Error(err)
+		return err
+	}
+	return nil
+}
+
+// Info returns DocumentInfo iterator is currently positioned at
+func (it *InfoMergeIterator) Info() *couchdbfile.DocumentInfo {
+	return it.di
+}
+
+// Source returns index of the file current DocumentInfo comes from
+func (it *InfoMergeIterator) Source() int {
+	return it.source
+}
+
+// Err returns error which stopped the iteration, if any
+func (it *InfoMergeIterator) Err() error {
+	return it.err
+}
+
+// DedupeIterator reads documents merged by InfoMergeIterator, each one
+// from the file holding its winning revision
+type DedupeIterator struct {
+	files   []*couchdbfile.CouchDbFile
+	opts    couchdbfile.DocumentsOptions
+	infos   *InfoMergeIterator
+	pending []*couchdbfile.CouchDbDocument
+	source  int
+	doc     *couchdbfile.CouchDbDocument
+	err     error
+}
+
+// Dedupe returns iterator over documents of the files with duplicates
+// merged. Files are walked through their ID trees.
+func Dedupe(ctx context.Context, files []*couchdbfile.CouchDbFile, opts couchdbfile.DocumentsOptions) *DedupeIterator {
+	return &DedupeIterator{
+		files: files,
+		opts:  opts,
+		infos: MergeInfos(ctx, files, opts),
+	}
+}
+
+// Next advances iterator to the next document. It returns false when
+// there are no more documents, iteration was cancelled or error occurred.
+func (it *DedupeIterator) Next() bool {
+	it.doc = nil
+	if it.err != nil {
+		return false
+	}
+	for len(it.pending) == 0 {
+		if !it.infos.Next() {
+			it.err = it.infos.Err()
+			return false
+		}
+		it.source = it.infos.Source()
+		it.pending, it.err = it.files[it.source].ReadDocuments(it.infos.Info(), it.opts)
+		if it.err != nil {
+			slog.Error(it.err)
+			return false
+		}
+	}
+	it.doc = it.pending[0]
+	it.pending = it.pending[1:]
+	return true
+}
+
+// Doc returns document iterator is currently positioned at
+func (it *DedupeIterator) Doc() *couchdbfile.CouchDbDocument {
+	return it.doc
+}
+
+// Source returns index of the file current document was read from
+func (it *DedupeIterator) Source() int {
+	return it.source
+}
+
+// Err returns error which stopped the iteration, if any
+func (it *DedupeIterator) Err() error {
+	return it.err
+}
@@ -0,0 +1,109 @@
+package couchdir
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/pipedrive/uncouch/couchdbfile"
+)
+
+// smallFixture has header at 4096 with update sequence 8 before "a", "b"
+// and "d" were updated and "e" was created
+const smallFixture = "../testdata/small.couch"
+
+// openShards opens the fixture once for every header offset, -1 for the
+// newest header
+func openShards(t *testing.T, offsets ...int64) []*couchdbfile.CouchDbFile {
+	data, err := ioutil.ReadFile(smallFixture)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var files []*couchdbfile.CouchDbFile
+	for _, offset := range offsets {
+		cf, err := couchdbfile.New(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if offset >= 0 {
+			err = cf.UseHeader(offset)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		files = append(files, cf)
+	}
+	return files
+}
+
+// dedupe returns documents as "source id rev conflicts" joined with comma
+func dedupe(t *testing.T, files []*couchdbfile.CouchDbFile, opts couchdbfile.DocumentsOptions) string {
+	it := Dedupe(context.Background(), files, opts)
+	var docs []string
+	for it.Next() {
+		doc := it.Doc()
+		docs = append(docs, fmt.Sprint(it.Source(), " ", doc.Id, " ", doc.Rev[:5], " ", len(doc.Conflicts)))
+	}
+	if it.Err() != nil {
+		t.Fatal(it.Err())
+	}
+	return strings.Join(docs, ",")
+}
+
+func TestDedupe(t *testing.T) {
+	// Stale copy comes first, documents updated later win from the second
+	// file, unchanged ones stay with the first copy. Deleted "b" wins as
+	// its live parent is superseded, "d" keeps both of its leaves.
+	want := "1 a 2-693 0,1 b 2-fbf 0,0 c 1-a9f 0,1 d 2-d2a 1,0 deal:000 1-1ef 0,0 deal:001 1-73a 0,1 e 1-cd3 0,0 user:000 1-3e3 0,0 user:001 1-e47 0"
+	got := dedupe(t, openShards(t, 4096, -1), couchdbfile.DocumentsOptions{Conflicts: true})
+	if got != want {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+	// Order of the files does not change winners
+	want = "0 a 2-693 0,0 b 2-fbf 0,0 c 1-a9f 0,0 d 2-d2a 1,0 deal:000 1-1ef 0,0 deal:001 1-73a 0,0 e 1-cd3 0,0 user:000 1-3e3 0,0 user:001 1-e47 0"
+	got = dedupe(t, openShards(t, -1, 4096), couchdbfile.DocumentsOptions{Conflicts: true})
+	if got != want {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+
+	// All leaves come from the winning copy only, key range limits files
+	got = dedupe(t, openShards(t, 4096, -1, 4096), couchdbfile.DocumentsOptions{AllLeaves: true, StartKey: "d", EndKey: "d"})
+	if want = "1 d 2-d2a 0,1 d 2-3ed 0"; got != want {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+	// Empty shard merges with the rest
+	got = dedupe(t, openShards(t, 0, -1), couchdbfile.DocumentsOptions{Prefix: "user:"})
+	if want = "1 user:000 1-3e3 0,1 user:001 1-e47 0"; got != want {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+}
+
+func TestWinningSource(t *testing.T) {
+	rev := func(pos int64, id string, parent int, leaf bool, deleted int8) couchdbfile.Revision {
+		return couchdbfile.Revision{Pos: pos, RevID: []byte(id), Parent: parent, Leaf: leaf, Deleted: deleted}
+	}
+	live := &couchdbfile.DocumentInfo{Revisions: []couchdbfile.Revision{rev(1, "a", -1, true, 0)}}
+	// Deleted 2-b is the only leaf of the copy where 1-a was updated
+	deleted := &couchdbfile.DocumentInfo{Revisions: []couchdbfile.Revision{rev(1, "a", -1, false, 0), rev(2, "b", 0, true, 1)}}
+	// Live 1-c conflicts with 1-a and 1-c wins the tie by ID
+	conflict := &couchdbfile.DocumentInfo{Revisions: []couchdbfile.Revision{rev(1, "c", -1, true, 0)}}
+	tests := []struct {
+		copies []*couchdbfile.DocumentInfo
+		want   int
+	}{
+		{[]*couchdbfile.DocumentInfo{nil, live}, 1},
+		{[]*couchdbfile.DocumentInfo{live, deleted}, 1},
+		{[]*couchdbfile.DocumentInfo{deleted, live}, 0},
+		{[]*couchdbfile.DocumentInfo{live, conflict, nil}, 1},
+		{[]*couchdbfile.DocumentInfo{live, live}, 0},
+		{[]*couchdbfile.DocumentInfo{nil, nil}, -1},
+	}
+	for i, test := range tests {
+		if got := WinningSource(test.copies); got != test.want {
+			t.Errorf("Test %d: expected %d, got %d", i, test.want, got)
+		}
+	}
+}
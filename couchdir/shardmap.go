@@ -0,0 +1,121 @@
+package couchdir
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pipedrive/uncouch/couchdbfile"
+)
+
+// ShardMap is placement of the database shards on the cluster nodes as
+// stored in the node local _dbs database
+type ShardMap struct {
+	DbName  string
+	Suffix  string
+	ByNode  map[string][]string
+	ByRange map[string][]string
+}
+
+// Ranges returns shard ranges of the database in order
+func (m *ShardMap) Ranges() []string {
+	ranges := make([]string, 0, len(m.ByRange))
+	for r := range m.ByRange {
+		ranges = append(ranges, r)
+	}
+	sort.Strings(ranges)
+	return ranges
+}
+
+// ShardPath returns path of the shard file relative to data directory
+func (m *ShardMap) ShardPath(r string) string {
+	return filepath.Join(shardsDir, r, filepath.FromSlash(m.DbName)+m.Suffix+couchExtension)
+}
+
+// ReadShardMaps reads shard maps of all databases from _dbs file.
+// Deleted databases and design documents are skipped.
+func ReadShardMaps(ctx context.Context, cf *couchdbfile.CouchDbFile) ([]ShardMap, error) {
+	var maps []ShardMap
+	it := cf.Documents(ctx, couchdbfile.DocumentsOptions{Tree: couchdbfile.IDTree})
+	for it.Next() {
+		doc := it.Doc()
+		if doc.Deleted != 0 || strings.HasPrefix(doc.Id, "_design/") {
+			continue
+		}
+		m, err := readShardMap(doc)
+		if err != nil {
+			slog.Error(err)
+			return nil, err
+		}
+		maps = append(maps, *m)
+	}
+	if err := it.Err(); err != nil {
+		slog.Error(err)
+		return nil, err
+	}
+	return maps, nil
+}
+
+// readShardMap reads shard map out of _dbs document
+func readShardMap(doc *couchdbfile.CouchDbDocument) (*ShardMap, error) {
+	var newMap ShardMap
+	m := &newMap
+	m.DbName = doc.Id
+	// Suffix is Erlang string, list of character codes
+	if suffix, ok := doc.Value["shard_suffix"].([]interface{}); ok {
+		var b strings.Builder
+		for _, c := range suffix {
+			code, ok := c.(float64)
+			if !ok {
+				err := fmt.Errorf("Invalid shard_suffix of database \"%s\"", doc.Id)
+				slog.Error(err)
+				return nil, err
+			}
+			b.WriteRune(rune(code))
+		}
+		m.Suffix = b.String()
+	}
+	var err error
+	m.ByNode, err = readPlacement(doc, "by_node")
+	if err != nil {
+		slog.Error(err)
+		return nil, err
+	}
+	m.ByRange, err = readPlacement(doc, "by_range")
+	if err != nil {
+		slog.Error(err)
+		return nil, err
+	}
+	return m, nil
+}
+
+// readPlacement reads object mapping names to lists of names
+func readPlacement(doc *couchdbfile.CouchDbDocument, field string) (map[string][]string, error) {
+	obj, ok := doc.Value[field].(map[string]interface{})
+	if !ok {
+		err := fmt.Errorf("Database \"%s\" has no %s shard map", doc.Id, field)
+		slog.Error(err)
+		return nil, err
+	}
+	placement := make(map[string][]string, len(obj))
+	for k, v := range obj {
+		list, ok := v.([]interface{})
+		if !ok {
+			err := fmt.Errorf("Invalid %s entry \"%s\" of database \"%s\"", field, k, doc.Id)
+			slog.Error(err)
+			return nil, err
+		}
+		for _, name := range list {
+			s, ok := name.(string)
+			if !ok {
+				err := fmt.Errorf("Invalid %s entry \"%s\" of database \"%s\"", field, k, doc.Id)
+				slog.Error(err)
+				return nil, err
+			}
+			placement[k] = append(placement[k], s)
+		}
+	}
+	return placement, nil
+}
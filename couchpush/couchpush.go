@@ -0,0 +1,254 @@
+// Package couchpush writes documents read from .couch files into live
+// CouchDB through _bulk_docs with new_edits=false, so revisions and their
+// histories are stored as they are instead of creating new ones.
+package couchpush
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pipedrive/uncouch/couchdbfile"
+)
+
+const (
+	// maxBackoff caps the delay between retries
+	maxBackoff = 30 * time.Second
+	// defaultTimeout limits single request including reading the response,
+	// so stalled connection is retried instead of hanging the push
+	defaultTimeout = 5 * time.Minute
+)
+
+// Client talks to single database of CouchDB. Credentials are taken from
+// the database URL.
+type Client struct {
+	// URL of the database without trailing slash
+	URL        string
+	HTTPClient *http.Client
+	// Retries is number of times failed request is repeated
+	Retries int
+	// Backoff is delay before the first retry, doubled after each one
+	Backoff time.Duration
+}
+
+// DocError is failure of single document reported by _bulk_docs
+type DocError struct {
+	ID     string `json:"id"`
+	Rev    string `json:"rev"`
+	Error  string `json:"error"`
+	Reason string `json:"reason"`
+}
+
+// StatusError is unexpected HTTP response status
+type StatusError struct {
+	Method     string
+	URL        string
+	StatusCode int
+	Body       string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%s %s returned %d: %s", e.Method, e.URL, e.StatusCode, e.Body)
+}
+
+// NewClient returns client of the database at URL
+func NewClient(databaseURL string) (*Client, error) {
+	u, err := url.Parse(databaseURL)
+	if err != nil {
+		slog.Error(err)
+		return nil, err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || strings.Trim(u.Path, "/") == "" {
+		err := fmt.Errorf("Invalid database URL \"%s\", expecting http(s)://host:port/db", Redact(databaseURL))
+		slog.Error(err)
+		return nil, err
+	}
+	var newClient Client
+	c := &newClient
+	c.URL = strings.TrimRight(databaseURL, "/")
+	c.HTTPClient = &http.Client{Timeout: defaultTimeout}
+	c.Retries = 5
+	c.Backoff = time.Second
+	return c, nil
+}
+
+// Redact removes password from URL so it can be logged
+func Redact(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.User == nil {
+		return rawURL
+	}
+	u.User = url.User(u.User.Username())
+	return u.String()
+}
+
+// EnsureDatabase checks the database exists and creates it if requested
+func (c *Client) EnsureDatabase(ctx context.Context, create bool) error {
+	status, body, err := c.do(ctx, http.MethodGet, c.URL, nil)
+	if err != nil {
+		slog.Error(err)
+		return err
+	}
+	if status == http.StatusOK {
+		return nil
+	}
+	if status != http.StatusNotFound || !create {
+		err := &StatusError{http.MethodGet, Redact(c.URL), status, string(body)}
+		slog.Error(err)
+		return err
+	}
+	status, body, err = c.do(ctx, http.MethodPut, c.URL, nil)
+	if err != nil {
+		slog.Error(err)
+		return err
+	}
+	// Precondition failed means database was created in the meantime
+	if status != http.StatusCreated && status != http.StatusAccepted && status != http.StatusPreconditionFailed {
+		err := &StatusError{http.MethodPut, Redact(c.URL), status, string(body)}
+		slog.Error(err)
+		return err
+	}
+	slog.Infof("Created database %s", Redact(c.URL))
+	return nil
+}
+
+// BulkDocs stores documents with their revisions as they are. It returns
+// documents CouchDB refused, the request as whole failing is an error.
+func (c *Client) BulkDocs(ctx context.Context, docs []map[string]interface{}) ([]DocError, error) {
+	payload, err := json.Marshal(struct {
+		Docs     []map[string]interface{} `json:"docs"`
+		NewEdits bool                     `json:"new_edits"`
+	}{docs, false})
+	if err != nil {
+		slog.Error(err)
+		return nil, err
+	}
+	status, body, err := c.do(ctx, http.MethodPost, c.URL+"/_bulk_docs", payload)
+	if err != nil {
+		slog.Error(err)
+		return nil, err
+	}
+	if status != http.StatusCreated && status != http.StatusAccepted {
+		err := &StatusError{http.MethodPost, Redact(c.URL) + "/_bulk_docs", status, string(body)}
+		slog.Error(err)
+		return nil, err
+	}
+	var results []DocError
+	err = json.Unmarshal(body, &results)
+	if err != nil {
+		slog.Error(err)
+		return nil, err
+	}
+	// With new_edits=false successful documents are usually left out
+	failed := results[:0]
+	for _, r := range results {
+		if r.Error != "" {
+			failed = append(failed, r)
+		}
+	}
+	return failed, nil
+}
+
+// do sends request retrying network errors and responses telling to try
+// again later. Status and body of the last response are returned.
+func (c *Client) do(ctx context.Context, method, target string, payload []byte) (int, []byte, error) {
+	backoff := c.Backoff
+	for attempt := 0; ; attempt++ {
+		status, body, err := c.send(ctx, method, target, payload)
+		retry := err != nil || status == http.StatusTooManyRequests || status >= http.StatusInternalServerError
+		if !retry || attempt >= c.Retries || ctx.Err() != nil {
+			if err != nil {
+				slog.Error(err)
+			}
+			return status, body, err
+		}
+		if err != nil {
+			slog.Warnf("%s %s failed, retrying in %v: %v", method, Redact(target), backoff, err)
+		} else {
+			slog.Warnf("%s %s returned %d, retrying in %v", method, Redact(target), status, backoff)
+		}
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return 0, nil, ctx.Err()
+		}
+		backoff *= 2
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+// send sends single request and reads the whole response
+func (c *Client) send(ctx context.Context, method, target string, payload []byte) (int, []byte, error) {
+	req, err := http.NewRequest(method, target, bytes.NewReader(payload))
+	if err != nil {
+		slog.Error(err)
+		return 0, nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Accept", "application/json")
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return 0, nil, err
+	}
+	return resp.StatusCode, body, nil
+}
+
+// BulkDoc builds _bulk_docs entry of the document revision with its
+// revision history and attachments inlined as base64 data
+func BulkDoc(cf *couchdbfile.CouchDbFile, doc *couchdbfile.CouchDbDocument) (map[string]interface{}, error) {
+	bulkDoc := make(map[string]interface{}, len(doc.Value)+4)
+	for k, v := range doc.Value {
+		bulkDoc[k] = v
+	}
+	bulkDoc["_id"] = doc.Id
+	bulkDoc["_rev"] = doc.Rev
+	if len(doc.Revisions.IDs) > 0 {
+		bulkDoc["_revisions"] = doc.Revisions
+	}
+	if doc.Deleted != 0 {
+		bulkDoc["_deleted"] = true
+	}
+	if len(doc.Attachments) == 0 {
+		return bulkDoc, nil
+	}
+	attachments := make(map[string]interface{}, len(doc.Attachments))
+	for i := range doc.Attachments {
+		a := &doc.Attachments[i]
+		var data bytes.Buffer
+		err := cf.WriteAttachment(a, &data, true)
+		if err != nil {
+			slog.Error(err)
+			return nil, err
+		}
+		attachment := map[string]interface{}{
+			"content_type": a.ContentType,
+			"revpos":       a.RevPos,
+			"data":         base64.StdEncoding.EncodeToString(data.Bytes()),
+		}
+		// Digest is of the stored bytes and CouchDB checks it against the
+		// decoded data it receives, so it only fits data stored as it is
+		if len(a.Digest) > 0 && a.Encoding == "identity" {
+			attachment["digest"] = "md5-" + base64.StdEncoding.EncodeToString(a.Digest)
+		}
+		attachments[a.Name] = attachment
+	}
+	bulkDoc["_attachments"] = attachments
+	return bulkDoc, nil
+}
@@ -0,0 +1,186 @@
+package couchpush
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pipedrive/uncouch/couchdbfile"
+)
+
+// scriptedServer answers _bulk_docs requests with given statuses in turn,
+// the last one repeats
+type scriptedServer struct {
+	mu       sync.Mutex
+	statuses []int
+	delays   []time.Duration
+	times    []time.Time
+}
+
+func (s *scriptedServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	n := len(s.times)
+	s.times = append(s.times, time.Now())
+	status := s.statuses[len(s.statuses)-1]
+	if n < len(s.statuses) {
+		status = s.statuses[n]
+	}
+	var delay time.Duration
+	if n < len(s.delays) {
+		delay = s.delays[n]
+	}
+	s.mu.Unlock()
+	time.Sleep(delay)
+	w.WriteHeader(status)
+	if status == http.StatusCreated {
+		json.NewEncoder(w).Encode([]DocError{{ID: "a", Rev: "1-a"}, {ID: "b", Rev: "1-b", Error: "forbidden", Reason: "no"}})
+		return
+	}
+	w.Write([]byte(`{"error":"unavailable"}`))
+}
+
+// requests returns number of requests received
+func (s *scriptedServer) requests() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.times)
+}
+
+func newTestClient(t *testing.T, s *scriptedServer) (*Client, func()) {
+	srv := httptest.NewServer(s)
+	c, err := NewClient(srv.URL + "/db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Backoff = 10 * time.Millisecond
+	return c, srv.Close
+}
+
+func TestBulkDocsRetries(t *testing.T) {
+	s := &scriptedServer{statuses: []int{http.StatusInternalServerError, http.StatusTooManyRequests, http.StatusCreated}}
+	c, stop := newTestClient(t, s)
+	defer stop()
+	failed, err := c.BulkDocs(context.Background(), []map[string]interface{}{{"_id": "a"}, {"_id": "b"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.requests() != 3 {
+		t.Errorf("Expected 3 requests, got %d", s.requests())
+	}
+	// Backoff doubles after each retry
+	if gap := s.times[1].Sub(s.times[0]); gap < c.Backoff {
+		t.Errorf("First retry after %v, expected at least %v", gap, c.Backoff)
+	}
+	if gap := s.times[2].Sub(s.times[1]); gap < 2*c.Backoff {
+		t.Errorf("Second retry after %v, expected at least %v", gap, 2*c.Backoff)
+	}
+	if len(failed) != 1 || failed[0].ID != "b" || failed[0].Error != "forbidden" {
+		t.Errorf("Expected only document b to fail, got %+v", failed)
+	}
+}
+
+func TestBulkDocsGivesUp(t *testing.T) {
+	s := &scriptedServer{statuses: []int{http.StatusServiceUnavailable}}
+	c, stop := newTestClient(t, s)
+	defer stop()
+	c.Retries = 2
+	_, err := c.BulkDocs(context.Background(), []map[string]interface{}{{"_id": "a"}})
+	statusErr, ok := err.(*StatusError)
+	if !ok || statusErr.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503 status error, got %v", err)
+	}
+	if s.requests() != 3 {
+		t.Errorf("Expected 3 requests, got %d", s.requests())
+	}
+}
+
+func TestBulkDocsClientErrorNotRetried(t *testing.T) {
+	s := &scriptedServer{statuses: []int{http.StatusBadRequest}}
+	c, stop := newTestClient(t, s)
+	defer stop()
+	_, err := c.BulkDocs(context.Background(), []map[string]interface{}{{"_id": "a"}})
+	if err == nil {
+		t.Error("Expected error")
+	}
+	if s.requests() != 1 {
+		t.Errorf("Expected 1 request, got %d", s.requests())
+	}
+}
+
+func TestBulkDocsTimeoutRetried(t *testing.T) {
+	s := &scriptedServer{
+		statuses: []int{http.StatusCreated},
+		delays:   []time.Duration{300 * time.Millisecond},
+	}
+	c, stop := newTestClient(t, s)
+	defer stop()
+	if c.HTTPClient.Timeout == 0 {
+		t.Fatal("Client should have timeout")
+	}
+	c.HTTPClient.Timeout = 50 * time.Millisecond
+	_, err := c.BulkDocs(context.Background(), []map[string]interface{}{{"_id": "a"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.requests() != 2 {
+		t.Errorf("Expected 2 requests, got %d", s.requests())
+	}
+}
+
+func TestBulkDocAttachments(t *testing.T) {
+	// Document "e" of the shared fixture has identity and gzip stored
+	// attachments
+	f, err := os.Open("../testdata/small.couch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cf, err := couchdbfile.New(f, fi.Size())
+	if err != nil {
+		t.Fatal(err)
+	}
+	it := cf.Documents(context.Background(), couchdbfile.DocumentsOptions{Tree: couchdbfile.IDTree, StartKey: "e", EndKey: "e"})
+	defer it.Close()
+	if !it.Next() {
+		t.Fatalf("Document e not found: %v", it.Err())
+	}
+	bulkDoc, err := BulkDoc(cf, it.Doc())
+	if err != nil {
+		t.Fatal(err)
+	}
+	attachments := bulkDoc["_attachments"].(map[string]interface{})
+	for name, want := range map[string]string{
+		"hello.txt":     strings.Repeat("hello world ", 20),
+		"dir/data.json": strings.Repeat(`{"a":1}`, 30),
+	} {
+		a := attachments[name].(map[string]interface{})
+		data, _ := base64.StdEncoding.DecodeString(a["data"].(string))
+		if string(data) != want {
+			t.Errorf("%s: unexpected data %q", name, data)
+		}
+		// CouchDB checks digest against the decoded data it receives
+		digest, ok := a["digest"]
+		sum := md5.Sum(data)
+		if ok && digest != "md5-"+base64.StdEncoding.EncodeToString(sum[:]) {
+			t.Errorf("%s: digest %v does not match the data", name, digest)
+		}
+		if _, stub := a["stub"]; stub {
+			t.Errorf("%s: expected data instead of stub", name)
+		}
+	}
+	if _, ok := attachments["hello.txt"].(map[string]interface{})["digest"]; !ok {
+		t.Error("Expected digest of identity stored attachment")
+	}
+}
@@ -0,0 +1,359 @@
+package couchserve
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"github.com/pipedrive/uncouch/couchdbfile"
+	"github.com/pipedrive/uncouch/couchdir"
+)
+
+// rowsWriter streams rows of JSON list the way CouchDB does, one row per
+// line, so big responses are not held in memory
+type rowsWriter struct {
+	w     io.Writer
+	count int
+	err   error
+}
+
+// row writes single row with separator from the previous one
+func (rw *rowsWriter) row(v interface{}) {
+	if rw.err != nil {
+		return
+	}
+	body, err := json.Marshal(v)
+	if err != nil {
+		slog.Error(err)
+		rw.err = err
+		return
+	}
+	if rw.count > 0 {
+		_, rw.err = rw.w.Write([]byte(",\r\n"))
+	} else {
+		_, rw.err = rw.w.Write([]byte("\r\n"))
+	}
+	if rw.err == nil {
+		_, rw.err = rw.w.Write(body)
+	}
+	rw.count++
+}
+
+// write writes raw part of the response
+func (rw *rowsWriter) write(s string) {
+	if rw.err == nil {
+		_, rw.err = io.WriteString(rw.w, s)
+	}
+}
+
+// allDocsRequest is body of POST _all_docs request
+type allDocsRequest struct {
+	Keys []string `json:"keys"`
+}
+
+// serveAllDocs serves /{db}/_all_docs. Rows come from ID trees of the shard
+// files merged in ID order, deleted documents are left out.
+func (s *Server) serveAllDocs(w http.ResponseWriter, r *http.Request, d *database) {
+	q := r.URL.Query()
+	opts, err := readDocumentOptions(r)
+	var (
+		includeDocs, descending  bool
+		inclusiveEnd             = true
+		limit, skip              int64
+		keys                     []string
+		startKey, endKey, key    string
+		hasStart, hasEnd, hasKey bool
+	)
+	if err == nil {
+		includeDocs, err = queryBool(q, "include_docs")
+	}
+	if err == nil {
+		descending, err = queryBool(q, "descending")
+	}
+	if err == nil && q.Get("inclusive_end") != "" {
+		inclusiveEnd, err = queryBool(q, "inclusive_end")
+	}
+	// Negative limit stands for no limit
+	if err == nil {
+		limit, err = queryInt(q, "limit", -1)
+	}
+	if err == nil {
+		skip, err = queryInt(q, "skip", 0)
+	}
+	if err == nil {
+		startKey, hasStart, err = queryKey(q, "startkey", "start_key")
+	}
+	if err == nil {
+		endKey, hasEnd, err = queryKey(q, "endkey", "end_key")
+	}
+	if err == nil {
+		key, hasKey, err = queryKey(q, "key")
+	}
+	if err == nil && q.Get("keys") != "" {
+		err = json.Unmarshal([]byte(q.Get("keys")), &keys)
+	}
+	if err == nil && r.Method == http.MethodPost {
+		var req allDocsRequest
+		err = json.NewDecoder(r.Body).Decode(&req)
+		keys = req.Keys
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "bad_request", err.Error())
+		return
+	}
+	if descending {
+		writeError(w, http.StatusBadRequest, "bad_request", "Parameter descending is not supported")
+		return
+	}
+	if hasKey {
+		startKey, endKey, hasStart, hasEnd = key, key, true, true
+	}
+
+	var totalRows int64
+	for _, cf := range d.files {
+		count, _, err := cf.DocCount()
+		if err != nil {
+			writeInternalError(w, err)
+			return
+		}
+		totalRows += count
+	}
+
+	// rowFor returns row of the document or nil when it should be left out
+	rowFor := func(c copies, id string) (map[string]interface{}, error) {
+		source, rev := d.winner(c)
+		if rev.Deleted != 0 {
+			return nil, nil
+		}
+		row := map[string]interface{}{
+			"id":    id,
+			"key":   id,
+			"value": map[string]string{"rev": rev.String()},
+		}
+		if includeDocs {
+			doc, err := d.documentJSON(c, source, rev, opts)
+			if err != nil {
+				slog.Error(err)
+				return nil, err
+			}
+			row["doc"] = doc
+		}
+		return row, nil
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	rw := &rowsWriter{w: w}
+	rw.write(`{"total_rows":` + jsonString(totalRows) + `,"offset":` + jsonString(skip) + `,"rows":[`)
+	var written int64
+	emit := func(row map[string]interface{}) bool {
+		if skip > 0 {
+			skip--
+			return true
+		}
+		if limit >= 0 && written >= limit {
+			return false
+		}
+		rw.row(row)
+		written++
+		return rw.err == nil && (limit < 0 || written < limit)
+	}
+
+	if keys != nil {
+		for _, id := range keys {
+			c, err := d.findDocument(id)
+			if err != nil {
+				rw.err = err
+				break
+			}
+			var row map[string]interface{}
+			if c == nil {
+				row = map[string]interface{}{"key": id, "error": "not_found"}
+			} else if row, err = rowFor(c, id); err != nil {
+				rw.err = err
+				break
+			} else if row == nil {
+				// Deleted documents are reported when asked for by key
+				_, rev := d.winner(c)
+				row = map[string]interface{}{
+					"id":    id,
+					"key":   id,
+					"value": map[string]interface{}{"rev": rev.String(), "deleted": true},
+				}
+				if includeDocs {
+					row["doc"] = nil
+				}
+			}
+			if !emit(row) {
+				break
+			}
+		}
+	} else {
+		var docOpts couchdbfile.DocumentsOptions
+		if hasStart {
+			docOpts.StartKey = startKey
+		}
+		if hasEnd {
+			docOpts.EndKey = endKey
+		}
+		it := couchdir.MergeInfos(r.Context(), d.files, docOpts)
+		for it.Next() {
+			di := it.Info()
+			id := string(di.ID)
+			if hasEnd && !inclusiveEnd && id == endKey {
+				break
+			}
+			c := make(copies, len(d.files))
+			c[it.Source()] = di
+			row, err := rowFor(c, id)
+			if err != nil {
+				rw.err = err
+				break
+			}
+			if row != nil && !emit(row) {
+				break
+			}
+		}
+		if rw.err == nil {
+			rw.err = it.Err()
+		}
+	}
+	if rw.err != nil {
+		// Headers are sent already, client sees truncated body
+		slog.Error(rw.err)
+		return
+	}
+	rw.write("\r\n]}\n")
+}
+
+// serveChanges serves /{db}/_changes. Shards are read one after another,
+// each from its own update sequence taken from since.
+func (s *Server) serveChanges(w http.ResponseWriter, r *http.Request, d *database) {
+	q := r.URL.Query()
+	opts, err := readDocumentOptions(r)
+	var (
+		includeDocs, descending bool
+		limit                   int64
+		seqs                    []int64
+	)
+	if err == nil {
+		includeDocs, err = queryBool(q, "include_docs")
+	}
+	if err == nil {
+		descending, err = queryBool(q, "descending")
+	}
+	// Negative limit stands for no limit
+	if err == nil {
+		limit, err = queryInt(q, "limit", -1)
+	}
+	if err == nil {
+		seqs, err = d.decodeSeq(q.Get("since"))
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "bad_request", err.Error())
+		return
+	}
+	if descending || q.Get("filter") != "" {
+		writeError(w, http.StatusBadRequest, "bad_request", "Parameters descending and filter are not supported")
+		return
+	}
+	feed := q.Get("feed")
+	// Files never change, so longpoll has nothing to wait for
+	if feed != "" && feed != "normal" && feed != "longpoll" && feed != "continuous" {
+		writeError(w, http.StatusBadRequest, "bad_request", "Unknown feed "+feed)
+		return
+	}
+	style := q.Get("style")
+	if style != "" && style != "main_only" && style != "all_docs" {
+		writeError(w, http.StatusBadRequest, "bad_request", "Unknown style "+style)
+		return
+	}
+	continuous := feed == "continuous"
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	rw := &rowsWriter{w: w}
+	if !continuous {
+		rw.write(`{"results":[`)
+	}
+	var count int64
+	for i, cf := range d.files {
+		if limit >= 0 && count >= limit {
+			break
+		}
+		// Changes takes 0 as no limit
+		remaining := int64(0)
+		if limit >= 0 {
+			remaining = limit - count
+		}
+		it := cf.Changes(r.Context(), seqs[i], remaining)
+		for it.Next() {
+			change := it.Change()
+			seqs[i] = change.Seq
+			row := map[string]interface{}{
+				"seq":     d.encodeSeq(seqs),
+				"id":      change.ID,
+				"changes": change.Changes,
+			}
+			if change.Deleted {
+				row["deleted"] = true
+			}
+			if style == "all_docs" {
+				var revs []couchdbfile.ChangeRevision
+				for _, leaf := range it.Info().Leaves() {
+					revs = append(revs, couchdbfile.ChangeRevision{Rev: leaf.String()})
+				}
+				row["changes"] = revs
+			}
+			if includeDocs {
+				c := make(copies, len(d.files))
+				c[i] = it.Info()
+				doc, err := d.documentJSON(c, i, c[i].WinningRevision(), opts)
+				if err != nil {
+					rw.err = err
+					break
+				}
+				row["doc"] = doc
+			}
+			if continuous {
+				body, err := json.Marshal(row)
+				if err == nil {
+					rw.write(string(body) + "\n")
+				} else {
+					rw.err = err
+				}
+			} else {
+				rw.row(row)
+			}
+			count++
+			if rw.err != nil {
+				break
+			}
+		}
+		if rw.err == nil {
+			rw.err = it.Err()
+		}
+		if rw.err != nil {
+			// Headers are sent already, client sees truncated body
+			slog.Error(rw.err)
+			return
+		}
+		if limit < 0 || count < limit {
+			// The whole shard was read, later updates may have been of
+			// documents moved on in the seq tree
+			seqs[i] = cf.Header.UpdateSeq
+		}
+	}
+	lastSeq := jsonString(d.encodeSeq(seqs))
+	if continuous {
+		rw.write(`{"last_seq":` + lastSeq + `,"pending":0}` + "\n")
+		return
+	}
+	rw.write("\r\n],\n" + `"last_seq":` + lastSeq + `,"pending":0}` + "\n")
+}
+
+// jsonString returns JSON encoding of the value
+func jsonString(v interface{}) string {
+	body, _ := json.Marshal(v)
+	return string(body)
+}
@@ -0,0 +1,376 @@
+package couchserve
+
+import (
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"strings"
+
+	"github.com/pipedrive/uncouch/couchdbfile"
+	"github.com/pipedrive/uncouch/couchdir"
+)
+
+// copies holds DocumentInfo of the document found in each shard file of
+// the database, nil where the file does not have it
+type copies []*couchdbfile.DocumentInfo
+
+// findDocument looks the document up in all shard files. It returns nil
+// when no file has the document.
+func (d *database) findDocument(id string) (copies, error) {
+	found := make(copies, len(d.files))
+	ok := false
+	for i, cf := range d.files {
+		di, err := cf.FindDocumentInfo(id)
+		if err == couchdbfile.ErrNotFound {
+			continue
+		}
+		if err != nil {
+			slog.Error(err)
+			return nil, err
+		}
+		found[i] = di
+		ok = true
+	}
+	if !ok {
+		return nil, nil
+	}
+	return found, nil
+}
+
+// winner returns winning revision of the document and file holding it
+func (d *database) winner(c copies) (int, *couchdbfile.Revision) {
+	source := couchdir.WinningSource(c)
+	return source, c[source].WinningRevision()
+}
+
+// findRevision returns file holding body of the revision. It returns nil
+// revision when no file has the body stored.
+func (d *database) findRevision(c copies, rev string) (int, *couchdbfile.Revision) {
+	for i, di := range c {
+		if di == nil {
+			continue
+		}
+		r := di.FindRevision(rev)
+		if r != nil && r.Offset >= 0 {
+			return i, r
+		}
+	}
+	return -1, nil
+}
+
+// leaves returns leaf revisions of all copies, leaf superseded in another
+// copy is left out
+func (d *database) leaves(c copies) []string {
+	inner := make(map[string]bool)
+	for _, di := range c {
+		if di == nil {
+			continue
+		}
+		for i := range di.Revisions {
+			if !di.Revisions[i].Leaf {
+				inner[di.Revisions[i].String()] = true
+			}
+		}
+	}
+	var leaves []string
+	seen := make(map[string]bool)
+	for _, di := range c {
+		if di == nil {
+			continue
+		}
+		for _, r := range di.Leaves() {
+			rev := r.String()
+			if !inner[rev] && !seen[rev] {
+				seen[rev] = true
+				leaves = append(leaves, rev)
+			}
+		}
+	}
+	return leaves
+}
+
+// documentOptions are query parameters shaping document JSON
+type documentOptions struct {
+	couchdbfile.DocumentJSONOptions
+	conflicts bool
+}
+
+// readDocumentOptions reads revs, attachments and conflicts parameters
+func readDocumentOptions(r *http.Request) (documentOptions, error) {
+	var opts documentOptions
+	q := r.URL.Query()
+	var err error
+	if opts.Revisions, err = queryBool(q, "revs"); err != nil {
+		return opts, err
+	}
+	if opts.Attachments, err = queryBool(q, "attachments"); err != nil {
+		return opts, err
+	}
+	if opts.conflicts, err = queryBool(q, "conflicts"); err != nil {
+		return opts, err
+	}
+	return opts, nil
+}
+
+// documentJSON reads revision from the i-th file and returns its JSON.
+// Conflicts are added only to the winning revision.
+func (d *database) documentJSON(c copies, i int, r *couchdbfile.Revision, opts documentOptions) (map[string]interface{}, error) {
+	cf := d.files[i]
+	doc, err := cf.ReadRevision(c[i], r)
+	if err != nil {
+		slog.Error(err)
+		return nil, err
+	}
+	if opts.conflicts && r == c[i].WinningRevision() {
+		for _, conflict := range c[i].Conflicts() {
+			doc.Conflicts = append(doc.Conflicts, conflict.String())
+		}
+	}
+	return cf.DocumentJSON(doc, opts.DocumentJSONOptions)
+}
+
+// serveDocument serves GET /{db}/{docid}
+func (s *Server) serveDocument(w http.ResponseWriter, r *http.Request, d *database, id string) {
+	opts, err := readDocumentOptions(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "bad_request", err.Error())
+		return
+	}
+	c, err := d.findDocument(id)
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	q := r.URL.Query()
+	if openRevs, ok := q["open_revs"]; ok {
+		s.serveOpenRevs(w, r, d, c, openRevs[0], opts)
+		return
+	}
+	if c == nil {
+		writeError(w, http.StatusNotFound, "not_found", "missing")
+		return
+	}
+	var (
+		source int
+		rev    *couchdbfile.Revision
+	)
+	if q.Get("rev") != "" {
+		source, rev = d.findRevision(c, q.Get("rev"))
+		if rev == nil {
+			writeError(w, http.StatusNotFound, "not_found", "missing")
+			return
+		}
+	} else {
+		source, rev = d.winner(c)
+		if rev.Deleted != 0 {
+			writeError(w, http.StatusNotFound, "not_found", "deleted")
+			return
+		}
+	}
+	doc, err := d.documentJSON(c, source, rev, opts)
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	w.Header().Set("ETag", `"`+rev.String()+`"`)
+	writeJSON(w, http.StatusOK, doc)
+}
+
+// serveOpenRevs serves open_revs request, either all leaves or JSON list of
+// revisions. Response is multipart/mixed when client accepts it, the way
+// the replicator asks for it.
+func (s *Server) serveOpenRevs(w http.ResponseWriter, r *http.Request, d *database, c copies, openRevs string, opts documentOptions) {
+	var revs []string
+	if openRevs == "all" {
+		if c == nil {
+			writeError(w, http.StatusNotFound, "not_found", "missing")
+			return
+		}
+		revs = d.leaves(c)
+	} else if err := json.Unmarshal([]byte(openRevs), &revs); err != nil {
+		writeError(w, http.StatusBadRequest, "bad_request", "Parameter open_revs must be \"all\" or JSON list of revisions")
+		return
+	}
+	results := make([]map[string]interface{}, 0, len(revs))
+	for _, rev := range revs {
+		source, revision := -1, (*couchdbfile.Revision)(nil)
+		if c != nil {
+			source, revision = d.findRevision(c, rev)
+		}
+		if revision == nil {
+			results = append(results, map[string]interface{}{"missing": rev})
+			continue
+		}
+		doc, err := d.documentJSON(c, source, revision, opts)
+		if err != nil {
+			writeInternalError(w, err)
+			return
+		}
+		results = append(results, map[string]interface{}{"ok": doc})
+	}
+	if !strings.Contains(r.Header.Get("Accept"), "multipart/mixed") {
+		writeJSON(w, http.StatusOK, results)
+		return
+	}
+	mw := multipart.NewWriter(w)
+	w.Header().Set("Content-Type", `multipart/mixed; boundary="`+mw.Boundary()+`"`)
+	w.WriteHeader(http.StatusOK)
+	for _, result := range results {
+		body, ok := result["ok"]
+		if !ok {
+			body = result
+		}
+		part, err := mw.CreatePart(textproto.MIMEHeader{"Content-Type": {"application/json"}})
+		if err == nil {
+			err = json.NewEncoder(part).Encode(body)
+		}
+		if err != nil {
+			// Headers are sent already, client sees broken multipart body
+			slog.Error(err)
+			return
+		}
+	}
+	mw.Close()
+}
+
+// serveAttachment serves raw data of the attachment of the winning or
+// requested revision
+func (s *Server) serveAttachment(w http.ResponseWriter, r *http.Request, d *database, id, name string) {
+	c, err := d.findDocument(id)
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	if c == nil {
+		writeError(w, http.StatusNotFound, "not_found", "missing")
+		return
+	}
+	var (
+		source int
+		rev    *couchdbfile.Revision
+	)
+	if r.URL.Query().Get("rev") != "" {
+		source, rev = d.findRevision(c, r.URL.Query().Get("rev"))
+	} else {
+		source, rev = d.winner(c)
+		if rev.Deleted != 0 {
+			rev = nil
+		}
+	}
+	if rev == nil || rev.Offset < 0 {
+		writeError(w, http.StatusNotFound, "not_found", "missing")
+		return
+	}
+	cf := d.files[source]
+	attachments, err := cf.ReadAttachments(rev)
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	for i := range attachments {
+		a := &attachments[i]
+		if a.Name != name {
+			continue
+		}
+		w.Header().Set("Content-Type", a.ContentType)
+		w.Header().Set("ETag", `"`+rev.String()+`"`)
+		if r.Method == http.MethodHead {
+			return
+		}
+		err = cf.WriteAttachment(a, w, true)
+		if err != nil {
+			// Headers are sent already, client sees truncated body
+			slog.Error(err)
+		}
+		return
+	}
+	writeError(w, http.StatusNotFound, "not_found", "Document is missing attachment")
+}
+
+// serveLocal serves local document. Local documents are not indexed by
+// ID in the file, so the local tree of every shard is scanned.
+func (s *Server) serveLocal(w http.ResponseWriter, r *http.Request, d *database, id string) {
+	for _, cf := range d.files {
+		it := cf.LocalDocuments(r.Context())
+		for it.Next() {
+			local := it.Doc()
+			if local.Id != id {
+				continue
+			}
+			doc := make(map[string]interface{}, len(local.Value)+2)
+			for k, v := range local.Value {
+				doc[k] = v
+			}
+			doc["_id"] = local.Id
+			doc["_rev"] = local.Rev
+			writeJSON(w, http.StatusOK, doc)
+			return
+		}
+		if err := it.Err(); err != nil {
+			writeInternalError(w, err)
+			return
+		}
+	}
+	writeError(w, http.StatusNotFound, "not_found", "missing")
+}
+
+// bulkGetRequest is body of _bulk_get request
+type bulkGetRequest struct {
+	Docs []struct {
+		ID  string `json:"id"`
+		Rev string `json:"rev"`
+	} `json:"docs"`
+}
+
+// serveBulkGet serves POST /{db}/_bulk_get. Without rev the winning
+// revision is returned, deleted or not.
+func (s *Server) serveBulkGet(w http.ResponseWriter, r *http.Request, d *database) {
+	opts, err := readDocumentOptions(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "bad_request", err.Error())
+		return
+	}
+	var req bulkGetRequest
+	err = json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "bad_request", "Request body must be JSON object with docs list")
+		return
+	}
+	results := make([]map[string]interface{}, 0, len(req.Docs))
+	for _, entry := range req.Docs {
+		var doc map[string]interface{}
+		c, err := d.findDocument(entry.ID)
+		if err != nil {
+			writeInternalError(w, err)
+			return
+		}
+		if c != nil {
+			source, rev := d.winner(c)
+			if entry.Rev != "" {
+				source, rev = d.findRevision(c, entry.Rev)
+			}
+			if rev != nil && rev.Offset >= 0 {
+				doc, err = d.documentJSON(c, source, rev, opts)
+				if err != nil {
+					writeInternalError(w, err)
+					return
+				}
+			}
+		}
+		result := map[string]interface{}{"ok": doc}
+		if doc == nil {
+			result = map[string]interface{}{"error": map[string]string{
+				"id":     entry.ID,
+				"rev":    entry.Rev,
+				"error":  "not_found",
+				"reason": "missing",
+			}}
+		}
+		results = append(results, map[string]interface{}{
+			"id":   entry.ID,
+			"docs": []map[string]interface{}{result},
+		})
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"results": results})
+}
@@ -0,0 +1,361 @@
+// Package couchserve serves read only part of CouchDB HTTP API straight
+// from .couch files of CouchDB data directory, so tools and the replicator
+// can read cold backups without running CouchDB. Nothing is ever written.
+package couchserve
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/pipedrive/uncouch/couchdbfile"
+	"github.com/pipedrive/uncouch/couchdir"
+)
+
+// couchDBVersion is CouchDB version reported by the welcome response
+const couchDBVersion = "3.1.1"
+
+// Server is http.Handler serving databases found in data directory
+type Server struct {
+	dbs   map[string]*database
+	names []string
+}
+
+// New finds databases of the data directory and opens their shard files.
+// Databases created later are not served and updates written after that
+// are not seen until the server is restarted.
+func New(dataDir string) (*Server, error) {
+	dbs, err := couchdir.Find(dataDir)
+	if err != nil {
+		slog.Error(err)
+		return nil, err
+	}
+	var newServer Server
+	s := &newServer
+	s.dbs = make(map[string]*database, len(dbs))
+	for _, db := range dbs {
+		d, err := openDatabase(db)
+		if err != nil {
+			slog.Error(err)
+			s.Close()
+			return nil, err
+		}
+		s.dbs[db.Name] = d
+		s.names = append(s.names, db.Name)
+	}
+	return s, nil
+}
+
+// Close closes shard files of all databases
+func (s *Server) Close() {
+	for _, d := range s.dbs {
+		d.Close()
+	}
+}
+
+// database is served database with its shard files open. Files are only
+// read, so requests share them.
+type database struct {
+	name    string
+	shards  []couchdir.Shard
+	files   []*couchdbfile.CouchDbFile
+	handles []*os.File
+	size    int64
+}
+
+// openDatabase opens shard files of the database
+func openDatabase(db couchdir.Database) (*database, error) {
+	d := &database{name: db.Name, shards: db.Shards}
+	for _, shard := range db.Shards {
+		f, err := os.Open(shard.Path)
+		if err != nil {
+			slog.Error(err)
+			d.Close()
+			return nil, err
+		}
+		d.handles = append(d.handles, f)
+		fi, err := f.Stat()
+		if err != nil {
+			slog.Error(err)
+			d.Close()
+			return nil, err
+		}
+		cf, err := couchdbfile.New(f, fi.Size())
+		if err != nil {
+			slog.Error(err)
+			d.Close()
+			return nil, err
+		}
+		d.files = append(d.files, cf)
+		d.size += fi.Size()
+	}
+	return d, nil
+}
+
+// Close closes shard files
+func (d *database) Close() {
+	for _, f := range d.handles {
+		f.Close()
+	}
+}
+
+// sharded reports if database is made of shards. Sequences of sharded
+// databases are opaque strings like in CouchDB cluster.
+func (d *database) sharded() bool {
+	return len(d.shards) > 1 || d.shards[0].Range != ""
+}
+
+// updateSeqs returns current update sequence of every shard
+func (d *database) updateSeqs() []int64 {
+	seqs := make([]int64, len(d.files))
+	for i, cf := range d.files {
+		seqs[i] = cf.Header.UpdateSeq
+	}
+	return seqs
+}
+
+// encodeSeq encodes update sequences of the shards. Sharded databases get
+// sum of the sequences followed by base64 encoded list of them.
+func (d *database) encodeSeq(seqs []int64) interface{} {
+	if !d.sharded() {
+		return seqs[0]
+	}
+	var sum int64
+	for _, seq := range seqs {
+		sum += seq
+	}
+	list, _ := json.Marshal(seqs)
+	return strconv.FormatInt(sum, 10) + "-" + base64.RawURLEncoding.EncodeToString(list)
+}
+
+// decodeSeq decodes since parameter to update sequences of the shards
+func (d *database) decodeSeq(since string) ([]int64, error) {
+	seqs := make([]int64, len(d.files))
+	switch {
+	case since == "" || since == "0":
+		return seqs, nil
+	case since == "now":
+		return d.updateSeqs(), nil
+	case !d.sharded():
+		seq, err := strconv.ParseInt(since, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid since sequence \"%s\"", since)
+		}
+		seqs[0] = seq
+		return seqs, nil
+	}
+	dash := strings.Index(since, "-")
+	if dash < 0 {
+		return nil, fmt.Errorf("Invalid since sequence \"%s\"", since)
+	}
+	list, err := base64.RawURLEncoding.DecodeString(since[dash+1:])
+	if err == nil {
+		err = json.Unmarshal(list, &seqs)
+	}
+	if err != nil || len(seqs) != len(d.files) {
+		return nil, fmt.Errorf("Invalid since sequence \"%s\"", since)
+	}
+	return seqs, nil
+}
+
+// ServeHTTP implements http.Handler
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path, err := splitPath(r.URL.EscapedPath())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "bad_request", err.Error())
+		return
+	}
+	post := len(path) == 2 && (path[1] == "_all_docs" || path[1] == "_bulk_get" || path[1] == "_changes")
+	if r.Method != http.MethodGet && r.Method != http.MethodHead && !(r.Method == http.MethodPost && post) {
+		writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "Only reads are supported")
+		return
+	}
+	if len(path) == 0 {
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"couchdb": "Welcome",
+			"version": couchDBVersion,
+			"vendor":  map[string]string{"name": "uncouch"},
+		})
+		return
+	}
+	switch path[0] {
+	case "_all_dbs":
+		names := s.names
+		if names == nil {
+			names = []string{}
+		}
+		writeJSON(w, http.StatusOK, names)
+		return
+	case "_up":
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+		return
+	}
+	d, ok := s.dbs[path[0]]
+	if !ok {
+		writeError(w, http.StatusNotFound, "not_found", "Database does not exist.")
+		return
+	}
+
+	if len(path) == 1 {
+		s.serveDatabase(w, d)
+		return
+	}
+	switch path[1] {
+	case "_all_docs":
+		s.serveAllDocs(w, r, d)
+		return
+	case "_changes":
+		s.serveChanges(w, r, d)
+		return
+	case "_bulk_get":
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "Only POST is supported")
+			return
+		}
+		s.serveBulkGet(w, r, d)
+		return
+	case "_local":
+		if len(path) == 3 {
+			s.serveLocal(w, r, d, "_local/"+path[2])
+			return
+		}
+	case "_design":
+		if len(path) >= 3 {
+			s.serveDocumentOrAttachment(w, r, d, "_design/"+path[2], path[3:])
+			return
+		}
+	default:
+		if !strings.HasPrefix(path[1], "_") {
+			s.serveDocumentOrAttachment(w, r, d, path[1], path[2:])
+			return
+		}
+	}
+	writeError(w, http.StatusNotFound, "not_found", "missing")
+}
+
+// serveDatabase writes database information
+func (s *Server) serveDatabase(w http.ResponseWriter, d *database) {
+	var count, deleted int64
+	for _, cf := range d.files {
+		c, del, err := cf.DocCount()
+		if err != nil {
+			writeInternalError(w, err)
+			return
+		}
+		count += c
+		deleted += del
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"db_name":             d.name,
+		"update_seq":          d.encodeSeq(d.updateSeqs()),
+		"doc_count":           count,
+		"doc_del_count":       deleted,
+		"purge_seq":           0,
+		"compact_running":     false,
+		"disk_format_version": d.files[0].Header.DiskVersion,
+		"instance_start_time": "0",
+		"sizes":               map[string]int64{"file": d.size},
+		"props":               map[string]interface{}{},
+	})
+}
+
+// serveDocumentOrAttachment serves document or its attachment when the
+// path continues after document ID
+func (s *Server) serveDocumentOrAttachment(w http.ResponseWriter, r *http.Request, d *database, id string, rest []string) {
+	if len(rest) == 0 {
+		s.serveDocument(w, r, d, id)
+		return
+	}
+	s.serveAttachment(w, r, d, id, strings.Join(rest, "/"))
+}
+
+// splitPath splits escaped URL path to unescaped segments, so database
+// names and document IDs can contain escaped slashes
+func splitPath(escaped string) ([]string, error) {
+	var path []string
+	for _, segment := range strings.Split(strings.Trim(escaped, "/"), "/") {
+		if segment == "" {
+			continue
+		}
+		unescaped, err := url.PathUnescape(segment)
+		if err != nil {
+			return nil, err
+		}
+		path = append(path, unescaped)
+	}
+	return path, nil
+}
+
+// writeJSON writes JSON response
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		slog.Error(err)
+		status = http.StatusInternalServerError
+		body = []byte(`{"error":"internal_server_error","reason":"Failed to encode response"}`)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(append(body, '\n'))
+}
+
+// writeError writes error response the way CouchDB does
+func writeError(w http.ResponseWriter, status int, name, reason string) {
+	writeJSON(w, status, map[string]string{"error": name, "reason": reason})
+}
+
+// writeInternalError writes error reading the files
+func writeInternalError(w http.ResponseWriter, err error) {
+	slog.Error(err)
+	writeError(w, http.StatusInternalServerError, "internal_server_error", err.Error())
+}
+
+// queryBool reads boolean query parameter, missing one is false
+func queryBool(q url.Values, name string) (bool, error) {
+	value := q.Get(name)
+	if value == "" {
+		return false, nil
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("Invalid boolean parameter %s=%s", name, value)
+	}
+	return b, nil
+}
+
+// queryInt reads non negative integer query parameter, missing one is
+// given default value
+func queryInt(q url.Values, name string, missing int64) (int64, error) {
+	value := q.Get(name)
+	if value == "" {
+		return missing, nil
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("Invalid integer parameter %s=%s", name, value)
+	}
+	return n, nil
+}
+
+// queryKey reads JSON encoded string key parameter, either of the names
+// can be used. It returns false when parameter is missing.
+func queryKey(q url.Values, names ...string) (string, bool, error) {
+	for _, name := range names {
+		value, ok := q[name]
+		if !ok {
+			continue
+		}
+		var key string
+		err := json.Unmarshal([]byte(value[0]), &key)
+		if err != nil {
+			return "", false, fmt.Errorf("Parameter %s must be JSON string", name)
+		}
+		return key, true, nil
+	}
+	return "", false, nil
+}
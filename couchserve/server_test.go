@@ -0,0 +1,142 @@
+package couchserve
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newTestServer serves copy of the fixture database "small" with 9
+// documents, one of them deleted
+func newTestServer(t *testing.T) (*Server, string, func()) {
+	dir, err := ioutil.TempDir("", "serve")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile("../testdata/small.couch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	filename := filepath.Join(dir, "small.couch")
+	err = ioutil.WriteFile(filename, data, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := New(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, filename, func() {
+		s.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+// get serves GET request and decodes JSON response
+func get(t *testing.T, s *Server, target string, v interface{}) int {
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, target, nil))
+	err := json.Unmarshal(w.Body.Bytes(), v)
+	if err != nil {
+		t.Fatalf("%s: %v in %s", target, err, w.Body.String())
+	}
+	return w.Code
+}
+
+func TestLimit(t *testing.T) {
+	s, _, cleanup := newTestServer(t)
+	defer cleanup()
+	tests := []struct {
+		target string
+		rows   int
+	}{
+		{"/small/_all_docs", 8},
+		{"/small/_all_docs?limit=0", 0},
+		{"/small/_all_docs?limit=3", 3},
+		{"/small/_all_docs?limit=3&skip=6", 2},
+		{"/small/_all_docs?limit=0&skip=2", 0},
+		{`/small/_all_docs?keys=["a","b","x"]&limit=0`, 0},
+		{`/small/_all_docs?keys=["a","b","x"]`, 3},
+	}
+	for _, test := range tests {
+		var resp struct {
+			TotalRows int               `json:"total_rows"`
+			Rows      []json.RawMessage `json:"rows"`
+		}
+		status := get(t, s, test.target, &resp)
+		if status != http.StatusOK || len(resp.Rows) != test.rows || resp.TotalRows != 8 {
+			t.Errorf("%s: expected %d of 8 rows, got status %d and %d of %d rows", test.target, test.rows, status, len(resp.Rows), resp.TotalRows)
+		}
+	}
+
+	for _, test := range []struct {
+		target  string
+		results int
+		lastSeq float64
+	}{
+		{"/small/_changes", 9, 13},
+		{"/small/_changes?limit=0", 0, 0},
+		{"/small/_changes?limit=0&since=5", 0, 5},
+		{"/small/_changes?limit=4", 4, 7},
+	} {
+		var resp struct {
+			Results []json.RawMessage `json:"results"`
+			LastSeq float64           `json:"last_seq"`
+		}
+		status := get(t, s, test.target, &resp)
+		if status != http.StatusOK || len(resp.Results) != test.results || resp.LastSeq != test.lastSeq {
+			t.Errorf("%s: expected %d results up to %v, got status %d and %d results up to %v", test.target, test.results, test.lastSeq, status, len(resp.Results), resp.LastSeq)
+		}
+	}
+}
+
+func TestFilesOpenedOnce(t *testing.T) {
+	s, filename, cleanup := newTestServer(t)
+	defer cleanup()
+	// Open file keeps being read after it is removed from the directory
+	err := os.Remove(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc map[string]interface{}
+	status := get(t, s, "/small/a", &doc)
+	if status != http.StatusOK || doc["_id"] != "a" || doc["value"] != 1500.0 {
+		t.Errorf("Expected document a, got status %d and %v", status, doc)
+	}
+	var info map[string]interface{}
+	status = get(t, s, "/small", &info)
+	if status != http.StatusOK || info["doc_count"] != 8.0 {
+		t.Errorf("Expected database info, got status %d and %v", status, info)
+	}
+}
+
+func TestAttachments(t *testing.T) {
+	s, _, cleanup := newTestServer(t)
+	defer cleanup()
+	var stubs, inlined struct {
+		Attachments map[string]map[string]interface{} `json:"_attachments"`
+	}
+	get(t, s, "/small/e", &stubs)
+	get(t, s, "/small/e?attachments=true", &inlined)
+	for name, want := range map[string]string{
+		"hello.txt":     strings.Repeat("hello world ", 20),
+		"dir/data.json": strings.Repeat(`{"a":1}`, 30),
+	} {
+		a := inlined.Attachments[name]
+		data, _ := base64.StdEncoding.DecodeString(fmt.Sprint(a["data"]))
+		if string(data) != want {
+			t.Errorf("%s: unexpected data %q", name, data)
+		}
+		// Digest is the stored one, same as in the stub
+		if a["digest"] == nil || a["digest"] != stubs.Attachments[name]["digest"] {
+			t.Errorf("%s: expected digest %v, got %v", name, stubs.Attachments[name]["digest"], a["digest"])
+		}
+	}
+}
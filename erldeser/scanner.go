@@ -5,6 +5,7 @@ package erldeser
 import (
 	"encoding/binary"
 	"fmt"
+	"io"
 	"math"
 
 	"github.com/pipedrive/uncouch/erlterm"
@@ -23,6 +24,10 @@ const (
 	ListExt         erlterm.TermType = 'l'
 	BinaryExt       erlterm.TermType = 'm'
 	SmallBigExt	    erlterm.TermType = 'n'
+	// Atom encodings below are returned as AtomExt
+	SmallAtomExt     erlterm.TermType = 's'
+	AtomUtf8Ext      erlterm.TermType = 'v'
+	SmallAtomUtf8Ext erlterm.TermType = 'w'
 )
 
 // Scanner implements term scanner from provided io.Reader
@@ -57,8 +62,10 @@ func (s *Scanner) Scan(t *erlterm.Term) error {
 		s.readSmallInteger(t)
 	case IntegerExt:
 		s.readInteger(t)
-	case AtomExt:
+	case AtomExt, AtomUtf8Ext:
 		s.readAtom(t)
+	case SmallAtomExt, SmallAtomUtf8Ext:
+		s.readSmallAtom(t)
 	case SmallTupleExt:
 		s.readSmallTuple(t)
 	case NilExt:
@@ -79,6 +86,97 @@ func (s *Scanner) Scan(t *erlterm.Term) error {
 	return nil
 }
 
+// Peek returns type of the next term without scanning it. It returns
+// io.EOF when there is no more input.
+func (s *Scanner) Peek() (erlterm.TermType, error) {
+	if s.offset >= int64(len(s.input)) {
+		return 0, io.EOF
+	}
+	return erlterm.TermType(s.input[s.offset]), nil
+}
+
+// Skip advances past the next term and all terms nested in it without
+// copying their content. It returns io.ErrUnexpectedEOF when the term
+// does not fit in the input.
+func (s *Scanner) Skip() error {
+	err := s.advance(1)
+	if err != nil {
+		return err
+	}
+	termType := erlterm.TermType(s.input[s.offset-1])
+	switch termType {
+	case NewFloatExt:
+		return s.advance(8)
+	case SmallIntegerExt:
+		return s.advance(1)
+	case IntegerExt:
+		return s.advance(4)
+	case AtomExt, AtomUtf8Ext, StringExt:
+		err := s.advance(2)
+		if err != nil {
+			return err
+		}
+		return s.advance(int64(binary.BigEndian.Uint16(s.input[s.offset-2 : s.offset])))
+	case SmallAtomExt, SmallAtomUtf8Ext:
+		err := s.advance(1)
+		if err != nil {
+			return err
+		}
+		return s.advance(int64(s.input[s.offset-1]))
+	case SmallTupleExt:
+		err := s.advance(1)
+		if err != nil {
+			return err
+		}
+		arity := int64(s.input[s.offset-1])
+		for i := int64(0); i < arity; i++ {
+			if err := s.Skip(); err != nil {
+				return err
+			}
+		}
+	case NilExt:
+	case ListExt:
+		err := s.advance(4)
+		if err != nil {
+			return err
+		}
+		listLength := int64(binary.BigEndian.Uint32(s.input[s.offset-4 : s.offset]))
+		// Elements are followed by the list tail
+		for i := int64(0); i <= listLength; i++ {
+			if err := s.Skip(); err != nil {
+				return err
+			}
+		}
+	case BinaryExt:
+		err := s.advance(4)
+		if err != nil {
+			return err
+		}
+		return s.advance(int64(binary.BigEndian.Uint32(s.input[s.offset-4 : s.offset])))
+	case SmallBigExt:
+		err := s.advance(1)
+		if err != nil {
+			return err
+		}
+		return s.advance(1 + int64(s.input[s.offset-1]))
+	default:
+		err := fmt.Errorf("Unhandled term type %v", termType)
+		slog.Error(err)
+		return err
+	}
+	return nil
+}
+
+// advance moves offset n bytes forward if the input has them
+func (s *Scanner) advance(n int64) error {
+	if n > int64(len(s.input))-s.offset {
+		slog.Error(io.ErrUnexpectedEOF)
+		return io.ErrUnexpectedEOF
+	}
+	s.offset += n
+	return nil
+}
+
 // Rewind resets offset to be able to scan same buffer again
 func (s *Scanner) Rewind() {
 	s.offset = 0
@@ -127,6 +225,21 @@ func (s *Scanner) readAtom(t *erlterm.Term) {
 	return
 }
 
+// readSmallAtom is reading serialised Erlang atom with one byte length
+func (s *Scanner) readSmallAtom(t *erlterm.Term) {
+	t.Term = AtomExt
+	atomLength := int64(s.input[s.offset])
+	s.offset++
+	if atomLength > int64(cap(t.Binary)) {
+		t.Binary = make([]byte, atomLength)
+	} else {
+		t.Binary = t.Binary[:atomLength]
+	}
+	copy(t.Binary, s.input[s.offset:s.offset+atomLength])
+	s.offset += atomLength
+	return
+}
+
 // readSmallTuple is reading serialised Erlang small tuple
 func (s *Scanner) readSmallTuple(t *erlterm.Term) {
 	arity := int64(s.input[s.offset])
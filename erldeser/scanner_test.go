@@ -0,0 +1,114 @@
+package erldeser
+
+import (
+	"io"
+	"testing"
+
+	"github.com/pipedrive/uncouch/erlterm"
+)
+
+// sentinel is small integer placed after each term, scanning it tells
+// the term was skipped whole
+var sentinel = []byte{byte(SmallIntegerExt), 42}
+
+func TestPeekSkip(t *testing.T) {
+	tests := []struct {
+		name     string
+		term     []byte
+		termType erlterm.TermType
+	}{
+		{"float", []byte{'F', 0x3f, 0xf8, 0, 0, 0, 0, 0, 0}, NewFloatExt},
+		{"small integer", []byte{'a', 7}, SmallIntegerExt},
+		{"integer", []byte{'b', 0, 0, 1, 0}, IntegerExt},
+		{"atom", []byte{'d', 0, 4, 't', 'r', 'u', 'e'}, AtomExt},
+		{"utf8 atom", []byte{'v', 0, 4, 'n', 'u', 'l', 'l'}, AtomUtf8Ext},
+		{"small atom", []byte{'s', 5, 'f', 'a', 'l', 's', 'e'}, SmallAtomExt},
+		{"small utf8 atom", []byte{'w', 4, 't', 'r', 'u', 'e'}, SmallAtomUtf8Ext},
+		{"nil", []byte{'j'}, NilExt},
+		{"string", []byte{'k', 0, 3, 1, 2, 3}, StringExt},
+		{"binary", []byte{'m', 0, 0, 0, 3, 'a', 'b', 'c'}, BinaryExt},
+		{"small big", []byte{'n', 5, 0, 0, 0, 0, 0, 1}, SmallBigExt},
+		{"empty tuple", []byte{'h', 0}, SmallTupleExt},
+		// {[{<<"k">>, [1, <<"v">>]}]}
+		{"object", []byte{
+			'h', 1,
+			'l', 0, 0, 0, 1,
+			'h', 2, 'm', 0, 0, 0, 1, 'k',
+			'l', 0, 0, 0, 2, 'a', 1, 'm', 0, 0, 0, 1, 'v', 'j',
+			'j',
+		}, SmallTupleExt},
+		// [{}, "ab" | nil]
+		{"nested list", []byte{'l', 0, 0, 0, 2, 'h', 0, 'k', 0, 2, 'a', 'b', 'j'}, ListExt},
+	}
+	for _, test := range tests {
+		input := append(append([]byte{}, test.term...), sentinel...)
+		s, err := NewScanner(input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		termType, err := s.Peek()
+		if err != nil || termType != test.termType {
+			t.Errorf("%s: expected %c from Peek, got %c and error %v", test.name, test.termType, termType, err)
+		}
+		// Peek does not move the scanner
+		termType, _ = s.Peek()
+		if termType != test.termType {
+			t.Errorf("%s: second Peek returned %c", test.name, termType)
+		}
+		err = s.Skip()
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		var term erlterm.Term
+		err = s.Scan(&term)
+		if err != nil || term.Term != SmallIntegerExt || term.IntegerValue != 42 {
+			t.Errorf("%s: Skip stopped at offset %d instead of %d", test.name, s.offset-2, len(test.term))
+		}
+	}
+}
+
+func TestSkipTruncated(t *testing.T) {
+	s, err := NewScanner(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Peek(); err != io.EOF {
+		t.Errorf("Expected io.EOF from Peek at the end, got %v", err)
+	}
+	if err := s.Skip(); err != io.ErrUnexpectedEOF {
+		t.Errorf("Expected io.ErrUnexpectedEOF from Skip at the end, got %v", err)
+	}
+
+	// {[{<<"k">>, [1, <<"v">>]}]} and small big cut at every length
+	for _, term := range [][]byte{
+		{'h', 1, 'l', 0, 0, 0, 1, 'h', 2, 'm', 0, 0, 0, 1, 'k', 'l', 0, 0, 0, 2, 'a', 1, 'm', 0, 0, 0, 1, 'v', 'j', 'j'},
+		{'n', 5, 0, 0, 0, 0, 0, 1},
+		{'d', 0, 4, 't', 'r', 'u', 'e'},
+		{'F', 0x3f, 0xf8, 0, 0, 0, 0, 0, 0},
+	} {
+		for cut := 1; cut < len(term); cut++ {
+			s, err := NewScanner(term[:cut])
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = s.Skip()
+			if err != io.ErrUnexpectedEOF {
+				t.Errorf("Term %v cut at %d: expected io.ErrUnexpectedEOF, got %v", term, cut, err)
+			}
+			if s.offset > int64(cut) {
+				t.Errorf("Term %v cut at %d: offset %d past the input", term, cut, s.offset)
+			}
+		}
+	}
+}
+
+func TestSkipUnknownTerm(t *testing.T) {
+	s, err := NewScanner([]byte{'z', 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Skip() == nil {
+		t.Error("Expected error for unknown term type")
+	}
+}
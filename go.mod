@@ -5,7 +5,10 @@ go 1.13
 require (
 	github.com/golang/snappy v0.0.1
 	github.com/spf13/cobra v0.0.5
+	github.com/xitongsys/parquet-go v1.5.4
+	github.com/xitongsys/parquet-go-source v0.0.0-20200817004010-026bad9b25d0
 	go.uber.org/atomic v1.4.0 // indirect
 	go.uber.org/multierr v1.1.0 // indirect
 	go.uber.org/zap v1.10.0
+	modernc.org/sqlite v1.10.6
 )
@@ -0,0 +1,170 @@
+package jsonser
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/pipedrive/uncouch/erldeser"
+)
+
+// Fields is tree of document fields to render. Field without children is
+// rendered whole, field with children only with the listed subfields.
+type Fields struct {
+	children map[string]*Fields
+}
+
+// ParseFields builds Fields from dotted paths like "a" and "b.c". Path
+// covering another one wins, so "b" and "b.c" render whole "b".
+func ParseFields(paths []string) *Fields {
+	root := &Fields{children: make(map[string]*Fields)}
+	for _, path := range paths {
+		if path == "" {
+			continue
+		}
+		node := root
+		segments := strings.Split(path, ".")
+		for i, segment := range segments {
+			child, ok := node.children[segment]
+			if ok && child.children == nil {
+				// Whole field was already requested
+				break
+			}
+			if i == len(segments)-1 {
+				node.children[segment] = &Fields{}
+				break
+			}
+			if !ok {
+				child = &Fields{children: make(map[string]*Fields)}
+				node.children[segment] = child
+			}
+			node = child
+		}
+	}
+	return root
+}
+
+// SetFields limits rendered document to given fields, terms of the other
+// fields are skipped without decoding. Nil renders the whole document.
+func (js *JSONSer) SetFields(fields *Fields) {
+	js.fields = fields
+}
+
+// readProjectedObject renders JSON object keeping only requested fields.
+// Objects without any of the requested fields are left out by the caller,
+// so it returns false when nothing was rendered. Values which are not
+// objects are skipped.
+func (js *JSONSer) readProjectedObject(collector *bytes.Buffer, fields *Fields) (bool, error) {
+	termType, err := js.s.Peek()
+	if err != nil {
+		slog.Error(err)
+		return false, err
+	}
+	if termType != erldeser.SmallTupleExt {
+		err := js.s.Skip()
+		if err != nil {
+			slog.Error(err)
+			return false, err
+		}
+		return false, nil
+	}
+	t := js.getTerm()
+	defer js.putTerm(t)
+	// {[{Key, Value}, ...]} or {[]} for empty object
+	err = js.s.Scan(t)
+	if err != nil {
+		slog.Error(err)
+		return false, err
+	}
+	err = js.s.Scan(t)
+	if err != nil {
+		slog.Error(err)
+		return false, err
+	}
+	if t.Term == erldeser.NilExt {
+		return false, nil
+	}
+	if t.Term != erldeser.ListExt {
+		err := fmt.Errorf("Erlang serialised JSON object should start as tuple containing list, we got %v", t.Term)
+		slog.Error(err)
+		return false, err
+	}
+	length := t.IntegerValue
+	start := collector.Len()
+	collector.WriteString("{")
+	rendered := false
+	for i := int64(0); i < length; i++ {
+		// {Key, Value}
+		err = js.s.Scan(t)
+		if err != nil {
+			slog.Error(err)
+			return false, err
+		}
+		if t.Term != erldeser.SmallTupleExt {
+			err := fmt.Errorf("Erlang serialised JSON key-value pair should be inside tuple, we got %v", t.Term)
+			slog.Error(err)
+			return false, err
+		}
+		err = js.s.Scan(t)
+		if err != nil {
+			slog.Error(err)
+			return false, err
+		}
+		if t.Term != erldeser.BinaryExt {
+			err := fmt.Errorf("Erlang serialised JSON key should be binary, we got %v", t.Term)
+			slog.Error(err)
+			return false, err
+		}
+		child, ok := fields.children[string(t.Binary)]
+		if !ok {
+			err = js.s.Skip()
+			if err != nil {
+				slog.Error(err)
+				return false, err
+			}
+			continue
+		}
+		mark := collector.Len()
+		if rendered {
+			collector.WriteString(",")
+		}
+		collector.WriteString("\"")
+		collector.Write(t.Binary)
+		collector.WriteString("\":")
+		if child.children == nil {
+			err = js.readJSONValue(collector)
+			if err != nil {
+				slog.Error(err)
+				return false, err
+			}
+			rendered = true
+			continue
+		}
+		ok, err = js.readProjectedObject(collector, child)
+		if err != nil {
+			slog.Error(err)
+			return false, err
+		}
+		if !ok {
+			collector.Truncate(mark)
+			continue
+		}
+		rendered = true
+	}
+	err = js.s.Scan(t)
+	if err != nil {
+		slog.Error(err)
+		return false, err
+	}
+	if t.Term != erldeser.NilExt {
+		err := fmt.Errorf("Erlang serialised list should end with extra nil, but ends with %v", t.Term)
+		slog.Error(err)
+		return false, err
+	}
+	if !rendered {
+		collector.Truncate(start)
+		return false, nil
+	}
+	collector.WriteString("}")
+	return true, nil
+}
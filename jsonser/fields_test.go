@@ -0,0 +1,246 @@
+package jsonser
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/pipedrive/uncouch/erldeser"
+)
+
+// object is JSON object with ordered keys
+type object [][2]interface{}
+
+// encode serialises value the way CouchDB stores JSON bodies, without
+// the magic number: objects as {[{Key, Value}]}, strings as binaries
+func encode(buf *bytes.Buffer, v interface{}) {
+	switch value := v.(type) {
+	case nil:
+		buf.Write([]byte{'s', 4, 'n', 'u', 'l', 'l'})
+	case bool:
+		name := fmt.Sprint(value)
+		buf.Write([]byte{'s', byte(len(name))})
+		buf.WriteString(name)
+	case int:
+		switch {
+		case value >= 0 && value < 256:
+			buf.Write([]byte{'a', byte(value)})
+		case value >= 0 && value <= math.MaxInt32:
+			buf.WriteByte('b')
+			binary.Write(buf, binary.BigEndian, uint32(value))
+		default:
+			digits := make([]byte, 0, 8)
+			for n := value; n > 0; n >>= 8 {
+				digits = append(digits, byte(n))
+			}
+			buf.Write([]byte{'n', byte(len(digits)), 0})
+			buf.Write(digits)
+		}
+	case float64:
+		buf.WriteByte('F')
+		binary.Write(buf, binary.BigEndian, math.Float64bits(value))
+	case string:
+		buf.WriteByte('m')
+		binary.Write(buf, binary.BigEndian, uint32(len(value)))
+		buf.WriteString(value)
+	case []interface{}:
+		if len(value) > 0 {
+			buf.WriteByte('l')
+			binary.Write(buf, binary.BigEndian, uint32(len(value)))
+			for _, item := range value {
+				encode(buf, item)
+			}
+		}
+		buf.WriteByte('j')
+	case object:
+		buf.Write([]byte{'h', 1})
+		if len(value) > 0 {
+			buf.WriteByte('l')
+			binary.Write(buf, binary.BigEndian, uint32(len(value)))
+			for _, kv := range value {
+				buf.Write([]byte{'h', 2})
+				encode(buf, kv[0])
+				encode(buf, kv[1])
+			}
+		}
+		buf.WriteByte('j')
+	default:
+		panic(fmt.Sprintf("Can not encode %T", v))
+	}
+}
+
+// render renders serialised body with given fields
+func render(tb testing.TB, input []byte, fields *Fields) []byte {
+	s, err := erldeser.NewScanner(input)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	js, err := New(s)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	js.SetFields(fields)
+	var output bytes.Buffer
+	err = js.WriteJSONToBuffer(&output)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return output.Bytes()
+}
+
+func TestParseFields(t *testing.T) {
+	fields := ParseFields([]string{"a", "b.c", "b.d.e", "f.g", "f", "", "h.i"})
+	got := describe(fields)
+	want := "{a b{c d{e}} f h{i}}"
+	if got != want {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+}
+
+// describe prints fields tree with children in sorted order
+func describe(f *Fields) string {
+	if f.children == nil {
+		return ""
+	}
+	var buf bytes.Buffer
+	buf.WriteString("{")
+	for i, name := range sortedKeys(f.children) {
+		if i > 0 {
+			buf.WriteString(" ")
+		}
+		buf.WriteString(name + describe(f.children[name]))
+	}
+	buf.WriteString("}")
+	return buf.String()
+}
+
+// sortedKeys returns names of the child fields in sorted order
+func sortedKeys(m map[string]*Fields) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func TestProjection(t *testing.T) {
+	doc := object{
+		{"a", 1},
+		{"b", object{{"c", "x"}, {"d", []interface{}{1, "y", object{{"z", 2}}}}}},
+		{"e", "skipped"},
+		{"f", object{{"g", true}}},
+		{"big", 1 << 40},
+		{"int", 100000},
+		{"float", 1.5},
+		{"none", nil},
+		{"empty", object{}},
+		{"list", []interface{}{object{{"k", false}}}},
+	}
+	var input bytes.Buffer
+	encode(&input, doc)
+
+	tests := []struct {
+		fields []string
+		want   string
+	}{
+		{[]string{"a"}, `{"a":1}`},
+		{[]string{"b.c"}, `{"b":{"c":"x"}}`},
+		{[]string{"b.c", "b"}, `{"b":{"c":"x","d":[1,"y",{"z":2}]}}`},
+		{[]string{"big", "int", "float", "none"}, `{"big":1099511627776,"int":100000,"float":1.5,"none":null}`},
+		{[]string{"f.g", "a", "empty"}, `{"a":1,"f":{"g":true},"empty":{}}`},
+		// Objects without requested fields and values which are not
+		// objects are left out
+		{[]string{"f.x"}, `{}`},
+		{[]string{"a.x", "e.y", "list.k"}, `{}`},
+		{[]string{"missing"}, `{}`},
+		{[]string{"b.missing", "a"}, `{"a":1}`},
+	}
+	for _, test := range tests {
+		got := render(t, input.Bytes(), ParseFields(test.fields))
+		if string(got) != test.want {
+			t.Errorf("Fields %v: expected %s, got %s", test.fields, test.want, got)
+		}
+	}
+
+	// Projection of every top level field gives the whole document
+	var names []string
+	for _, kv := range doc {
+		names = append(names, kv[0].(string))
+	}
+	var whole, projected interface{}
+	json.Unmarshal(render(t, input.Bytes(), nil), &whole)
+	json.Unmarshal(render(t, input.Bytes(), ParseFields(names)), &projected)
+	if whole == nil || !reflect.DeepEqual(whole, projected) {
+		t.Errorf("Expected %v, got %v", whole, projected)
+	}
+}
+
+func TestProjectionTruncated(t *testing.T) {
+	var input bytes.Buffer
+	encode(&input, object{{"a", 1}, {"b", object{{"c", "x"}}}})
+	// afterKey returns offset right after the key binary
+	afterKey := func(key string) int {
+		return bytes.Index(input.Bytes(), append([]byte{'m', 0, 0, 0, byte(len(key))}, key...)) + 5 + len(key)
+	}
+	tests := []struct {
+		cut    int
+		fields []string
+	}{
+		// Empty body
+		{0, []string{"a"}},
+		// Skipped value is missing or cut
+		{afterKey("a"), []string{"x"}},
+		{afterKey("a") + 1, []string{"x"}},
+		// Projected object is missing
+		{afterKey("b"), []string{"b.c"}},
+	}
+	for _, test := range tests {
+		s, err := erldeser.NewScanner(input.Bytes()[:test.cut])
+		if err != nil {
+			t.Fatal(err)
+		}
+		js, err := New(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		js.SetFields(ParseFields(test.fields))
+		var output bytes.Buffer
+		err = js.WriteJSONToBuffer(&output)
+		if err == nil {
+			t.Errorf("Body cut at %d with fields %v: expected error", test.cut, test.fields)
+		}
+	}
+}
+
+func BenchmarkProjection(b *testing.B) {
+	// Document with many fields where only few are requested
+	doc := object{{"type", "deal"}, {"value", 1500}}
+	for i := 0; i < 200; i++ {
+		doc = append(doc, [2]interface{}{fmt.Sprintf("field%d", i), object{
+			{"name", fmt.Sprintf("Field %d", i)},
+			{"values", []interface{}{i, float64(i) / 3, "text", nil}},
+		}})
+	}
+	var input bytes.Buffer
+	encode(&input, doc)
+	for _, bench := range []struct {
+		name   string
+		fields *Fields
+	}{
+		{"whole", nil},
+		{"fields", ParseFields([]string{"type", "value", "field100.name"})},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			b.SetBytes(int64(input.Len()))
+			for i := 0; i < b.N; i++ {
+				render(b, input.Bytes(), bench.fields)
+			}
+		})
+	}
+}
@@ -18,6 +18,7 @@ import (
 type JSONSer struct {
 	termPool []*erlterm.Term
 	s        *erldeser.Scanner
+	fields   *Fields
 }
 
 // New will return JSON serialiser
@@ -53,6 +54,17 @@ func (js *JSONSer) putTerm(t *erlterm.Term) {
 
 // WriteJSONToBuffer writes Erlang serialised JSON to given buffer as normal JSON
 func (js *JSONSer) WriteJSONToBuffer(collector *bytes.Buffer) error {
+	if js.fields != nil {
+		rendered, err := js.readProjectedObject(collector, js.fields)
+		if err != nil {
+			slog.Error(err)
+			return err
+		}
+		if !rendered {
+			collector.WriteString("{}")
+		}
+		return nil
+	}
 	err := js.readJSONValue(collector)
 	if err != nil {
 		slog.Error(err)
@@ -0,0 +1,153 @@
+package mango
+
+import (
+	"sort"
+	"strings"
+)
+
+// Values of different types are ordered the way CouchDB collates them
+const (
+	rankNull = iota
+	rankFalse
+	rankTrue
+	rankNumber
+	rankString
+	rankArray
+	rankObject
+	rankOther
+)
+
+// compare orders two JSON values, it returns negative number, zero or
+// positive number like strings.Compare. Values of different types compare
+// by type, so {"$gt": 1000} matches any string like it does in CouchDB.
+// Strings are compared by bytes instead of ICU collation.
+func compare(a, b interface{}) int {
+	ra, rb := rank(a), rank(b)
+	if ra != rb {
+		return ra - rb
+	}
+	switch ra {
+	case rankNumber:
+		x, _ := number(a)
+		y, _ := number(b)
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+		return 0
+	case rankString:
+		return strings.Compare(a.(string), b.(string))
+	case rankArray:
+		x, _ := asArray(a)
+		y, _ := asArray(b)
+		for i := 0; i < len(x) && i < len(y); i++ {
+			if c := compare(x[i], y[i]); c != 0 {
+				return c
+			}
+		}
+		return len(x) - len(y)
+	case rankObject:
+		return compareObjects(a.(map[string]interface{}), b.(map[string]interface{}))
+	}
+	return 0
+}
+
+// compareObjects compares objects field by field in key order
+func compareObjects(a, b map[string]interface{}) int {
+	keys := func(m map[string]interface{}) []string {
+		list := make([]string, 0, len(m))
+		for k := range m {
+			list = append(list, k)
+		}
+		sort.Strings(list)
+		return list
+	}
+	ka, kb := keys(a), keys(b)
+	for i := 0; i < len(ka) && i < len(kb); i++ {
+		if c := strings.Compare(ka[i], kb[i]); c != 0 {
+			return c
+		}
+		if c := compare(a[ka[i]], b[kb[i]]); c != 0 {
+			return c
+		}
+	}
+	return len(ka) - len(kb)
+}
+
+// rank returns collation rank of the value type
+func rank(v interface{}) int {
+	switch value := v.(type) {
+	case nil:
+		return rankNull
+	case bool:
+		if value {
+			return rankTrue
+		}
+		return rankFalse
+	case string:
+		return rankString
+	case map[string]interface{}:
+		return rankObject
+	}
+	if _, ok := number(v); ok {
+		return rankNumber
+	}
+	if _, ok := asArray(v); ok {
+		return rankArray
+	}
+	return rankOther
+}
+
+// typeName returns type name used by $type operator
+func typeName(v interface{}) string {
+	switch rank(v) {
+	case rankNull:
+		return "null"
+	case rankFalse, rankTrue:
+		return "boolean"
+	case rankNumber:
+		return "number"
+	case rankString:
+		return "string"
+	case rankArray:
+		return "array"
+	case rankObject:
+		return "object"
+	}
+	return ""
+}
+
+// number converts numeric value to float64. Documents decoded from JSON
+// hold float64, metadata fields added by callers may use integer types.
+func number(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case int:
+		return float64(n), true
+	case int8:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	}
+	return 0, false
+}
+
+// asArray returns elements of array value
+func asArray(v interface{}) ([]interface{}, bool) {
+	switch list := v.(type) {
+	case []interface{}:
+		return list, true
+	case []string:
+		values := make([]interface{}, len(list))
+		for i, s := range list {
+			values[i] = s
+		}
+		return values, true
+	}
+	return nil, false
+}
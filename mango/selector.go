@@ -0,0 +1,355 @@
+// Package mango matches decoded documents against CouchDB Mango selectors,
+// the query language of CouchDB _find, so the same queries can be run
+// against .couch files.
+package mango
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// matcher reports if document, or array element for $elemMatch, matches
+type matcher func(doc interface{}) bool
+
+// predicate tests value of the field, ok is false when field is missing
+type predicate func(value interface{}, ok bool) bool
+
+// Selector is parsed Mango selector
+type Selector struct {
+	match matcher
+}
+
+// Parse parses JSON selector like {"type":"deal","value":{"$gt":1000}}
+func Parse(data []byte) (*Selector, error) {
+	var raw interface{}
+	err := json.Unmarshal(data, &raw)
+	if err != nil {
+		err = fmt.Errorf("Invalid selector JSON: %v", err)
+		slog.Error(err)
+		return nil, err
+	}
+	m, ok := raw.(map[string]interface{})
+	if !ok {
+		err := fmt.Errorf("Selector must be JSON object")
+		slog.Error(err)
+		return nil, err
+	}
+	for k := range m {
+		if strings.HasPrefix(k, "$") && !isCombination(k) {
+			err := fmt.Errorf("Operator %s can only be used on a field", k)
+			slog.Error(err)
+			return nil, err
+		}
+	}
+	match, err := parseSelector(m, nil)
+	if err != nil {
+		slog.Error(err)
+		return nil, err
+	}
+	var newSelector Selector
+	s := &newSelector
+	s.match = match
+	return s, nil
+}
+
+// Match reports if document matches the selector
+func (s *Selector) Match(doc map[string]interface{}) bool {
+	return s.match(doc)
+}
+
+// isCombination reports if operator combines selectors
+func isCombination(op string) bool {
+	switch op {
+	case "$and", "$or", "$nor", "$not":
+		return true
+	}
+	return false
+}
+
+// parseSelector parses selector object applied to the field at path. Field
+// names add to the path, operators test value found at the path and all
+// entries of the object have to match.
+func parseSelector(m map[string]interface{}, path []string) (matcher, error) {
+	matchers := make([]matcher, 0, len(m))
+	for k, v := range m {
+		var (
+			match matcher
+			err   error
+		)
+		switch {
+		case isCombination(k):
+			match, err = parseCombination(k, v, path)
+		case strings.HasPrefix(k, "$"):
+			var pred predicate
+			pred, err = parseOperator(k, v)
+			if err == nil {
+				match = fieldMatcher(path, pred)
+			}
+		default:
+			fieldPath := append(append([]string{}, path...), splitField(k)...)
+			if sub, ok := v.(map[string]interface{}); ok && len(sub) > 0 {
+				match, err = parseSelector(sub, fieldPath)
+			} else {
+				match = fieldMatcher(fieldPath, eq(v))
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		matchers = append(matchers, match)
+	}
+	return allOf(matchers), nil
+}
+
+// parseCombination parses $and, $or, $nor and $not
+func parseCombination(op string, arg interface{}, path []string) (matcher, error) {
+	if op == "$not" {
+		m, ok := arg.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("Operator $not expects selector object")
+		}
+		match, err := parseSelector(m, path)
+		if err != nil {
+			return nil, err
+		}
+		return func(doc interface{}) bool { return !match(doc) }, nil
+	}
+	list, ok := arg.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("Operator %s expects list of selectors", op)
+	}
+	matchers := make([]matcher, len(list))
+	for i, item := range list {
+		m, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("Operator %s expects list of selectors", op)
+		}
+		var err error
+		matchers[i], err = parseSelector(m, path)
+		if err != nil {
+			return nil, err
+		}
+	}
+	switch op {
+	case "$and":
+		return allOf(matchers), nil
+	case "$or":
+		return anyOf(matchers), nil
+	}
+	match := anyOf(matchers)
+	return func(doc interface{}) bool { return !match(doc) }, nil
+}
+
+// parseOperator parses condition operator testing single field
+func parseOperator(op string, arg interface{}) (predicate, error) {
+	switch op {
+	case "$eq":
+		return eq(arg), nil
+	case "$ne":
+		return func(v interface{}, ok bool) bool { return ok && compare(v, arg) != 0 }, nil
+	case "$gt":
+		return func(v interface{}, ok bool) bool { return ok && compare(v, arg) > 0 }, nil
+	case "$gte":
+		return func(v interface{}, ok bool) bool { return ok && compare(v, arg) >= 0 }, nil
+	case "$lt":
+		return func(v interface{}, ok bool) bool { return ok && compare(v, arg) < 0 }, nil
+	case "$lte":
+		return func(v interface{}, ok bool) bool { return ok && compare(v, arg) <= 0 }, nil
+	case "$exists":
+		exists, isBool := arg.(bool)
+		if !isBool {
+			return nil, fmt.Errorf("Operator $exists expects boolean")
+		}
+		return func(v interface{}, ok bool) bool { return ok == exists }, nil
+	case "$type":
+		name, isString := arg.(string)
+		if !isString {
+			return nil, fmt.Errorf("Operator $type expects string")
+		}
+		return func(v interface{}, ok bool) bool { return ok && typeName(v) == name }, nil
+	case "$in", "$nin", "$all":
+		list, isList := arg.([]interface{})
+		if !isList {
+			return nil, fmt.Errorf("Operator %s expects list", op)
+		}
+		return listPredicate(op, list), nil
+	case "$size":
+		size, isNumber := arg.(float64)
+		if !isNumber || size != float64(int(size)) {
+			return nil, fmt.Errorf("Operator $size expects integer")
+		}
+		return func(v interface{}, ok bool) bool {
+			values, isArray := asArray(v)
+			return ok && isArray && len(values) == int(size)
+		}, nil
+	case "$regex":
+		pattern, isString := arg.(string)
+		if !isString {
+			return nil, fmt.Errorf("Operator $regex expects string")
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid $regex pattern: %v", err)
+		}
+		return func(v interface{}, ok bool) bool {
+			s, isString := v.(string)
+			return ok && isString && re.MatchString(s)
+		}, nil
+	case "$elemMatch", "$allMatch":
+		m, isObject := arg.(map[string]interface{})
+		if !isObject {
+			return nil, fmt.Errorf("Operator %s expects selector object", op)
+		}
+		match, err := parseSelector(m, nil)
+		if err != nil {
+			return nil, err
+		}
+		return func(v interface{}, ok bool) bool {
+			values, isArray := asArray(v)
+			if !ok || !isArray || len(values) == 0 {
+				return false
+			}
+			for _, value := range values {
+				if match(value) != (op == "$allMatch") {
+					return op == "$elemMatch"
+				}
+			}
+			return op == "$allMatch"
+		}, nil
+	}
+	return nil, fmt.Errorf("Unknown operator %s", op)
+}
+
+// eq returns predicate matching values equal to arg
+func eq(arg interface{}) predicate {
+	return func(v interface{}, ok bool) bool { return ok && compare(v, arg) == 0 }
+}
+
+// listPredicate returns predicate of $in, $nin and $all. Array field
+// matches $in when any of its elements is in the list.
+func listPredicate(op string, list []interface{}) predicate {
+	contains := func(values []interface{}, v interface{}) bool {
+		for _, item := range values {
+			if compare(item, v) == 0 {
+				return true
+			}
+		}
+		return false
+	}
+	return func(v interface{}, ok bool) bool {
+		if !ok {
+			return false
+		}
+		values, isArray := asArray(v)
+		switch op {
+		case "$all":
+			if !isArray {
+				return false
+			}
+			for _, item := range list {
+				if !contains(values, item) {
+					return false
+				}
+			}
+			return true
+		case "$in":
+			if !isArray {
+				return contains(list, v)
+			}
+			for _, value := range values {
+				if contains(list, value) {
+					return true
+				}
+			}
+			return false
+		}
+		if !isArray {
+			return !contains(list, v)
+		}
+		for _, value := range values {
+			if contains(list, value) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// fieldMatcher returns matcher testing field at path with the predicate
+func fieldMatcher(path []string, pred predicate) matcher {
+	return func(doc interface{}) bool {
+		v, ok := lookup(doc, path)
+		return pred(v, ok)
+	}
+}
+
+// allOf returns matcher requiring all matchers to match
+func allOf(matchers []matcher) matcher {
+	return func(doc interface{}) bool {
+		for _, match := range matchers {
+			if !match(doc) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// anyOf returns matcher requiring at least one of matchers to match
+func anyOf(matchers []matcher) matcher {
+	return func(doc interface{}) bool {
+		for _, match := range matchers {
+			if match(doc) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// splitField splits dotted field name to path, "\." escapes the dot
+func splitField(field string) []string {
+	var (
+		path    []string
+		segment strings.Builder
+	)
+	for i := 0; i < len(field); i++ {
+		switch {
+		case field[i] == '\\' && i+1 < len(field) && field[i+1] == '.':
+			segment.WriteByte('.')
+			i++
+		case field[i] == '.':
+			path = append(path, segment.String())
+			segment.Reset()
+		default:
+			segment.WriteByte(field[i])
+		}
+	}
+	return append(path, segment.String())
+}
+
+// lookup returns value at path, numeric segments index arrays
+func lookup(doc interface{}, path []string) (interface{}, bool) {
+	v := doc
+	for _, segment := range path {
+		switch value := v.(type) {
+		case map[string]interface{}:
+			var ok bool
+			if v, ok = value[segment]; !ok {
+				return nil, false
+			}
+		case []interface{}:
+			i, err := strconv.Atoi(segment)
+			if err != nil || i < 0 || i >= len(value) {
+				return nil, false
+			}
+			v = value[i]
+		default:
+			return nil, false
+		}
+	}
+	return v, true
+}
@@ -0,0 +1,166 @@
+package mango
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// testDocs are documents the selectors are matched against
+var testDocs = []string{
+	`{"_id":"a","_deleted":false,"type":"deal","value":1500,"tags":["x","y"],"owner":{"name":"Ann","age":30}}`,
+	`{"_id":"b","_deleted":false,"type":"deal","value":200,"tags":["y"],"owner":{"name":"Bob"}}`,
+	`{"_id":"c","_deleted":false,"type":"user","value":"1000","tags":[],"items":[{"sku":"p1","qty":2},{"sku":"p2","qty":5}]}`,
+	`{"_id":"d","_deleted":true,"type":null,"items":[{"sku":"p1","qty":1}]}`,
+	`{"_id":"e","_deleted":false,"type":"deal","value":50.5,"a.b":1,"nested":{"list":[10,20]}}`,
+}
+
+// matching returns sorted IDs of test documents matching the selector
+func matching(t *testing.T, selector string) string {
+	s, err := Parse([]byte(selector))
+	if err != nil {
+		t.Fatalf("Selector %s: %v", selector, err)
+	}
+	var ids []string
+	for _, raw := range testDocs {
+		var doc map[string]interface{}
+		err := json.Unmarshal([]byte(raw), &doc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s.Match(doc) {
+			ids = append(ids, doc["_id"].(string))
+		}
+	}
+	sort.Strings(ids)
+	return strings.Join(ids, ",")
+}
+
+func TestOperators(t *testing.T) {
+	tests := []struct {
+		selector string
+		want     string
+	}{
+		// Implicit and explicit equality
+		{`{"type":"deal"}`, "a,b,e"},
+		{`{"type":{"$eq":"user"}}`, "c"},
+		{`{"type":null}`, "d"},
+		{`{"owner":{"name":"Ann"}}`, "a"},
+		{`{"owner.name":"Bob"}`, "b"},
+		{`{"a\\.b":1}`, "e"},
+		{`{"nested.list.1":20}`, "e"},
+		{`{"_deleted":true}`, "d"},
+		{`{"_deleted":{"$ne":true}}`, "a,b,c,e"},
+
+		// Comparison, values of other types compare by type
+		{`{"value":{"$gt":200}}`, "a,c"},
+		{`{"value":{"$gte":200}}`, "a,b,c"},
+		{`{"value":{"$lt":200}}`, "e"},
+		{`{"value":{"$lte":200}}`, "b,e"},
+		{`{"value":{"$gt":100,"$lt":1000}}`, "b"},
+		{`{"type":{"$ne":"deal"}}`, "c,d"},
+
+		// $exists, missing field only matches $exists false
+		{`{"owner":{"$exists":true}}`, "a,b"},
+		{`{"owner.age":{"$exists":false}}`, "b,c,d,e"},
+		{`{"value":{"$exists":false}}`, "d"},
+
+		// $type
+		{`{"value":{"$type":"number"}}`, "a,b,e"},
+		{`{"value":{"$type":"string"}}`, "c"},
+		{`{"type":{"$type":"null"}}`, "d"},
+		{`{"owner":{"$type":"object"}}`, "a,b"},
+		{`{"tags":{"$type":"array"}}`, "a,b,c"},
+		{`{"_deleted":{"$type":"boolean"}}`, "a,b,c,d,e"},
+
+		// Lists
+		{`{"type":{"$in":["user",null]}}`, "c,d"},
+		{`{"tags":{"$in":["x"]}}`, "a"},
+		{`{"type":{"$nin":["deal"]}}`, "c,d"},
+		{`{"tags":{"$nin":["x"]}}`, "b,c"},
+		{`{"tags":{"$all":["y"]}}`, "a,b"},
+		{`{"tags":{"$all":["x","y"]}}`, "a"},
+		{`{"type":{"$all":["deal"]}}`, ""},
+
+		// $size needs array of exact length
+		{`{"tags":{"$size":1}}`, "b"},
+		{`{"tags":{"$size":0}}`, "c"},
+		{`{"items":{"$size":2}}`, "c"},
+		{`{"type":{"$size":4}}`, ""},
+
+		// $regex only matches strings
+		{`{"owner.name":{"$regex":"^A"}}`, "a"},
+		{`{"value":{"$regex":"^1"}}`, "c"},
+
+		// $elemMatch needs one element matching all conditions
+		{`{"items":{"$elemMatch":{"sku":"p1","qty":{"$gt":1}}}}`, "c"},
+		{`{"items":{"$elemMatch":{"sku":"p2","qty":1}}}`, ""},
+		{`{"items":{"$elemMatch":{"qty":{"$lt":2}}}}`, "d"},
+		{`{"tags":{"$elemMatch":{"$eq":"x"}}}`, "a"},
+		{`{"nested.list":{"$elemMatch":{"$gt":15}}}`, "e"},
+		{`{"tags":{"$elemMatch":{"$eq":"z"}}}`, ""},
+		{`{"owner":{"$elemMatch":{"name":"Ann"}}}`, ""},
+
+		// $allMatch needs every element to match, empty array does not
+		{`{"items":{"$allMatch":{"qty":{"$gt":1}}}}`, "c"},
+		{`{"tags":{"$allMatch":{"$eq":"y"}}}`, "b"},
+
+		// Combinations
+		{`{"$and":[{"type":"deal"},{"value":{"$gt":100}}]}`, "a,b"},
+		{`{"$or":[{"type":"user"},{"value":{"$lt":100}}]}`, "c,e"},
+		{`{"$nor":[{"type":"deal"},{"type":"user"}]}`, "d"},
+		{`{"$not":{"type":"deal"}}`, "c,d"},
+		{`{"$not":{"owner.age":{"$exists":true}}}`, "b,c,d,e"},
+		{`{"owner":{"$not":{"name":"Ann"}}}`, "b,c,d,e"},
+		{`{"value":{"$not":{"$gt":200}}}`, "b,d,e"},
+		{`{"type":"deal","$not":{"$or":[{"value":200},{"value":50.5}]}}`, "a"},
+		{`{}`, "a,b,c,d,e"},
+	}
+	for _, test := range tests {
+		got := matching(t, test.selector)
+		if got != test.want {
+			t.Errorf("Selector %s: expected %q, got %q", test.selector, test.want, got)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, selector := range []string{
+		`not json`,
+		`[]`,
+		`{"$gt":1}`,
+		`{"a":{"$unknown":1}}`,
+		`{"a":{"$exists":"yes"}}`,
+		`{"a":{"$type":1}}`,
+		`{"a":{"$in":"x"}}`,
+		`{"a":{"$size":1.5}}`,
+		`{"a":{"$regex":"("}}`,
+		`{"a":{"$elemMatch":1}}`,
+		`{"$and":{"a":1}}`,
+		`{"$or":[1]}`,
+		`{"$not":[]}`,
+	} {
+		_, err := Parse([]byte(selector))
+		if err == nil {
+			t.Errorf("Selector %s: expected error", selector)
+		}
+	}
+}
+
+func TestCompare(t *testing.T) {
+	// CouchDB collation: null < false < true < numbers < strings < arrays < objects
+	ordered := []interface{}{
+		nil, false, true, -1.0, int64(2), 3.5, "", "a", "b",
+		[]interface{}{}, []interface{}{1.0}, []interface{}{1.0, 2.0}, []interface{}{"a"},
+		map[string]interface{}{}, map[string]interface{}{"a": 1.0}, map[string]interface{}{"a": 2.0}, map[string]interface{}{"b": 1.0},
+	}
+	for i := range ordered {
+		for j := range ordered {
+			c := compare(ordered[i], ordered[j])
+			if (i < j && c >= 0) || (i == j && c != 0) || (i > j && c <= 0) {
+				t.Errorf("compare(%v, %v) = %d", ordered[i], ordered[j], c)
+			}
+		}
+	}
+}
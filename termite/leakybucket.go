@@ -2,6 +2,7 @@ package termite
 
 import (
 	"fmt"
+	"sync/atomic"
 
 	"github.com/pipedrive/uncouch/erlterm"
 )
@@ -16,16 +17,17 @@ var (
 // GetProfilerData emits primitive profiler data bout our pool caching
 func GetProfilerData() string {
 	return fmt.Sprintf("putPoolSuccess %v, putPoolFailure %v, getPoolSuccess %v, getPoolFailure %v, termPoolListIncrements %v",
-		putPoolSuccess, putPoolFailure, getPoolSuccess, getPoolFailure, termPoolListIncrements)
+		atomic.LoadInt64(&putPoolSuccess), atomic.LoadInt64(&putPoolFailure), atomic.LoadInt64(&getPoolSuccess),
+		atomic.LoadInt64(&getPoolFailure), atomic.LoadInt64(&termPoolListIncrements))
 }
 
 // GetTermPool returns reusable Term pool in the hope to reduce GC stress
 func GetTermPool() (tp *[]*erlterm.Term) {
 	select {
 	case tp = <-freeTermPoolList:
-		getPoolSuccess++
+		atomic.AddInt64(&getPoolSuccess, 1)
 	default:
-		getPoolFailure++
+		atomic.AddInt64(&getPoolFailure, 1)
 		newPool := make([]*erlterm.Term, termPoolSize)
 		for i := range newPool {
 			newPool[i] = new(erlterm.Term)
@@ -43,10 +45,10 @@ func PutTermPool(tp *[]*erlterm.Term) {
 	}
 	select {
 	case freeTermPoolList <- tp:
-		putPoolSuccess++
+		atomic.AddInt64(&putPoolSuccess, 1)
 		// Term on free list; nothing more to do.
 	default:
-		putPoolFailure++
+		atomic.AddInt64(&putPoolFailure, 1)
 		// Free list full, just carry on.
 	}
 	return
@@ -56,7 +58,7 @@ func PutTermPool(tp *[]*erlterm.Term) {
 func (b *Builder) GetTerm() (t *erlterm.Term) {
 	if b.j >= termPoolSize {
 		b.termPools = append(b.termPools, GetTermPool())
-		termPoolListIncrements++
+		atomic.AddInt64(&termPoolListIncrements, 1)
 		b.i++
 		b.j = 0
 	}